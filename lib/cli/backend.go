@@ -0,0 +1,63 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	backendPrefix = "/api/admin/backend"
+)
+
+func GetBackendCmd(ctx *Context) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "backend",
+		Short: "",
+	}
+
+	// drain or undrain a backend
+	for _, action := range []string{"drain", "undrain"} {
+		action := action
+		drainCmd := &cobra.Command{
+			Use:  fmt.Sprintf("%s addr", action),
+			Args: cobra.ExactArgs(1),
+		}
+		namespace := drainCmd.Flags().String("namespace", "", "only apply to the namespace, empty means all namespaces")
+		drainCmd.RunE = func(cmd *cobra.Command, args []string) error {
+			path := fmt.Sprintf("%s/%s/%s", backendPrefix, url.PathEscape(args[0]), action)
+			if *namespace != "" {
+				path += "?namespace=" + url.QueryEscape(*namespace)
+			}
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, path, nil)
+			if err != nil {
+				return err
+			}
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(drainCmd)
+	}
+
+	// show the draining progress
+	rootCmd.AddCommand(
+		&cobra.Command{
+			Use: "drain-status",
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, backendPrefix+"/drain", nil)
+				if err != nil {
+					return err
+				}
+				cmd.Println(resp)
+				return nil
+			},
+		},
+	)
+
+	return rootCmd
+}
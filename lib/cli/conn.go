@@ -0,0 +1,82 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	connPrefix = "/api/admin/connections"
+)
+
+func GetConnCmd(ctx *Context) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "conn",
+		Short: "",
+	}
+
+	// list connections
+	{
+		listConns := &cobra.Command{
+			Use: "list",
+		}
+		namespace := listConns.Flags().String("namespace", "", "only list the connections of the namespace")
+		user := listConns.Flags().String("user", "", "only list the connections of the user")
+		backend := listConns.Flags().String("backend", "", "only list the connections on the backend")
+		listConns.RunE = func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			for key, val := range map[string]string{"namespace": *namespace, "user": *user, "backend": *backend} {
+				if val != "" {
+					query.Set(key, val)
+				}
+			}
+			path := connPrefix
+			if len(query) > 0 {
+				path += "?" + query.Encode()
+			}
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, path, nil)
+			if err != nil {
+				return err
+			}
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(listConns)
+	}
+
+	// close connections
+	{
+		killConn := &cobra.Command{
+			Use:   "kill connID",
+			Short: "close the connection after its transaction ends, or immediately with --force",
+			Args:  cobra.ExactArgs(1),
+		}
+		force := killConn.Flags().Bool("force", false, "close the connection immediately even if it's executing a command")
+		wait := killConn.Flags().Duration("wait", 0, "the duration to wait for the connection to be closed, 0 means the server default")
+		killConn.RunE = func(cmd *cobra.Command, args []string) error {
+			action := "close"
+			if *force {
+				action = "kill"
+			}
+			path := fmt.Sprintf("%s/%s/%s", connPrefix, url.PathEscape(args[0]), action)
+			if *wait > 0 {
+				path += "?wait=" + url.QueryEscape(wait.String())
+			}
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, path, nil)
+			if err != nil {
+				return err
+			}
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(killConn)
+	}
+
+	return rootCmd
+}
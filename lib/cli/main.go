@@ -84,5 +84,8 @@ func GetRootCmd(tlsConfig *tls.Config) *cobra.Command {
 	rootCmd.AddCommand(GetNamespaceCmd(ctx))
 	rootCmd.AddCommand(GetConfigCmd(ctx))
 	rootCmd.AddCommand(GetHealthCmd(ctx))
+	rootCmd.AddCommand(GetConnCmd(ctx))
+	rootCmd.AddCommand(GetBackendCmd(ctx))
+	rootCmd.AddCommand(GetRebalanceCmd(ctx))
 	return rootCmd
 }
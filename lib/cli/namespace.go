@@ -196,6 +196,39 @@ func GetNamespaceCmd(ctx *Context) *cobra.Command {
 		rootCmd.AddCommand(importNamespace)
 	}
 
+	// explain which namespace a connection matches
+	{
+		matchNamespace := &cobra.Command{
+			Use: "match",
+		}
+		user := matchNamespace.Flags().String("user", "", "user name of the connection")
+		db := matchNamespace.Flags().String("db", "", "initial database of the connection")
+		addr := matchNamespace.Flags().String("client-addr", "", "client address of the connection")
+		sni := matchNamespace.Flags().String("sni", "", "TLS server name of the connection")
+		attrs := matchNamespace.Flags().StringToString("attr", nil, "connection attributes, such as program_name=mysql")
+		matchNamespace.RunE = func(cmd *cobra.Command, _ []string) error {
+			info, err := json.Marshal(map[string]any{
+				"user":        *user,
+				"db":          *db,
+				"client-addr": *addr,
+				"attrs":       *attrs,
+				"sni":         *sni,
+			})
+			if err != nil {
+				return err
+			}
+
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, fmt.Sprintf("%s/match", namespacePrefix), bytes.NewReader(info))
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(matchNamespace)
+	}
+
 	// delete specific namespace
 	{
 		delNamespace := &cobra.Command{
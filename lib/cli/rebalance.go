@@ -0,0 +1,77 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	rebalancePrefix = "/api/admin/rebalance"
+)
+
+func GetRebalanceCmd(ctx *Context) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "rebalance",
+		Short: "show, pause, resume or preview the rebalancing of namespaces",
+	}
+
+	// show whether the rebalancing of each namespace is paused
+	rootCmd.AddCommand(
+		&cobra.Command{
+			Use: "status",
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, rebalancePrefix+"/", nil)
+				if err != nil {
+					return err
+				}
+				cmd.Println(resp)
+				return nil
+			},
+		},
+	)
+
+	// pause or resume the rebalancing of a namespace
+	for _, action := range []string{"pause", "resume"} {
+		action := action
+		rootCmd.AddCommand(
+			&cobra.Command{
+				Use:  fmt.Sprintf("%s namespace", action),
+				Args: cobra.ExactArgs(1),
+				RunE: func(cmd *cobra.Command, args []string) error {
+					path := fmt.Sprintf("%s/%s/%s", rebalancePrefix, url.PathEscape(args[0]), action)
+					resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, path, nil)
+					if err != nil {
+						return err
+					}
+					cmd.Println(resp)
+					return nil
+				},
+			},
+		)
+	}
+
+	// preview the migrations of the next round without making them
+	rootCmd.AddCommand(
+		&cobra.Command{
+			Use:  "preview namespace",
+			Args: cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				path := fmt.Sprintf("%s/%s/preview", rebalancePrefix, url.PathEscape(args[0]))
+				resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, path, nil)
+				if err != nil {
+					return err
+				}
+				cmd.Println(resp)
+				return nil
+			},
+		},
+	)
+
+	return rootCmd
+}
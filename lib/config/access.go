@@ -0,0 +1,60 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
+var (
+	ErrInvalidAccessList = errors.New("invalid access list")
+)
+
+// AccessList allows or denies the clients by their addresses, which are the source addresses in the proxy protocol
+// if it's enabled. The entries are CIDRs or IP addresses. Deny takes precedence over Allow. If Allow is not empty,
+// the clients that match none of its entries are denied.
+type AccessList struct {
+	Allow []string `yaml:"allow,omitempty" json:"allow,omitempty" toml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty" json:"deny,omitempty" toml:"deny,omitempty"`
+}
+
+// Empty returns true if the list allows any client.
+func (al *AccessList) Empty() bool {
+	return len(al.Allow) == 0 && len(al.Deny) == 0
+}
+
+// Validate checks the entries of the list.
+func (al *AccessList) Validate() error {
+	for _, entries := range [][]string{al.Allow, al.Deny} {
+		for _, entry := range entries {
+			if _, err := ParseCIDR(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseCIDR parses a CIDR or an IP address. An IP address is treated as a CIDR that contains only itself.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.Wrapf(ErrInvalidAccessList, "invalid IP %s", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidAccessList, "invalid CIDR %s", s)
+	}
+	return ipNet, nil
+}
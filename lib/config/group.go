@@ -0,0 +1,58 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
+var (
+	ErrInvalidBackendGroup = errors.New("invalid backend group")
+)
+
+// BackendGroup is a named pool of backends in a namespace, such as an OLTP pool and an analytics pool.
+// A backend belongs to the first group that selects it. The connections that match a rule with a group
+// are only routed and migrated to the backends of the group.
+type BackendGroup struct {
+	Name string `yaml:"name" json:"name" toml:"name"`
+	// Labels select the backends whose topology labels contain all of them.
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty" toml:"labels,omitempty"`
+	// Instances select the backends by addresses, which is used when the labels are unavailable.
+	Instances []string `yaml:"instances,omitempty" json:"instances,omitempty" toml:"instances,omitempty"`
+}
+
+// Match returns true if the group selects the backend.
+func (g *BackendGroup) Match(addr string, labels map[string]string) bool {
+	for _, instance := range g.Instances {
+		if instance == addr {
+			return true
+		}
+	}
+	if len(g.Labels) == 0 {
+		return false
+	}
+	for k, v := range g.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func validateBackendGroups(groups []BackendGroup) error {
+	names := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		if group.Name == "" {
+			return errors.Wrapf(ErrInvalidBackendGroup, "group name is empty")
+		}
+		if _, ok := names[group.Name]; ok {
+			return errors.Wrapf(ErrInvalidBackendGroup, "group %s is duplicated", group.Name)
+		}
+		names[group.Name] = struct{}{}
+		if len(group.Labels) == 0 && len(group.Instances) == 0 {
+			return errors.Wrapf(ErrInvalidBackendGroup, "group %s selects no backends", group.Name)
+		}
+	}
+	return nil
+}
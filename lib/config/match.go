@@ -0,0 +1,91 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"net"
+	"path"
+	"regexp"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
+var (
+	ErrInvalidMatchRule = errors.New("invalid namespace match rule")
+)
+
+// The fields of the client certificate that are compared with the user name.
+const (
+	CertUserCN  = "cn"
+	CertUserSAN = "san"
+	CertUserURI = "uri"
+)
+
+// MatchRule matches client connections to a namespace. All the specified conditions must be satisfied
+// and an empty condition matches anything.
+// The rules of all namespaces are evaluated by descending priorities. The rules with the same priority are
+// evaluated by namespace names and then by their order in the namespace.
+type MatchRule struct {
+	// Name is only used to explain the matching result.
+	Name     string `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty"`
+	Priority int    `yaml:"priority,omitempty" json:"priority,omitempty" toml:"priority,omitempty"`
+	// User is a glob pattern of the user name, such as `app_*`.
+	User string `yaml:"user,omitempty" json:"user,omitempty" toml:"user,omitempty"`
+	// UserRegex is a regular expression of the user name. It must match the whole name.
+	UserRegex string `yaml:"user-regex,omitempty" json:"user-regex,omitempty" toml:"user-regex,omitempty"`
+	// DB is a glob pattern of the initial database in the handshake.
+	DB string `yaml:"db,omitempty" json:"db,omitempty" toml:"db,omitempty"`
+	// CIDRs match the client address, which is the source address in the proxy protocol if it's enabled.
+	CIDRs []string `yaml:"cidrs,omitempty" json:"cidrs,omitempty" toml:"cidrs,omitempty"`
+	// Attrs are glob patterns of the connection attributes, such as `program_name`.
+	Attrs map[string]string `yaml:"attrs,omitempty" json:"attrs,omitempty" toml:"attrs,omitempty"`
+	// SNI is a glob pattern of the TLS server name sent by the client.
+	SNI string `yaml:"sni,omitempty" json:"sni,omitempty" toml:"sni,omitempty"`
+	// CertCN is a glob pattern of the subject common name of the client certificate.
+	CertCN string `yaml:"cert-cn,omitempty" json:"cert-cn,omitempty" toml:"cert-cn,omitempty"`
+	// CertSAN is a glob pattern that matches any DNS name, email address or IP address in the client certificate.
+	CertSAN string `yaml:"cert-san,omitempty" json:"cert-san,omitempty" toml:"cert-san,omitempty"`
+	// CertURI is a glob pattern that matches any URI in the client certificate, such as a SPIFFE ID.
+	// Note that `*` doesn't match `/`, so a pattern looks like `spiffe://example.org/ns/prod/sa/*`.
+	CertURI string `yaml:"cert-uri,omitempty" json:"cert-uri,omitempty" toml:"cert-uri,omitempty"`
+	// CertUser requires the user name to equal an identity in the client certificate. It's not a condition:
+	// the handshake is rejected if the rule matches but the identity doesn't. It's one of `cn`, `san` and `uri`.
+	// For `uri`, the user name is compared with the last path segment of the URI.
+	CertUser string `yaml:"cert-user,omitempty" json:"cert-user,omitempty" toml:"cert-user,omitempty"`
+	// Group pins the matched connections to a backend group of the namespace. It's not a condition.
+	Group string `yaml:"group,omitempty" json:"group,omitempty" toml:"group,omitempty"`
+}
+
+// Validate checks the patterns of the rule.
+func (r *MatchRule) Validate() error {
+	if r.User == "" && r.UserRegex == "" && r.DB == "" && len(r.CIDRs) == 0 && len(r.Attrs) == 0 && r.SNI == "" &&
+		r.CertCN == "" && r.CertSAN == "" && r.CertURI == "" {
+		return errors.Wrapf(ErrInvalidMatchRule, "rule %s has no conditions", r.Name)
+	}
+	patterns := []string{r.User, r.DB, r.SNI, r.CertCN, r.CertSAN, r.CertURI}
+	for _, v := range r.Attrs {
+		patterns = append(patterns, v)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(ErrInvalidMatchRule, "rule %s, pattern %s", r.Name, pattern)
+		}
+	}
+	if r.UserRegex != "" {
+		if _, err := regexp.Compile(r.UserRegex); err != nil {
+			return errors.Wrapf(ErrInvalidMatchRule, "rule %s, regex %s: %s", r.Name, r.UserRegex, err.Error())
+		}
+	}
+	for _, cidr := range r.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.Wrapf(ErrInvalidMatchRule, "rule %s, cidr %s", r.Name, cidr)
+		}
+	}
+	switch r.CertUser {
+	case "", CertUserCN, CertUserSAN, CertUserURI:
+	default:
+		return errors.Wrapf(ErrInvalidMatchRule, "rule %s, cert-user %s", r.Name, r.CertUser)
+	}
+	return nil
+}
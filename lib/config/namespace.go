@@ -5,9 +5,19 @@ package config
 
 import (
 	"bytes"
+	"encoding/json"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
+var (
+	ErrInvalidHealthCheck = errors.New("invalid health check config")
+	ErrInvalidWeight      = errors.New("invalid backend weight")
+	// ErrInvalidConsistentHash is returned when the hash key is unknown or the load factor is less than 1.
+	ErrInvalidConsistentHash = errors.New("invalid consistent hash config")
+	ErrInvalidSlowStart      = errors.New("invalid slow start duration")
 )
 
 type Namespace struct {
@@ -19,13 +29,50 @@ type Namespace struct {
 type FrontendNamespace struct {
 	User     string    `yaml:"user" json:"user" toml:"user"`
 	Security TLSConfig `yaml:"security" json:"security" toml:"security"`
+	// Rules are evaluated if no namespace matches the user exactly.
+	Rules []MatchRule `yaml:"rules,omitempty" json:"rules,omitempty" toml:"rules,omitempty"`
+	// RequireClientCert rejects the connections of the namespace that don't present a client certificate.
+	// The server TLS config must specify a CA for the clients to send certificates.
+	RequireClientCert bool `yaml:"require-client-cert,omitempty" json:"require-client-cert,omitempty" toml:"require-client-cert,omitempty"`
+	// AccessList is checked for the connections of the namespace after the global access list.
+	AccessList AccessList `yaml:"access-list" json:"access-list" toml:"access-list"`
 }
 
 type BackendNamespace struct {
 	Instances    []string  `yaml:"instances" json:"instances" toml:"instances"`
 	SelectorType string    `yaml:"selector-type" json:"selector-type" toml:"selector-type"`
 	Security     TLSConfig `yaml:"security" json:"security" toml:"security"`
-	//HealthCheck  HealthCheck `yaml:"health-check" json:"health-check" toml:"health-check"`
+	// Weights are keyed by backend addresses. The default weight is 1.
+	// The weighted selector routes connections in proportion to the weights and the score selector
+	// balances the connections per unit of weight.
+	Weights map[string]int `yaml:"weights,omitempty" json:"weights,omitempty" toml:"weights,omitempty"`
+	// WeightLabel is the key of the topology label that specifies the weight of a backend.
+	// The weights in Weights take precedence over the label.
+	WeightLabel string `yaml:"weight-label,omitempty" json:"weight-label,omitempty" toml:"weight-label,omitempty"`
+	// Groups split the backends into pools. The connections that match a rule with a group are pinned to the group.
+	Groups []BackendGroup `yaml:"groups,omitempty" json:"groups,omitempty" toml:"groups,omitempty"`
+	// SlowStart is the warm-up duration of a backend after it becomes healthy again or joins.
+	// Its capacity rises gradually during warm-up so that it's not flooded with connections while its caches are cold.
+	// Zero disables warm-up. It only works for the score selector.
+	SlowStart time.Duration `yaml:"slow-start,omitempty" json:"slow-start,omitempty" toml:"slow-start,omitempty"`
+	// HealthCheck is nil if it's not specified, and then the default config is used.
+	HealthCheck *HealthCheck `yaml:"health-check,omitempty" json:"health-check,omitempty" toml:"health-check,omitempty"`
+	// SQLTimeout is nil if it's not specified, and then the statements are not limited.
+	SQLTimeout *SQLTimeout `yaml:"sql-timeout,omitempty" json:"sql-timeout,omitempty" toml:"sql-timeout,omitempty"`
+	// CircuitBreaker is nil if it's not specified, and then the circuit breaker is disabled.
+	CircuitBreaker *CircuitBreaker `yaml:"circuit-breaker,omitempty" json:"circuit-breaker,omitempty" toml:"circuit-breaker,omitempty"`
+	// ConsistentHash is only used by the consistent-hash selector. If it's nil, the client IP is hashed.
+	ConsistentHash *ConsistentHash `yaml:"consistent-hash,omitempty" json:"consistent-hash,omitempty" toml:"consistent-hash,omitempty"`
+}
+
+// GetHealthCheck returns a copy of the health check config with defaults filled.
+func (cfg *BackendNamespace) GetHealthCheck() *HealthCheck {
+	if cfg.HealthCheck == nil {
+		return NewDefaultHealthCheckConfig()
+	}
+	hc := *cfg.HealthCheck
+	hc.Check()
+	return &hc
 }
 
 const (
@@ -33,6 +80,8 @@ const (
 	healthCheckMaxRetries    = 3
 	healthCheckRetryInterval = 1 * time.Second
 	healthCheckTimeout       = 2 * time.Second
+	loadConsecutiveFailures  = 3
+	loadConsecutiveSuccesses = 3
 )
 
 // HealthCheck contains some configurations for health check.
@@ -44,6 +93,35 @@ type HealthCheck struct {
 	MaxRetries    int           `yaml:"max-retries" json:"max-retries" toml:"max-retries"`
 	RetryInterval time.Duration `yaml:"retry-interval" json:"retry-interval" toml:"retry-interval"`
 	DialTimeout   time.Duration `yaml:"dial-timeout" json:"dial-timeout" toml:"dial-timeout"`
+	// Load is checked by scraping the metrics of the backends after they pass the health check.
+	Load LoadThreshold `yaml:"load,omitempty" json:"load,omitempty" toml:"load,omitempty"`
+}
+
+// LoadThreshold marks a backend as busy when its load exceeds any threshold, and then the router
+// prefers other backends. Zero means the signal is not checked.
+type LoadThreshold struct {
+	// MemoryUsage is the resident memory of the backend in bytes. Exceeding it marks the backend as memory high.
+	MemoryUsage int64 `yaml:"memory-usage" json:"memory-usage" toml:"memory-usage"`
+	// CPUUsage is the percentage of CPU usage to the GOMAXPROCS of the backend. Exceeding it marks the backend as run slow.
+	CPUUsage int `yaml:"cpu-usage" json:"cpu-usage" toml:"cpu-usage"`
+	// QueryDuration is the P99 query duration of the backend. Exceeding it marks the backend as run slow.
+	QueryDuration time.Duration `yaml:"query-duration" json:"query-duration" toml:"query-duration"`
+	// Connections is the number of connections in the status API of the backend. Exceeding it marks the backend as run slow.
+	Connections int `yaml:"connections" json:"connections" toml:"connections"`
+	// ConsecutiveFailures is the number of consecutive checks that exceed any threshold before the backend is marked busy.
+	ConsecutiveFailures int `yaml:"consecutive-failures" json:"consecutive-failures" toml:"consecutive-failures"`
+	// ConsecutiveSuccesses is the number of consecutive checks within all thresholds before the busy backend is marked healthy.
+	ConsecutiveSuccesses int `yaml:"consecutive-successes" json:"consecutive-successes" toml:"consecutive-successes"`
+}
+
+// Enabled returns true if any threshold is set.
+func (lt *LoadThreshold) Enabled() bool {
+	return lt.MemoryUsage > 0 || lt.CPUUsage > 0 || lt.QueryDuration > 0 || lt.Connections > 0
+}
+
+// NeedMetrics returns true if any threshold is checked by the metrics of the backend.
+func (lt *LoadThreshold) NeedMetrics() bool {
+	return lt.MemoryUsage > 0 || lt.CPUUsage > 0 || lt.QueryDuration > 0
 }
 
 // NewDefaultHealthCheckConfig creates a default HealthCheck.
@@ -54,9 +132,24 @@ func NewDefaultHealthCheckConfig() *HealthCheck {
 		MaxRetries:    healthCheckMaxRetries,
 		RetryInterval: healthCheckRetryInterval,
 		DialTimeout:   healthCheckTimeout,
+		Load: LoadThreshold{
+			ConsecutiveFailures:  loadConsecutiveFailures,
+			ConsecutiveSuccesses: loadConsecutiveSuccesses,
+		},
 	}
 }
 
+// UnmarshalJSON enables the health check if `enable` is omitted.
+func (hc *HealthCheck) UnmarshalJSON(data []byte) error {
+	type healthCheck HealthCheck
+	v := healthCheck{Enable: true}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*hc = HealthCheck(v)
+	return nil
+}
+
 func (hc *HealthCheck) Check() {
 	if hc.Interval == 0 {
 		hc.Interval = healthCheckInterval
@@ -70,13 +163,188 @@ func (hc *HealthCheck) Check() {
 	if hc.DialTimeout == 0 {
 		hc.DialTimeout = healthCheckTimeout
 	}
+	if hc.Load.ConsecutiveFailures == 0 {
+		hc.Load.ConsecutiveFailures = loadConsecutiveFailures
+	}
+	if hc.Load.ConsecutiveSuccesses == 0 {
+		hc.Load.ConsecutiveSuccesses = loadConsecutiveSuccesses
+	}
+}
+
+// Validate returns an error if any duration, retry count or threshold is negative.
+// Zero values are valid because they are replaced by the defaults in Check().
+func (hc *HealthCheck) Validate() error {
+	if hc.Interval < 0 || hc.MaxRetries < 0 || hc.RetryInterval < 0 || hc.DialTimeout < 0 ||
+		hc.Load.MemoryUsage < 0 || hc.Load.CPUUsage < 0 || hc.Load.QueryDuration < 0 || hc.Load.Connections < 0 ||
+		hc.Load.ConsecutiveFailures < 0 || hc.Load.ConsecutiveSuccesses < 0 {
+		return errors.Wrapf(ErrInvalidHealthCheck, "%+v", *hc)
+	}
+	return nil
+}
+
+// SQLTimeout is the maximum duration of each statement. The proxy kills the statement once it exceeds the timeout.
+// Zero means no limit.
+type SQLTimeout struct {
+	Timeout time.Duration `yaml:"timeout" json:"timeout" toml:"timeout"`
+	// Users override the timeout for specific users.
+	Users map[string]time.Duration `yaml:"users,omitempty" json:"users,omitempty" toml:"users,omitempty"`
+}
+
+// GetTimeout returns the timeout of the user.
+func (st *SQLTimeout) GetTimeout(user string) time.Duration {
+	if timeout, ok := st.Users[user]; ok {
+		return timeout
+	}
+	return st.Timeout
+}
+
+const (
+	breakerMinRequests      = 10
+	breakerWindow           = 10 * time.Second
+	breakerHalfOpenRequests = 1
+)
+
+// CircuitBreaker rejects new connections quickly when connecting to the backends keeps failing.
+// It's validated when the namespace is built.
+type CircuitBreaker struct {
+	// FailureRateThreshold is the percentage of dial and handshake failures in a window that opens the breaker.
+	FailureRateThreshold int `yaml:"failure-rate-threshold" json:"failure-rate-threshold" toml:"failure-rate-threshold"`
+	// MinRequests is the minimum number of results in a window to calculate the failure rate.
+	MinRequests int `yaml:"min-requests" json:"min-requests" toml:"min-requests"`
+	// Window is the duration to calculate the failure rate.
+	Window time.Duration `yaml:"window" json:"window" toml:"window"`
+	// OpenStatusDurationMs is how long the breaker stays open before it allows some requests to probe the backends.
+	OpenStatusDurationMs int64 `yaml:"open-status-duration-ms" json:"open-status-duration-ms" toml:"open-status-duration-ms"`
+	// HalfOpenRequests is the number of successful requests in the half-open state to close the breaker.
+	HalfOpenRequests int `yaml:"half-open-requests" json:"half-open-requests" toml:"half-open-requests"`
+}
+
+// Check fills the unspecified fields with defaults.
+func (cb *CircuitBreaker) Check() {
+	if cb.MinRequests <= 0 {
+		cb.MinRequests = breakerMinRequests
+	}
+	if cb.Window <= 0 {
+		cb.Window = breakerWindow
+	}
+	if cb.HalfOpenRequests <= 0 {
+		cb.HalfOpenRequests = breakerHalfOpenRequests
+	}
+}
+
+// The keys that the consistent-hash selector hashes connections by.
+const (
+	HashKeyClientIP = "client-ip"
+	HashKeyUser     = "user"
+	HashKeyDB       = "db"
+	HashKeyAttr     = "attr"
+)
+
+const hashLoadFactor = 1.25
+
+// ConsistentHash routes the connections with the same key to the same backend as long as the backend is available
+// and not overloaded, so that the sessions of an application benefit from the caches of the backend.
+type ConsistentHash struct {
+	// Key is one of client-ip, user, db and attr. The default is client-ip.
+	Key string `yaml:"key" json:"key" toml:"key"`
+	// Attr is the name of the connection attribute to hash when the key is attr.
+	Attr string `yaml:"attr,omitempty" json:"attr,omitempty" toml:"attr,omitempty"`
+	// LoadFactor bounds the connections of each backend to LoadFactor times the average.
+	// When a backend reaches the bound, its keys overflow to the next backends on the ring. The default is 1.25.
+	LoadFactor float64 `yaml:"load-factor" json:"load-factor" toml:"load-factor"`
+}
+
+// GetConsistentHash returns a copy of the consistent hash config with defaults filled.
+func (cfg *BackendNamespace) GetConsistentHash() *ConsistentHash {
+	var ch ConsistentHash
+	if cfg.ConsistentHash != nil {
+		ch = *cfg.ConsistentHash
+	}
+	if ch.Key == "" {
+		ch.Key = HashKeyClientIP
+	}
+	if ch.LoadFactor == 0 {
+		ch.LoadFactor = hashLoadFactor
+	}
+	return &ch
+}
+
+// Validate returns an error if the key is unknown or the load factor is less than 1.
+func (ch *ConsistentHash) Validate() error {
+	switch ch.Key {
+	case "", HashKeyClientIP, HashKeyUser, HashKeyDB:
+	case HashKeyAttr:
+		if ch.Attr == "" {
+			return errors.Wrapf(ErrInvalidConsistentHash, "the attr name is required when the key is %s", HashKeyAttr)
+		}
+	default:
+		return errors.Wrapf(ErrInvalidConsistentHash, "unknown key %s", ch.Key)
+	}
+	if ch.LoadFactor != 0 && ch.LoadFactor < 1 {
+		return errors.Wrapf(ErrInvalidConsistentHash, "load factor %v is less than 1", ch.LoadFactor)
+	}
+	return nil
+}
+
+// Check validates the namespace config.
+func (cfg *Namespace) Check() error {
+	if cfg.Backend.HealthCheck != nil {
+		if err := cfg.Backend.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Frontend.Rules {
+		if err := cfg.Frontend.Rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+	if err := cfg.Frontend.AccessList.Validate(); err != nil {
+		return err
+	}
+	for addr, weight := range cfg.Backend.Weights {
+		if weight <= 0 {
+			return errors.Wrapf(ErrInvalidWeight, "backend %s, weight %d", addr, weight)
+		}
+	}
+	if err := validateBackendGroups(cfg.Backend.Groups); err != nil {
+		return err
+	}
+	if cfg.Backend.SlowStart < 0 {
+		return errors.Wrapf(ErrInvalidSlowStart, "slow start %s is negative", cfg.Backend.SlowStart)
+	}
+	if cfg.Backend.ConsistentHash != nil {
+		if err := cfg.Backend.ConsistentHash.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, rule := range cfg.Frontend.Rules {
+		if rule.Group == "" {
+			continue
+		}
+		found := false
+		for _, group := range cfg.Backend.Groups {
+			if group.Name == rule.Group {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Wrapf(ErrInvalidMatchRule, "rule %s, group %s doesn't exist", rule.Name, rule.Group)
+		}
+	}
+	return nil
 }
 
 func NewNamespace(data []byte) (*Namespace, error) {
 	var cfg Namespace
-	if err := toml.Unmarshal(data, &cfg); err != nil {
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
 		return nil, err
 	}
+	// The health check is enabled if `enable` is omitted.
+	if cfg.Backend.HealthCheck != nil && !md.IsDefined("backend", "health-check", "enable") {
+		cfg.Backend.HealthCheck.Enable = true
+	}
 	return &cfg, nil
 }
 
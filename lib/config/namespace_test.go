@@ -4,7 +4,9 @@
 package config
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -41,3 +43,156 @@ func TestNamespaceConfig(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, data1, data2)
 }
+
+func TestHealthCheckConfig(t *testing.T) {
+	// The default config is used if it's not specified.
+	backend := BackendNamespace{}
+	require.NoError(t, (&Namespace{Backend: backend}).Check())
+	require.Equal(t, NewDefaultHealthCheckConfig(), backend.GetHealthCheck())
+
+	// The unspecified fields are filled with defaults, and the health check is enabled if `enable` is omitted.
+	nsc, err := NewNamespace([]byte("[backend.health-check]\nmax-retries = 5"))
+	require.NoError(t, err)
+	backend = nsc.Backend
+	require.NoError(t, (&Namespace{Backend: backend}).Check())
+	hc := backend.GetHealthCheck()
+	require.True(t, hc.Enable)
+	require.Equal(t, 5, hc.MaxRetries)
+	require.Equal(t, healthCheckInterval, hc.Interval)
+	require.Equal(t, healthCheckTimeout, hc.DialTimeout)
+	require.Equal(t, loadConsecutiveFailures, hc.Load.ConsecutiveFailures)
+	// GetHealthCheck returns a copy.
+	require.Zero(t, backend.HealthCheck.Interval)
+
+	nsc, err = NewNamespace([]byte("[backend.health-check]\nenable = false"))
+	require.NoError(t, err)
+	require.False(t, nsc.Backend.GetHealthCheck().Enable)
+	require.NoError(t, json.Unmarshal([]byte(`{"interval": 60000000000}`), &backend.HealthCheck))
+	require.True(t, backend.GetHealthCheck().Enable)
+	require.NoError(t, json.Unmarshal([]byte(`{"enable": false}`), &backend.HealthCheck))
+	require.False(t, backend.GetHealthCheck().Enable)
+
+	backend.HealthCheck = &HealthCheck{Enable: true, MaxRetries: -1}
+	require.ErrorIs(t, (&Namespace{Backend: backend}).Check(), ErrInvalidHealthCheck)
+	backend.HealthCheck = &HealthCheck{Enable: true, DialTimeout: -time.Second}
+	require.ErrorIs(t, (&Namespace{Backend: backend}).Check(), ErrInvalidHealthCheck)
+	backend.HealthCheck = &HealthCheck{Enable: true, Load: LoadThreshold{CPUUsage: -1}}
+	require.ErrorIs(t, (&Namespace{Backend: backend}).Check(), ErrInvalidHealthCheck)
+	backend.HealthCheck = &HealthCheck{Enable: true, Load: LoadThreshold{ConsecutiveFailures: -1}}
+	require.ErrorIs(t, (&Namespace{Backend: backend}).Check(), ErrInvalidHealthCheck)
+}
+
+func TestMatchRuleConfig(t *testing.T) {
+	valid := []MatchRule{
+		{User: "app_*"},
+		{UserRegex: "svc[0-9]+", DB: "db?"},
+		{CIDRs: []string{"10.0.0.0/8", "::1/128"}},
+		{Attrs: map[string]string{"program_name": "mysql*"}, SNI: "*.tiproxy"},
+		{CertCN: "app-*", CertUser: CertUserCN},
+		{CertURI: "spiffe://example.org/ns/*", CertUser: CertUserURI},
+	}
+	for i, rule := range valid {
+		ns := Namespace{Frontend: FrontendNamespace{Rules: []MatchRule{rule}}}
+		require.NoError(t, ns.Check(), "case %d", i)
+	}
+
+	invalid := []MatchRule{
+		{Name: "empty"},
+		{User: "[app"},
+		{UserRegex: "svc(["},
+		{CIDRs: []string{"10.0.0.1"}},
+		{Attrs: map[string]string{"program_name": "[mysql"}},
+		{CertSAN: "[app"},
+		{CertUser: CertUserCN},
+		{CertCN: "app", CertUser: "email"},
+	}
+	for i, rule := range invalid {
+		ns := Namespace{Frontend: FrontendNamespace{Rules: []MatchRule{rule}}}
+		require.ErrorIs(t, ns.Check(), ErrInvalidMatchRule, "case %d", i)
+	}
+}
+
+func TestSQLTimeout(t *testing.T) {
+	st := SQLTimeout{
+		Timeout: time.Minute,
+		Users:   map[string]time.Duration{"u1": time.Second, "u2": 0},
+	}
+	require.Equal(t, time.Minute, st.GetTimeout("root"))
+	require.Equal(t, time.Second, st.GetTimeout("u1"))
+	// A zero timeout of a user means no limit.
+	require.Equal(t, time.Duration(0), st.GetTimeout("u2"))
+}
+
+func TestBackendGroupConfig(t *testing.T) {
+	groups := []BackendGroup{
+		{Name: "oltp", Instances: []string{"127.0.0.1:4000"}},
+		{Name: "olap", Labels: map[string]string{"pool": "olap"}},
+	}
+	ns := Namespace{
+		Frontend: FrontendNamespace{Rules: []MatchRule{{User: "report_*", Group: "olap"}}},
+		Backend:  BackendNamespace{Groups: groups},
+	}
+	require.NoError(t, ns.Check())
+	require.True(t, groups[0].Match("127.0.0.1:4000", nil))
+	require.True(t, groups[1].Match("127.0.0.1:4001", map[string]string{"pool": "olap", "zone": "z1"}))
+	require.False(t, groups[1].Match("127.0.0.1:4001", map[string]string{"zone": "z1"}))
+
+	ns.Frontend.Rules[0].Group = "unknown"
+	require.ErrorIs(t, ns.Check(), ErrInvalidMatchRule)
+
+	invalid := [][]BackendGroup{
+		{{Name: "", Instances: []string{"127.0.0.1:4000"}}},
+		{{Name: "g"}},
+		{{Name: "g", Instances: []string{"127.0.0.1:4000"}}, {Name: "g", Instances: []string{"127.0.0.1:4001"}}},
+	}
+	for i, groups := range invalid {
+		ns := Namespace{Backend: BackendNamespace{Groups: groups}}
+		require.ErrorIs(t, ns.Check(), ErrInvalidBackendGroup, "case %d", i)
+	}
+}
+
+func TestConsistentHashConfig(t *testing.T) {
+	backend := BackendNamespace{}
+	require.Equal(t, &ConsistentHash{Key: HashKeyClientIP, LoadFactor: hashLoadFactor}, backend.GetConsistentHash())
+	backend.ConsistentHash = &ConsistentHash{Key: HashKeyAttr, Attr: "app"}
+	require.Equal(t, &ConsistentHash{Key: HashKeyAttr, Attr: "app", LoadFactor: hashLoadFactor}, backend.GetConsistentHash())
+	ns := Namespace{Backend: backend}
+	require.NoError(t, ns.Check())
+
+	invalid := []ConsistentHash{
+		{Key: "unknown"},
+		{Key: HashKeyAttr},
+		{Key: HashKeyUser, LoadFactor: 0.5},
+	}
+	for i, ch := range invalid {
+		ch := ch
+		ns := Namespace{Backend: BackendNamespace{ConsistentHash: &ch}}
+		require.ErrorIs(t, ns.Check(), ErrInvalidConsistentHash, "case %d", i)
+	}
+}
+
+func TestSlowStartConfig(t *testing.T) {
+	ns := Namespace{Backend: BackendNamespace{SlowStart: time.Minute}}
+	require.NoError(t, ns.Check())
+	ns.Backend.SlowStart = -time.Second
+	require.ErrorIs(t, ns.Check(), ErrInvalidSlowStart)
+}
+
+func TestAccessListConfig(t *testing.T) {
+	ns := Namespace{Frontend: FrontendNamespace{AccessList: AccessList{
+		Allow: []string{"10.0.0.0/8", "192.168.1.1", "::1"},
+		Deny:  []string{"10.1.0.0/16"},
+	}}}
+	require.NoError(t, ns.Check())
+	for _, entry := range []string{"10.0.0.0/33", "10.0.0", "localhost", ""} {
+		ns.Frontend.AccessList.Deny = []string{entry}
+		require.ErrorIs(t, ns.Check(), ErrInvalidAccessList, entry)
+	}
+
+	ipNet, err := ParseCIDR("192.168.1.1")
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/32", ipNet.String())
+	ipNet, err = ParseCIDR("::1")
+	require.NoError(t, err)
+	require.Equal(t, "::1/128", ipNet.String())
+}
@@ -17,16 +17,20 @@ import (
 
 var (
 	ErrUnsupportedProxyProtocolVersion = errors.New("unsupported proxy protocol version")
+	ErrInvalidRebalance                = errors.New("invalid rebalance config")
+	ErrInvalidProxyAuth                = errors.New("invalid proxy auth config")
+	ErrUnsupportedAuthPlugin           = errors.New("unsupported default auth plugin")
 )
 
 type Config struct {
-	Proxy    ProxyServer `yaml:"proxy,omitempty" toml:"proxy,omitempty" json:"proxy,omitempty"`
-	API      API         `yaml:"api,omitempty" toml:"api,omitempty" json:"api,omitempty"`
-	Advance  Advance     `yaml:"advance,omitempty" toml:"advance,omitempty" json:"advance,omitempty"`
-	Workdir  string      `yaml:"workdir,omitempty" toml:"workdir,omitempty" json:"workdir,omitempty"`
-	Security Security    `yaml:"security,omitempty" toml:"security,omitempty" json:"security,omitempty"`
-	Metrics  Metrics     `yaml:"metrics,omitempty" toml:"metrics,omitempty" json:"metrics,omitempty"`
-	Log      Log         `yaml:"log,omitempty" toml:"log,omitempty" json:"log,omitempty"`
+	Proxy     ProxyServer `yaml:"proxy,omitempty" toml:"proxy,omitempty" json:"proxy,omitempty"`
+	API       API         `yaml:"api,omitempty" toml:"api,omitempty" json:"api,omitempty"`
+	Advance   Advance     `yaml:"advance,omitempty" toml:"advance,omitempty" json:"advance,omitempty"`
+	Workdir   string      `yaml:"workdir,omitempty" toml:"workdir,omitempty" json:"workdir,omitempty"`
+	Security  Security    `yaml:"security,omitempty" toml:"security,omitempty" json:"security,omitempty"`
+	Metrics   Metrics     `yaml:"metrics,omitempty" toml:"metrics,omitempty" json:"metrics,omitempty"`
+	Log       Log         `yaml:"log,omitempty" toml:"log,omitempty" json:"log,omitempty"`
+	Rebalance Rebalance   `yaml:"rebalance,omitempty" toml:"rebalance,omitempty" json:"rebalance,omitempty"`
 }
 
 type Metrics struct {
@@ -57,6 +61,19 @@ type ProxyServerOnline struct {
 	BackendUnhealthyKeepalive  KeepAlive `yaml:"backend-unhealthy-keepalive" toml:"backend-unhealthy-keepalive" json:"backend-unhealthy-keepalive"`
 	ProxyProtocol              string    `yaml:"proxy-protocol,omitempty" toml:"proxy-protocol,omitempty" json:"proxy-protocol,omitempty"`
 	GracefulWaitBeforeShutdown int       `yaml:"graceful-wait-before-shutdown,omitempty" toml:"graceful-wait-before-shutdown,omitempty" json:"graceful-wait-before-shutdown,omitempty"`
+	// EvacuateTxnTimeout is the seconds to wait for the transaction of a session after its backend is drained or removed.
+	// After that, the session is closed and its transaction is rolled back. 0 means waiting forever.
+	EvacuateTxnTimeout int `yaml:"evacuate-txn-timeout,omitempty" toml:"evacuate-txn-timeout,omitempty" json:"evacuate-txn-timeout,omitempty"`
+	// IdleDetachTimeout is the seconds that a session idles outside transactions before its backend connection is released.
+	// The session is restored on a new backend connection when the next command arrives. 0 means never detaching.
+	IdleDetachTimeout int `yaml:"idle-detach-timeout,omitempty" toml:"idle-detach-timeout,omitempty" json:"idle-detach-timeout,omitempty"`
+	// BackendPoolSize is the number of pre-established connections to keep for each backend. 0 disables the pool.
+	BackendPoolSize int `yaml:"backend-pool-size,omitempty" toml:"backend-pool-size,omitempty" json:"backend-pool-size,omitempty"`
+	// DefaultAuthPlugin is the auth plugin advertised to the clients in the initial handshake.
+	// Empty means mysql_native_password.
+	DefaultAuthPlugin string `yaml:"default-auth-plugin,omitempty" toml:"default-auth-plugin,omitempty" json:"default-auth-plugin,omitempty"`
+	// AccessList is checked for all the clients when they connect. The namespaces may have their own access lists.
+	AccessList AccessList `yaml:"access-list" toml:"access-list" json:"access-list"`
 }
 
 type ProxyServer struct {
@@ -64,6 +81,8 @@ type ProxyServer struct {
 	PDAddrs           string `yaml:"pd-addrs,omitempty" toml:"pd-addrs,omitempty" json:"pd-addrs,omitempty"`
 	ServerVersion     string `yaml:"server-version,omitempty" toml:"server-version,omitempty" json:"server-version,omitempty"`
 	RequireBackendTLS bool   `yaml:"require-backend-tls,omitempty" toml:"require-backend-tls,omitempty" json:"require-backend-tls,omitempty"`
+	// Labels are the topology labels of TiProxy. The label "zone" makes TiProxy prefer the TiDB instances in the same zone.
+	Labels            map[string]string `yaml:"labels,omitempty" toml:"labels,omitempty" json:"labels,omitempty"`
 	ProxyServerOnline `yaml:",inline" toml:",inline" json:",inline"`
 }
 
@@ -75,6 +94,24 @@ type API struct {
 	ProxyProtocol   string `yaml:"proxy-protocol,omitempty" toml:"proxy-protocol,omitempty" json:"proxy-protocol,omitempty"`
 }
 
+// Rebalance controls how the routers migrate connections between backends. It's updated online.
+// Zero means the default value unless stated otherwise.
+type Rebalance struct {
+	// Interval is the interval between 2 rounds of rebalancing.
+	Interval time.Duration `yaml:"interval,omitempty" toml:"interval,omitempty" json:"interval,omitempty"`
+	// ConnsPerLoop is the maximum number of connections to migrate in each round.
+	ConnsPerLoop int `yaml:"conns-per-loop,omitempty" toml:"conns-per-loop,omitempty" json:"conns-per-loop,omitempty"`
+	// MaxScoreRatio is the ratio of the highest score to the lowest score that triggers rebalancing.
+	// It must be greater than 1, otherwise the connections are migrated back and forth.
+	MaxScoreRatio float64 `yaml:"max-score-ratio,omitempty" toml:"max-score-ratio,omitempty" json:"max-score-ratio,omitempty"`
+	// MinDwellTime is the minimum duration that a connection stays on a backend after it's migrated
+	// before it's migrated again for balance. Zero means no limit.
+	MinDwellTime time.Duration `yaml:"min-dwell-time,omitempty" toml:"min-dwell-time,omitempty" json:"min-dwell-time,omitempty"`
+	// MaxMigrationsPerHour is the maximum number of times that a connection is migrated for balance in an hour.
+	// Zero means no limit.
+	MaxMigrationsPerHour int `yaml:"max-migrations-per-hour,omitempty" toml:"max-migrations-per-hour,omitempty" json:"max-migrations-per-hour,omitempty"`
+}
+
 type Advance struct {
 	IgnoreWrongNamespace bool `yaml:"ignore-wrong-namespace,omitempty" toml:"ignore-wrong-namespace,omitempty" json:"ignore-wrong-namespace,omitempty"`
 }
@@ -135,6 +172,106 @@ type Security struct {
 	PeerTLS    TLSConfig `yaml:"peer-tls,omitempty" toml:"peer-tls,omitempty" json:"peer-tls,omitempty"`
 	ClusterTLS TLSConfig `yaml:"cluster-tls,omitempty" toml:"cluster-tls,omitempty" json:"cluster-tls,omitempty"`
 	SQLTLS     TLSConfig `yaml:"sql-tls,omitempty" toml:"sql-tls,omitempty" json:"sql-tls,omitempty"`
+	ProxyAuth  ProxyAuth `yaml:"proxy-auth,omitempty" toml:"proxy-auth,omitempty" json:"proxy-auth,omitempty"`
+	// AuthRSAKey is the PEM file of the RSA private key that the clients without TLS use to encrypt the passwords
+	// of caching_sha2_password. A key is generated on startup if it's empty.
+	AuthRSAKey string `yaml:"auth-rsa-key,omitempty" toml:"auth-rsa-key,omitempty" json:"auth-rsa-key,omitempty"`
+}
+
+const (
+	ProxyAuthHtpasswd = "htpasswd"
+	ProxyAuthLDAP     = "ldap"
+	ProxyAuthJWT      = "jwt"
+	// redactedSecret replaces the secrets when the config is displayed.
+	redactedSecret = "******"
+)
+
+// ProxyAuth verifies the clients at TiProxy before connecting to TiDB.
+// After the client is verified, TiProxy logs into TiDB with the mapped service identity.
+type ProxyAuth struct {
+	// Provider is the way to verify the clients. Empty means forwarding the auth data to TiDB.
+	Provider string `yaml:"provider,omitempty" toml:"provider,omitempty" json:"provider,omitempty"`
+	// HtpasswdFile contains lines of `user:hash`. The hash is in bcrypt, sha256-crypt or sha512-crypt format.
+	HtpasswdFile string   `yaml:"htpasswd-file,omitempty" toml:"htpasswd-file,omitempty" json:"htpasswd-file,omitempty"`
+	LDAP         LDAPAuth `yaml:"ldap,omitempty" toml:"ldap,omitempty" json:"ldap,omitempty"`
+	JWT          JWTAuth  `yaml:"jwt,omitempty" toml:"jwt,omitempty" json:"jwt,omitempty"`
+	// Identities are the TiDB users that the verified users log in as.
+	Identities []ServiceIdentity `yaml:"identities,omitempty" toml:"identities,omitempty" json:"identities,omitempty"`
+}
+
+type LDAPAuth struct {
+	Addr string `yaml:"addr,omitempty" toml:"addr,omitempty" json:"addr,omitempty"`
+	// BindDN is the template of the DN to bind, where %s is replaced with the user name, e.g. "uid=%s,ou=people,dc=example,dc=com".
+	BindDN string `yaml:"bind-dn,omitempty" toml:"bind-dn,omitempty" json:"bind-dn,omitempty"`
+	// TLS connects to the LDAP server with TLS. It uses the cluster-tls config.
+	TLS bool `yaml:"tls,omitempty" toml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+type JWTAuth struct {
+	// Secret is the key of HMAC signatures.
+	Secret string `yaml:"secret,omitempty" toml:"secret,omitempty" json:"secret,omitempty"`
+	// PublicKeyFile is the PEM file of the RSA or ECDSA public key to verify signatures.
+	PublicKeyFile string `yaml:"public-key-file,omitempty" toml:"public-key-file,omitempty" json:"public-key-file,omitempty"`
+	Issuer        string `yaml:"issuer,omitempty" toml:"issuer,omitempty" json:"issuer,omitempty"`
+	Audience      string `yaml:"audience,omitempty" toml:"audience,omitempty" json:"audience,omitempty"`
+	// UserClaim is the claim that must equal the user name. It's "sub" by default.
+	UserClaim string `yaml:"user-claim,omitempty" toml:"user-claim,omitempty" json:"user-claim,omitempty"`
+}
+
+// ServiceIdentity is a TiDB user that the verified users log in as.
+type ServiceIdentity struct {
+	// Users are the verified users that are mapped to this identity. Empty means all the users that are not mapped.
+	Users    []string `yaml:"users,omitempty" toml:"users,omitempty" json:"users,omitempty"`
+	User     string   `yaml:"user,omitempty" toml:"user,omitempty" json:"user,omitempty"`
+	Password string   `yaml:"password,omitempty" toml:"password,omitempty" json:"password,omitempty"`
+}
+
+func (pa ProxyAuth) redact() ProxyAuth {
+	if pa.JWT.Secret != "" {
+		pa.JWT.Secret = redactedSecret
+	}
+	if len(pa.Identities) > 0 {
+		// Copy the slice so that the original config is not changed.
+		identities := make([]ServiceIdentity, 0, len(pa.Identities))
+		for _, identity := range pa.Identities {
+			if identity.Password != "" {
+				identity.Password = redactedSecret
+			}
+			identities = append(identities, identity)
+		}
+		pa.Identities = identities
+	}
+	return pa
+}
+
+func (pa *ProxyAuth) Check() error {
+	switch pa.Provider {
+	case "":
+		return nil
+	case ProxyAuthHtpasswd:
+		if pa.HtpasswdFile == "" {
+			return errors.Wrapf(ErrInvalidProxyAuth, "htpasswd-file is required")
+		}
+	case ProxyAuthLDAP:
+		if pa.LDAP.Addr == "" || strings.Count(pa.LDAP.BindDN, "%s") != 1 {
+			return errors.Wrapf(ErrInvalidProxyAuth, "ldap requires addr and bind-dn with one %%s")
+		}
+	case ProxyAuthJWT:
+		if pa.JWT.Secret == "" && pa.JWT.PublicKeyFile == "" {
+			return errors.Wrapf(ErrInvalidProxyAuth, "jwt requires secret or public-key-file")
+		}
+	default:
+		return errors.Wrapf(ErrInvalidProxyAuth, "unsupported provider %s", pa.Provider)
+	}
+	if len(pa.Identities) == 0 {
+		return errors.Wrapf(ErrInvalidProxyAuth, "identities are required")
+	}
+	for _, identity := range pa.Identities {
+		if identity.User == "" {
+			return errors.Wrapf(ErrInvalidProxyAuth, "the user of identities is required")
+		}
+	}
+	return nil
 }
 
 func DefaultKeepAlive() (frontend, backendHealthy, backendUnhealthy KeepAlive) {
@@ -182,6 +319,14 @@ func (cfg *Config) Clone() *Config {
 	return &newCfg
 }
 
+// Redact returns a copy of the config whose secrets are masked, so that the config can be displayed by the API or
+// printed in logs.
+func (cfg *Config) Redact() *Config {
+	newCfg := cfg.Clone()
+	newCfg.Security.ProxyAuth = cfg.Security.ProxyAuth.redact()
+	return newCfg
+}
+
 func (cfg *Config) Check() error {
 
 	if cfg.Workdir == "" {
@@ -198,6 +343,22 @@ func (cfg *Config) Check() error {
 	default:
 		return errors.Wrapf(ErrUnsupportedProxyProtocolVersion, "%s", cfg.Proxy.ProxyProtocol)
 	}
+	if cfg.Rebalance.Interval < 0 || cfg.Rebalance.ConnsPerLoop < 0 || cfg.Rebalance.MaxScoreRatio < 0 ||
+		cfg.Rebalance.MinDwellTime < 0 || cfg.Rebalance.MaxMigrationsPerHour < 0 ||
+		(cfg.Rebalance.MaxScoreRatio > 0 && cfg.Rebalance.MaxScoreRatio <= 1) {
+		return errors.Wrapf(ErrInvalidRebalance, "%+v", cfg.Rebalance)
+	}
+	switch cfg.Proxy.DefaultAuthPlugin {
+	case "", "mysql_native_password", "caching_sha2_password":
+	default:
+		return errors.Wrapf(ErrUnsupportedAuthPlugin, "%s", cfg.Proxy.DefaultAuthPlugin)
+	}
+	if err := cfg.Security.ProxyAuth.Check(); err != nil {
+		return err
+	}
+	if err := cfg.Proxy.AccessList.Validate(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/require"
@@ -21,11 +22,20 @@ var testProxyConfig = Config{
 		Addr:              "0.0.0.0:4000",
 		PDAddrs:           "127.0.0.1:4089",
 		RequireBackendTLS: true,
+		Labels:            map[string]string{"zone": "z1"},
 		ProxyServerOnline: ProxyServerOnline{
 			MaxConnections:             1,
 			FrontendKeepalive:          KeepAlive{Enabled: true},
 			ProxyProtocol:              "v2",
 			GracefulWaitBeforeShutdown: 10,
+			EvacuateTxnTimeout:         60,
+			IdleDetachTimeout:          300,
+			BackendPoolSize:            4,
+			DefaultAuthPlugin:          "caching_sha2_password",
+			AccessList: AccessList{
+				Allow: []string{"10.0.0.0/8"},
+				Deny:  []string{"10.0.0.1"},
+			},
 		},
 	},
 	API: API{
@@ -38,6 +48,13 @@ var testProxyConfig = Config{
 		MetricsAddr:     "127.0.0.1:9021",
 		MetricsInterval: 15,
 	},
+	Rebalance: Rebalance{
+		Interval:             time.Second,
+		ConnsPerLoop:         5,
+		MaxScoreRatio:        1.5,
+		MinDwellTime:         time.Minute,
+		MaxMigrationsPerHour: 10,
+	},
 	Log: Log{
 		Encoder: "tidb",
 		LogOnline: LogOnline{
@@ -77,6 +94,26 @@ var testProxyConfig = Config{
 			Cert:               "b",
 			Key:                "c",
 		},
+		AuthRSAKey: "rsa.pem",
+		ProxyAuth: ProxyAuth{
+			Provider:     ProxyAuthHtpasswd,
+			HtpasswdFile: "htpasswd",
+			LDAP: LDAPAuth{
+				Addr:   "127.0.0.1:389",
+				BindDN: "uid=%s,dc=example,dc=com",
+				TLS:    true,
+			},
+			JWT: JWTAuth{
+				Secret:    "s",
+				Issuer:    "i",
+				Audience:  "a",
+				UserClaim: "sub",
+			},
+			Identities: []ServiceIdentity{
+				{Users: []string{"u1"}, User: "svc1", Password: "p1"},
+				{User: "svc", Password: "p"},
+			},
+		},
 	},
 }
 
@@ -91,6 +128,19 @@ func TestProxyConfig(t *testing.T) {
 	require.Equal(t, data1, data2)
 }
 
+func TestRedact(t *testing.T) {
+	cfg := testProxyConfig.Redact()
+	require.Equal(t, "******", cfg.Security.ProxyAuth.JWT.Secret)
+	require.Len(t, cfg.Security.ProxyAuth.Identities, 2)
+	for _, identity := range cfg.Security.ProxyAuth.Identities {
+		require.Equal(t, "******", identity.Password)
+	}
+	require.Equal(t, testProxyConfig.Security.ProxyAuth.Identities[0].User, cfg.Security.ProxyAuth.Identities[0].User)
+	// The original config is not changed.
+	require.Equal(t, "s", testProxyConfig.Security.ProxyAuth.JWT.Secret)
+	require.Equal(t, "p1", testProxyConfig.Security.ProxyAuth.Identities[0].Password)
+}
+
 func TestProxyCheck(t *testing.T) {
 	testcases := []struct {
 		pre  func(*testing.T, *Config)
@@ -113,6 +163,55 @@ func TestProxyCheck(t *testing.T) {
 			},
 			err: ErrUnsupportedProxyProtocolVersion,
 		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Rebalance.MaxScoreRatio = 1
+			},
+			err: ErrInvalidRebalance,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Rebalance.ConnsPerLoop = -1
+			},
+			err: ErrInvalidRebalance,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Rebalance.MinDwellTime = -time.Second
+			},
+			err: ErrInvalidRebalance,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Proxy.DefaultAuthPlugin = "sha256_password"
+			},
+			err: ErrUnsupportedAuthPlugin,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.ProxyAuth.Provider = "unknown"
+			},
+			err: ErrInvalidProxyAuth,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.ProxyAuth.Provider = ProxyAuthLDAP
+				c.Security.ProxyAuth.LDAP.BindDN = "dc=example,dc=com"
+			},
+			err: ErrInvalidProxyAuth,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.ProxyAuth.Identities = nil
+			},
+			err: ErrInvalidProxyAuth,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Proxy.AccessList.Allow = []string{"10.0.0.0/x"}
+			},
+			err: ErrInvalidAccessList,
+		},
 	}
 	for _, tc := range testcases {
 		cfg := testProxyConfig
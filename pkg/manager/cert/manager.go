@@ -5,7 +5,13 @@ package cert
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,11 +25,11 @@ import (
 
 const (
 	defaultRetryInterval = 1 * time.Hour
+	authRSAKeySize       = 2048
 )
 
 // CertManager reloads certs and offers interfaces for fetching TLS configs.
-// Currently, all the namespaces share the same certs but there might be per-namespace
-// certs in the future.
+// The namespaces share the global certs unless they specify their own frontend or backend certs.
 type CertManager struct {
 	serverTLS        *security.CertInfo // client / proxyctl -> proxy
 	serverTLSConfig  atomic.Pointer[tls.Config]
@@ -33,6 +39,13 @@ type CertManager struct {
 	clusterTLSConfig atomic.Pointer[tls.Config]
 	sqlTLS           *security.CertInfo // proxy -> tidb sql port
 	sqlTLSConfig     atomic.Pointer[tls.Config]
+	// frontendTLSConfig is serverTLSConfig with the namespace certs, chosen by SNI.
+	frontendTLSConfig atomic.Pointer[tls.Config]
+	nsLock            sync.RWMutex
+	nsCerts           map[string]*NamespaceCerts
+	// authRSAKey is used by the clients without TLS to encrypt the passwords of caching_sha2_password.
+	authRSAKeyFile string
+	authRSAKey     atomic.Pointer[rsa.PrivateKey]
 
 	cancel        context.CancelFunc
 	wg            waitgroup.WaitGroup
@@ -71,6 +84,7 @@ func (cm *CertManager) setConfig(cfg *config.Config) {
 	cm.peerTLS.SetConfig(cfg.Security.PeerTLS)
 	cm.clusterTLS.SetConfig(cfg.Security.ClusterTLS)
 	cm.sqlTLS.SetConfig(cfg.Security.SQLTLS)
+	cm.authRSAKeyFile = cfg.Security.AuthRSAKey
 }
 
 func (cm *CertManager) SetRetryInterval(interval time.Duration) {
@@ -93,6 +107,10 @@ func (cm *CertManager) SQLTLS() *tls.Config {
 	return cm.sqlTLSConfig.Load()
 }
 
+func (cm *CertManager) AuthRSAKey() *rsa.PrivateKey {
+	return cm.authRSAKey.Load()
+}
+
 // The proxy is supposed to be always online, so it should reload certs automatically,
 // rather than reloading it by restarting the proxy.
 // The proxy periodically reloads certs. If it fails, we will retry in the next round.
@@ -142,6 +160,11 @@ func (cm *CertManager) reload() error {
 	} else {
 		cm.sqlTLSConfig.Store(tlsConfig)
 	}
+	if err := cm.reloadAuthRSAKey(); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, cm.reloadNamespaces()...)
+	cm.updateFrontendTLS()
 	var err error
 	if len(errs) > 0 {
 		metrics.ServerErrCounter.WithLabelValues("load_cert").Add(float64(len(errs)))
@@ -151,6 +174,50 @@ func (cm *CertManager) reload() error {
 	return err
 }
 
+// reloadAuthRSAKey loads the RSA key from the file. If the file is not configured, the key is generated once.
+func (cm *CertManager) reloadAuthRSAKey() error {
+	if cm.authRSAKeyFile == "" {
+		if cm.authRSAKey.Load() == nil {
+			key, err := rsa.GenerateKey(rand.Reader, authRSAKeySize)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			cm.authRSAKey.Store(key)
+		}
+		return nil
+	}
+	data, err := os.ReadFile(cm.authRSAKeyFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	key, err := parseRSAKey(data)
+	if err != nil {
+		return errors.Wrapf(err, "parse RSA key %s", cm.authRSAKeyFile)
+	}
+	cm.authRSAKey.Store(key)
+	return nil
+}
+
+// parseRSAKey parses a PEM RSA private key in either PKCS #1 or PKCS #8 form.
+func parseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return rsaKey, nil
+}
+
 func (cm *CertManager) Close() {
 	if cm.cancel != nil {
 		cm.cancel()
@@ -4,7 +4,11 @@
 package cert
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net"
@@ -419,3 +423,43 @@ func TestWatchConfig(t *testing.T) {
 		}, time.Second, 10*time.Millisecond)
 	}
 }
+
+func TestAuthRSAKey(t *testing.T) {
+	tmpdir := t.TempDir()
+	lg, _ := logger.CreateLoggerForTest(t)
+
+	// The key is generated if it's not configured and it stays the same after reloading.
+	certMgr := NewCertManager()
+	cfg := &config.Config{}
+	require.NoError(t, certMgr.Init(cfg, lg, nil))
+	key := certMgr.AuthRSAKey()
+	require.NotNil(t, key)
+	require.NoError(t, certMgr.reload())
+	require.Same(t, key, certMgr.AuthRSAKey())
+	certMgr.Close()
+
+	// The key is loaded from the file in PKCS #1 or PKCS #8 form.
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	blocks := []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+	}
+	keyPath := filepath.Join(tmpdir, "rsa.pem")
+	cfg.Security.AuthRSAKey = keyPath
+	for _, block := range blocks {
+		require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+		certMgr = NewCertManager()
+		require.NoError(t, certMgr.Init(cfg, lg, nil))
+		require.True(t, key.Equal(certMgr.AuthRSAKey()))
+		certMgr.Close()
+	}
+
+	// Invalid files are reported.
+	require.NoError(t, os.WriteFile(keyPath, []byte("dummy"), 0600))
+	certMgr = NewCertManager()
+	require.Error(t, certMgr.Init(cfg, lg, nil))
+	certMgr.Close()
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cert
+
+import (
+	"crypto/tls"
+	"sort"
+	"sync/atomic"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/lib/util/security"
+	"go.uber.org/zap"
+)
+
+// NamespaceCerts contains the certs of a namespace. A nil config means the global one is used.
+type NamespaceCerts struct {
+	frontendTLS       *security.CertInfo // client -> proxy, chosen by SNI
+	frontendTLSConfig atomic.Pointer[tls.Config]
+	backendTLS        *security.CertInfo // proxy -> tidb sql port
+	backendTLSConfig  atomic.Pointer[tls.Config]
+}
+
+func newNamespaceCerts(frontend, backend config.TLSConfig) *NamespaceCerts {
+	nc := &NamespaceCerts{
+		frontendTLS: security.NewCert(true),
+		backendTLS:  security.NewCert(false),
+	}
+	nc.frontendTLS.SetConfig(frontend)
+	nc.backendTLS.SetConfig(backend)
+	return nc
+}
+
+func (nc *NamespaceCerts) reload(lg *zap.Logger) []error {
+	errs := make([]error, 0, 2)
+	if tlsConfig, err := nc.frontendTLS.Reload(lg); err != nil {
+		errs = append(errs, err)
+	} else {
+		nc.frontendTLSConfig.Store(tlsConfig)
+	}
+	if tlsConfig, err := nc.backendTLS.Reload(lg); err != nil {
+		errs = append(errs, err)
+	} else {
+		nc.backendTLSConfig.Store(tlsConfig)
+	}
+	return errs
+}
+
+// SetNamespaceTLS sets the frontend and backend certs of a namespace and loads them.
+// The certs are removed if neither of them is configured.
+func (cm *CertManager) SetNamespaceTLS(ns string, frontend, backend config.TLSConfig) error {
+	nc, err := cm.LoadNamespaceTLS(ns, frontend, backend)
+	if err != nil {
+		return err
+	}
+	cm.ApplyNamespaceTLS(ns, nc)
+	return nil
+}
+
+// LoadNamespaceTLS loads the frontend and backend certs of a namespace without applying them.
+// It returns nil if neither of them is configured.
+func (cm *CertManager) LoadNamespaceTLS(ns string, frontend, backend config.TLSConfig) (*NamespaceCerts, error) {
+	if !frontend.HasCert() && !frontend.AutoCerts && !backend.HasCA() && !backend.SkipCA {
+		return nil, nil
+	}
+	nc := newNamespaceCerts(frontend, backend)
+	if errs := nc.reload(cm.logger.With(zap.String("namespace", ns))); len(errs) > 0 {
+		return nil, errors.Collect(errors.Errorf("loading certs of namespace %s", ns), errs...)
+	}
+	return nc, nil
+}
+
+// ApplyNamespaceTLS makes the certs returned by LoadNamespaceTLS take effect.
+// The certs of the namespace are removed if nc is nil.
+func (cm *CertManager) ApplyNamespaceTLS(ns string, nc *NamespaceCerts) {
+	if nc == nil {
+		cm.DelNamespaceTLS(ns)
+		return
+	}
+	cm.nsLock.Lock()
+	if cm.nsCerts == nil {
+		cm.nsCerts = make(map[string]*NamespaceCerts)
+	}
+	cm.nsCerts[ns] = nc
+	cm.nsLock.Unlock()
+	cm.updateFrontendTLS()
+}
+
+// DelNamespaceTLS removes the certs of a namespace.
+func (cm *CertManager) DelNamespaceTLS(ns string) {
+	cm.nsLock.Lock()
+	_, ok := cm.nsCerts[ns]
+	delete(cm.nsCerts, ns)
+	cm.nsLock.Unlock()
+	if ok {
+		cm.updateFrontendTLS()
+	}
+}
+
+// NamespaceBackendTLS returns the TLS config to connect to the backends of the namespace.
+// It returns nil if the namespace has no backend certs, and then SQLTLS() should be used.
+func (cm *CertManager) NamespaceBackendTLS(ns string) *tls.Config {
+	cm.nsLock.RLock()
+	defer cm.nsLock.RUnlock()
+	if nc, ok := cm.nsCerts[ns]; ok {
+		return nc.backendTLSConfig.Load()
+	}
+	return nil
+}
+
+// BackendTLS returns the latest TLS config to connect to the backends of the namespace.
+// It's the namespace backend config if it exists, otherwise SQLTLS().
+func (cm *CertManager) BackendTLS(ns string) *tls.Config {
+	if tlsConfig := cm.NamespaceBackendTLS(ns); tlsConfig != nil {
+		return tlsConfig
+	}
+	return cm.SQLTLS()
+}
+
+// FrontendTLS returns the TLS config for client connections. Different from ServerTLS(), it presents
+// the cert of a namespace if the SNI of the client matches the cert.
+// The namespace is unknown during the TLS handshake, so SNI is the only way to choose the cert.
+func (cm *CertManager) FrontendTLS() *tls.Config {
+	return cm.frontendTLSConfig.Load()
+}
+
+// updateFrontendTLS is called whenever the server certs or the namespace certs change.
+func (cm *CertManager) updateFrontendTLS() {
+	base := cm.serverTLSConfig.Load()
+	hasNamespaceCerts := false
+	cm.nsLock.RLock()
+	for _, nc := range cm.nsCerts {
+		if nc.frontendTLSConfig.Load() != nil {
+			hasNamespaceCerts = true
+			break
+		}
+	}
+	cm.nsLock.RUnlock()
+	if !hasNamespaceCerts {
+		cm.frontendTLSConfig.Store(base)
+		return
+	}
+
+	var tcfg *tls.Config
+	if base != nil {
+		tcfg = base.Clone()
+	} else {
+		// The clients without a matched SNI get the cert of the default namespace in getConfigForClient.
+		tcfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tcfg.GetConfigForClient = cm.getConfigForClient
+	cm.frontendTLSConfig.Store(tcfg)
+}
+
+// getConfigForClient returns the frontend config of the first namespace (sorted by name) whose cert matches the SNI.
+// Returning nil means using the global server config. If there's no global server cert, the first namespace is the
+// default, so that the clients without a matched SNI can still establish TLS connections.
+func (cm *CertManager) getConfigForClient(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+	cm.nsLock.RLock()
+	names := make([]string, 0, len(cm.nsCerts))
+	for name := range cm.nsCerts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	configs := make([]*tls.Config, 0, len(names))
+	for _, name := range names {
+		if tcfg := cm.nsCerts[name].frontendTLSConfig.Load(); tcfg != nil {
+			configs = append(configs, tcfg)
+		}
+	}
+	cm.nsLock.RUnlock()
+
+	if chi.ServerName != "" {
+		for _, tcfg := range configs {
+			cert, err := tcfg.GetCertificate(chi)
+			if err != nil || cert == nil {
+				continue
+			}
+			if chi.SupportsCertificate(cert) == nil {
+				return tcfg, nil
+			}
+		}
+	}
+	if cm.serverTLSConfig.Load() == nil && len(configs) > 0 {
+		return configs[0], nil
+	}
+	return nil, nil
+}
+
+// reloadNamespaces reloads the certs of all namespaces and returns the errors.
+func (cm *CertManager) reloadNamespaces() []error {
+	cm.nsLock.RLock()
+	var errs []error
+	for name, nc := range cm.nsCerts {
+		errs = append(errs, nc.reload(cm.logger.With(zap.String("namespace", name)))...)
+	}
+	cm.nsLock.RUnlock()
+	return errs
+}
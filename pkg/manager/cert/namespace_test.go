@@ -0,0 +1,157 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/logger"
+	"github.com/pingcap/TiProxy/lib/util/security"
+	"github.com/pingcap/TiProxy/lib/util/waitgroup"
+	"github.com/stretchr/testify/require"
+)
+
+// createDNSCert creates a self-signed cert for the DNS name.
+func createDNSCert(t *testing.T, dir, dnsName string) (certPath, keyPath string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	certPath, keyPath = filepath.Join(dir, dnsName+".crt"), filepath.Join(dir, dnsName+".key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	return
+}
+
+// handshakeWithSNI returns the DNS names of the cert that the server presents.
+func handshakeWithSNI(t *testing.T, stls *tls.Config, serverName string) ([]string, error) {
+	client, server := net.Pipe()
+	var wg waitgroup.WaitGroup
+	var dnsNames []string
+	var clientErr error
+	wg.Run(func() {
+		tlsConn := tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if clientErr = tlsConn.Handshake(); clientErr == nil {
+			dnsNames = tlsConn.ConnectionState().PeerCertificates[0].DNSNames
+		}
+		_ = client.Close()
+	})
+	wg.Run(func() {
+		_ = tls.Server(server, stls).Handshake()
+		_ = server.Close()
+	})
+	wg.Wait()
+	return dnsNames, clientErr
+}
+
+func TestNamespaceTLS(t *testing.T) {
+	tmpdir := t.TempDir()
+	lg, _ := logger.CreateLoggerForTest(t)
+	globalCert, globalKey := createDNSCert(t, tmpdir, "global.tiproxy")
+	nsCert, nsKey := createDNSCert(t, tmpdir, "ns1.tiproxy")
+	caPath := filepath.Join(tmpdir, "c1", "ca")
+	require.NoError(t, security.CreateTLSCertificates(lg, filepath.Join(tmpdir, "c1", "cert"), filepath.Join(tmpdir, "c1", "key"), caPath, 0, security.DefaultCertExpiration))
+
+	cfg := &config.Config{
+		Workdir: tmpdir,
+		Security: config.Security{
+			ServerTLS: config.TLSConfig{Cert: globalCert, Key: globalKey},
+		},
+	}
+	certMgr := NewCertManager()
+	require.NoError(t, certMgr.Init(cfg, lg, nil))
+	t.Cleanup(certMgr.Close)
+	require.Equal(t, certMgr.ServerTLS(), certMgr.FrontendTLS())
+
+	// A namespace without certs uses the global certs.
+	require.NoError(t, certMgr.SetNamespaceTLS("ns0", config.TLSConfig{}, config.TLSConfig{}))
+	require.Nil(t, certMgr.NamespaceBackendTLS("ns0"))
+	require.Equal(t, certMgr.SQLTLS(), certMgr.BackendTLS("ns0"))
+	require.Equal(t, certMgr.ServerTLS(), certMgr.FrontendTLS())
+
+	// Bad certs are rejected.
+	require.Error(t, certMgr.SetNamespaceTLS("ns1", config.TLSConfig{Cert: "unknown", Key: "unknown"}, config.TLSConfig{}))
+	require.Nil(t, certMgr.NamespaceBackendTLS("ns1"))
+
+	require.NoError(t, certMgr.SetNamespaceTLS("ns1", config.TLSConfig{Cert: nsCert, Key: nsKey}, config.TLSConfig{CA: caPath}))
+	require.NotNil(t, certMgr.NamespaceBackendTLS("ns1"))
+	require.Same(t, certMgr.NamespaceBackendTLS("ns1"), certMgr.BackendTLS("ns1"))
+	// The cert is chosen by SNI.
+	tests := []struct {
+		serverName string
+		dnsName    string
+	}{
+		{"ns1.tiproxy", "ns1.tiproxy"},
+		{"global.tiproxy", "global.tiproxy"},
+		{"unknown.tiproxy", "global.tiproxy"},
+		{"", "global.tiproxy"},
+	}
+	for _, test := range tests {
+		dnsNames, err := handshakeWithSNI(t, certMgr.FrontendTLS(), test.serverName)
+		require.NoError(t, err, test.serverName)
+		require.Equal(t, []string{test.dnsName}, dnsNames, test.serverName)
+	}
+
+	// The certs are also reloaded periodically.
+	require.NoError(t, certMgr.reload())
+
+	certMgr.DelNamespaceTLS("ns1")
+	require.Nil(t, certMgr.NamespaceBackendTLS("ns1"))
+	require.Equal(t, certMgr.ServerTLS(), certMgr.FrontendTLS())
+	dnsNames, err := handshakeWithSNI(t, certMgr.FrontendTLS(), "ns1.tiproxy")
+	require.NoError(t, err)
+	require.Equal(t, []string{"global.tiproxy"}, dnsNames)
+}
+
+// Test that TLS still works for the clients without SNI if only namespaces have certs.
+func TestNamespaceTLSWithoutServerCert(t *testing.T) {
+	tmpdir := t.TempDir()
+	lg, _ := logger.CreateLoggerForTest(t)
+	ns1Cert, ns1Key := createDNSCert(t, tmpdir, "ns1.tiproxy")
+	ns2Cert, ns2Key := createDNSCert(t, tmpdir, "ns2.tiproxy")
+
+	certMgr := NewCertManager()
+	require.NoError(t, certMgr.Init(&config.Config{Workdir: tmpdir}, lg, nil))
+	t.Cleanup(certMgr.Close)
+	require.Nil(t, certMgr.FrontendTLS())
+	require.NoError(t, certMgr.SetNamespaceTLS("ns2", config.TLSConfig{Cert: ns2Cert, Key: ns2Key}, config.TLSConfig{}))
+	require.NoError(t, certMgr.SetNamespaceTLS("ns1", config.TLSConfig{Cert: ns1Cert, Key: ns1Key}, config.TLSConfig{}))
+	require.NotNil(t, certMgr.FrontendTLS())
+
+	// The first namespace is the default.
+	tests := []struct {
+		serverName string
+		dnsName    string
+	}{
+		{"ns2.tiproxy", "ns2.tiproxy"},
+		{"unknown.tiproxy", "ns1.tiproxy"},
+		{"", "ns1.tiproxy"},
+	}
+	for _, test := range tests {
+		dnsNames, err := handshakeWithSNI(t, certMgr.FrontendTLS(), test.serverName)
+		require.NoError(t, err, test.serverName)
+		require.Equal(t, []string{test.dnsName}, dnsNames, test.serverName)
+	}
+}
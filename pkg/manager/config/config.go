@@ -60,7 +60,7 @@ func (e *ConfigManager) SetTOMLConfig(data []byte) (err error) {
 	e.sts.Lock()
 	defer func() {
 		if err == nil {
-			e.logger.Info("current config", zap.Any("cfg", e.sts.current))
+			e.logger.Info("current config", zap.Any("cfg", e.sts.current.Redact()))
 		}
 		e.sts.Unlock()
 	}()
@@ -5,6 +5,8 @@ package config
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"path/filepath"
 	"sync"
 	"time"
@@ -13,18 +15,19 @@ import (
 	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/errors"
 	"github.com/pingcap/TiProxy/lib/util/waitgroup"
-	"github.com/tidwall/btree"
 	"go.uber.org/zap"
 )
 
 const (
 	pathPrefixNamespace = "ns"
+	pathPrefixCommit    = "commit"
 	pathPrefixConfig    = "config"
 )
 
 var (
-	ErrNoResults   = errors.Errorf("has no results")
-	ErrFail2Update = errors.Errorf("failed to update")
+	ErrNoResults          = errors.Errorf("has no results")
+	ErrFail2Update        = errors.Errorf("failed to update")
+	ErrCloseConfigManager = errors.Errorf("failed to close config manager")
 )
 
 type KVValue struct {
@@ -36,8 +39,19 @@ type ConfigManager struct {
 	wg     waitgroup.WaitGroup
 	cancel context.CancelFunc
 	logger *zap.Logger
-
-	kv *btree.BTreeG[KVValue]
+	// id identifies the TiProxy instance in the namespace commits.
+	id string
+
+	kv struct {
+		sync.RWMutex
+		store KVStore
+		// cancel stops watching the store.
+		cancel context.CancelFunc
+	}
+	nsts struct {
+		sync.Mutex
+		listeners []chan<- *NamespaceEvent
+	}
 
 	wch     *fsnotify.Watcher
 	overlay []byte
@@ -50,7 +64,11 @@ type ConfigManager struct {
 }
 
 func NewConfigManager() *ConfigManager {
-	return &ConfigManager{}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return &ConfigManager{
+		id: hex.EncodeToString(id),
+	}
 }
 
 func (e *ConfigManager) Init(ctx context.Context, logger *zap.Logger, configFile string, overlay *config.Config) error {
@@ -60,10 +78,10 @@ func (e *ConfigManager) Init(ctx context.Context, logger *zap.Logger, configFile
 
 	e.logger = logger
 
-	// for namespace persistence
-	e.kv = btree.NewBTreeG(func(a, b KVValue) bool {
-		return a.Key < b.Key
-	})
+	// for namespace persistence, the store may be replaced by a persistent one later
+	if err = e.SetKVStore(newMemKVStore()); err != nil {
+		return errors.WithStack(err)
+	}
 
 	// for config watch
 	if overlay != nil {
@@ -136,6 +154,12 @@ func (e *ConfigManager) Close() error {
 		e.cancel()
 		e.cancel = nil
 	}
+	e.kv.Lock()
+	if e.kv.cancel != nil {
+		e.kv.cancel()
+		e.kv.cancel = nil
+	}
+	e.kv.Unlock()
 	if e.wch != nil {
 		wcherr = e.wch.Close()
 		e.wch = nil
@@ -146,6 +170,19 @@ func (e *ConfigManager) Close() error {
 	}
 	e.sts.listeners = nil
 	e.sts.Unlock()
+	e.nsts.Lock()
+	for _, ch := range e.nsts.listeners {
+		close(ch)
+	}
+	e.nsts.listeners = nil
+	e.nsts.Unlock()
 	e.wg.Wait()
-	return wcherr
+	var kverr error
+	e.kv.Lock()
+	if e.kv.store != nil {
+		kverr = e.kv.store.Close()
+		e.kv.store = nil
+	}
+	e.kv.Unlock()
+	return errors.Collect(ErrCloseConfigManager, wcherr, kverr)
 }
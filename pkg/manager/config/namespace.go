@@ -8,43 +8,133 @@ import (
 	"encoding/json"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
 )
 
-func (e *ConfigManager) get(ctx context.Context, ns, key string) (KVValue, error) {
-	nkey := path.Clean(path.Join(ns, key))
-	v, ok := e.kv.Get(KVValue{Key: nkey})
-	if !ok {
-		return v, errors.WithStack(errors.Wrapf(ErrNoResults, "key=%s", nkey))
-	}
-	return v, nil
+// NamespaceEvent is sent to the listeners once a namespace is committed by another TiProxy, so that all the TiProxy
+// instances apply the same namespaces. Namespace is nil if the namespace is deleted.
+type NamespaceEvent struct {
+	Name      string
+	Namespace *config.Namespace
 }
 
-func (e *ConfigManager) list(ctx context.Context, ns string, ops ...clientv3.OpOption) ([]KVValue, error) {
-	k := path.Clean(ns)
-	var resp []KVValue
-	e.kv.Ascend(KVValue{Key: k}, func(item KVValue) bool {
-		if !strings.HasPrefix(item.Key, k) {
-			return false
+// namespaceCommit is the value of the commit key of a namespace. Updating and deleting namespaces don't take effect
+// until they are committed, and then the commit keys are updated to notify all the TiProxy instances.
+type namespaceCommit struct {
+	// Origin is the ID of the TiProxy that commits the namespace, which has applied it already.
+	Origin string `json:"origin"`
+	// Time is when the namespace is committed.
+	Time time.Time `json:"time"`
+}
+
+// SetKVStore replaces the store of namespaces and starts watching the commits. The previous store is closed.
+func (e *ConfigManager) SetKVStore(store KVStore) error {
+	var ctx context.Context
+	e.kv.Lock()
+	prevStore := e.kv.store
+	if e.kv.cancel != nil {
+		e.kv.cancel()
+	}
+	ctx, e.kv.cancel = context.WithCancel(context.Background())
+	e.kv.store = store
+	e.kv.Unlock()
+
+	ch := store.Watch(ctx, pathPrefixCommit+"/")
+	e.wg.Run(func() {
+		for ev := range ch {
+			e.notifyNamespace(ctx, ev)
 		}
-		resp = append(resp, item)
-		return true
 	})
-	return resp, nil
+	if prevStore != nil {
+		return prevStore.Close()
+	}
+	return nil
 }
 
-func (e *ConfigManager) set(ctx context.Context, ns, key string, val []byte) error {
-	v := KVValue{Key: path.Clean(path.Join(ns, key)), Value: val}
-	_, _ = e.kv.Set(v)
+func (e *ConfigManager) getKVStore() KVStore {
+	e.kv.RLock()
+	defer e.kv.RUnlock()
+	return e.kv.store
+}
+
+func (e *ConfigManager) notifyNamespace(ctx context.Context, ev KVEvent) {
+	// The commit keys are never deleted.
+	if ev.Deleted {
+		return
+	}
+	var commit namespaceCommit
+	if err := json.Unmarshal(ev.Value, &commit); err != nil {
+		e.logger.Warn("failed to parse the namespace commit", zap.String("key", ev.Key), zap.Error(err))
+		return
+	}
+	if commit.Origin == e.id {
+		return
+	}
+	nsEvent := &NamespaceEvent{
+		Name: strings.TrimPrefix(ev.Key, pathPrefixCommit+"/"),
+	}
+	nsc, err := e.GetNamespace(ctx, nsEvent.Name)
+	switch {
+	case err == nil:
+		nsEvent.Namespace = nsc
+	case !errors.Is(err, ErrNoResults):
+		e.logger.Warn("failed to read the committed namespace", zap.String("namespace", nsEvent.Name), zap.Error(err))
+		return
+	}
+	e.nsts.Lock()
+	defer e.nsts.Unlock()
+	for _, ch := range e.nsts.listeners {
+		select {
+		case ch <- nsEvent:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WatchNamespace returns a channel that receives the namespaces committed by other TiProxy instances.
+func (e *ConfigManager) WatchNamespace() <-chan *NamespaceEvent {
+	ch := make(chan *NamespaceEvent)
+	e.nsts.Lock()
+	e.nsts.listeners = append(e.nsts.listeners, ch)
+	e.nsts.Unlock()
+	return ch
+}
+
+// CommitNamespaces notifies other TiProxy instances to apply the namespaces, which are applied by this one already.
+func (e *ConfigManager) CommitNamespaces(ctx context.Context, names []string) error {
+	r, err := json.Marshal(&namespaceCommit{Origin: e.id, Time: time.Now()})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, name := range names {
+		if err := e.set(ctx, pathPrefixCommit, name, r); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (e *ConfigManager) get(ctx context.Context, ns, key string) (KVValue, error) {
+	return e.getKVStore().Get(ctx, path.Clean(path.Join(ns, key)))
+}
+
+func (e *ConfigManager) list(ctx context.Context, ns string, ops ...clientv3.OpOption) ([]KVValue, error) {
+	// Append a slash so that the keys of "ns1" are not listed for "ns".
+	return e.getKVStore().List(ctx, path.Clean(ns)+"/")
+}
+
+func (e *ConfigManager) set(ctx context.Context, ns, key string, val []byte) error {
+	return e.getKVStore().Set(ctx, path.Clean(path.Join(ns, key)), val)
+}
+
 func (e *ConfigManager) del(ctx context.Context, ns, key string) error {
-	_, _ = e.kv.Delete(KVValue{Key: path.Clean(path.Join(ns, key))})
-	return nil
+	return e.getKVStore().Del(ctx, path.Clean(path.Join(ns, key)))
 }
 
 func (e *ConfigManager) GetNamespace(ctx context.Context, ns string) (*config.Namespace, error) {
@@ -79,6 +169,12 @@ func (e *ConfigManager) SetNamespace(ctx context.Context, ns string, nsc *config
 	if ns == "" || nsc.Namespace == "" {
 		return errors.New("namespace name can not be empty string")
 	}
+	if strings.ContainsAny(ns, "/\\") || ns == "." || ns == ".." {
+		return errors.Errorf("invalid namespace name %s", ns)
+	}
+	if err := nsc.Check(); err != nil {
+		return err
+	}
 	r, err := json.Marshal(nsc)
 	if err != nil {
 		return err
@@ -8,6 +8,7 @@ import (
 	"path"
 	"testing"
 
+	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/waitgroup"
 	"github.com/stretchr/testify/require"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -111,3 +112,39 @@ func TestBaseConcurrency(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestWatchNamespace(t *testing.T) {
+	cfgmgr, _, ctx := testConfigManager(t, "")
+	store, err := NewFileKVStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, cfgmgr.SetKVStore(store))
+	ch := cfgmgr.WatchNamespace()
+	// Another TiProxy that shares the store.
+	other := NewConfigManager()
+	other.kv.store = store
+
+	nsc := &config.Namespace{
+		Namespace: "test",
+		Backend: config.BackendNamespace{
+			Instances: []string{"127.0.0.1:4000"},
+		},
+	}
+	// The commits of itself are ignored.
+	require.NoError(t, other.SetNamespace(ctx, nsc.Namespace, nsc))
+	require.NoError(t, cfgmgr.CommitNamespaces(ctx, []string{nsc.Namespace}))
+	require.NoError(t, other.CommitNamespaces(ctx, []string{nsc.Namespace}))
+	ev := <-ch
+	require.Equal(t, "test", ev.Name)
+	require.Equal(t, nsc, ev.Namespace)
+
+	require.NoError(t, other.DelNamespace(ctx, nsc.Namespace))
+	require.NoError(t, other.CommitNamespaces(ctx, []string{nsc.Namespace}))
+	ev = <-ch
+	require.Equal(t, "test", ev.Name)
+	require.Nil(t, ev.Namespace)
+
+	nscs, err := cfgmgr.ListAllNamespace(ctx)
+	require.NoError(t, err)
+	require.Len(t, nscs, 0)
+	require.Error(t, cfgmgr.SetNamespace(ctx, "../test", &config.Namespace{Namespace: "../test"}))
+}
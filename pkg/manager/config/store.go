@@ -0,0 +1,457 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/tidwall/btree"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// etcdKeyPrefix is the root of all the keys written by TiProxy to the PD ETCD.
+	etcdKeyPrefix = "/tiproxy/"
+	// etcdRewatchInterval is the interval to watch again after the watch channel is broken.
+	etcdRewatchInterval = time.Second
+	// fileTmpSuffix is the suffix of the temporary file written before renaming.
+	fileTmpSuffix = ".tmp"
+)
+
+var _ KVStore = (*memKVStore)(nil)
+var _ KVStore = (*fileKVStore)(nil)
+var _ KVStore = (*etcdKVStore)(nil)
+
+// KVEvent is sent to watchers once a key is updated or deleted.
+type KVEvent struct {
+	KVValue
+	Deleted bool
+}
+
+// KVStore persists key-values, such as namespaces.
+// Keys are slash-separated paths and List returns the values whose keys have the prefix.
+type KVStore interface {
+	Get(ctx context.Context, key string) (KVValue, error)
+	List(ctx context.Context, prefix string) ([]KVValue, error)
+	Set(ctx context.Context, key string, val []byte) error
+	Del(ctx context.Context, key string) error
+	// Watch watches the keys with the prefix until ctx is done.
+	// The returned channel is closed after ctx is done.
+	Watch(ctx context.Context, prefix string) <-chan KVEvent
+	Close() error
+}
+
+type kvWatcher struct {
+	ctx    context.Context
+	prefix string
+	ch     chan KVEvent
+}
+
+// kvWatchers notifies the watchers of local stores, which are not shared by other TiProxy instances.
+type kvWatchers struct {
+	sync.Mutex
+	watchers []*kvWatcher
+}
+
+func (ws *kvWatchers) add(ctx context.Context, prefix string) <-chan KVEvent {
+	w := &kvWatcher{
+		ctx:    ctx,
+		prefix: prefix,
+		ch:     make(chan KVEvent),
+	}
+	ws.Lock()
+	ws.watchers = append(ws.watchers, w)
+	ws.Unlock()
+	go func() {
+		<-ctx.Done()
+		// notify() sends events within the lock, so it's safe to close the channel here.
+		ws.Lock()
+		for i, watcher := range ws.watchers {
+			if watcher == w {
+				ws.watchers = append(ws.watchers[:i], ws.watchers[i+1:]...)
+				break
+			}
+		}
+		close(w.ch)
+		ws.Unlock()
+	}()
+	return w.ch
+}
+
+func (ws *kvWatchers) notify(ev KVEvent) {
+	ws.Lock()
+	defer ws.Unlock()
+	for _, w := range ws.watchers {
+		if !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		case <-w.ctx.Done():
+		}
+	}
+}
+
+// memKVStore stores key-values in memory. It's used when no persistent store is specified.
+type memKVStore struct {
+	kv       *btree.BTreeG[KVValue]
+	watchers kvWatchers
+}
+
+func newMemKVStore() *memKVStore {
+	return &memKVStore{
+		kv: btree.NewBTreeG(func(a, b KVValue) bool {
+			return a.Key < b.Key
+		}),
+	}
+}
+
+func (s *memKVStore) Get(_ context.Context, key string) (KVValue, error) {
+	v, ok := s.kv.Get(KVValue{Key: key})
+	if !ok {
+		return v, errors.WithStack(errors.Wrapf(ErrNoResults, "key=%s", key))
+	}
+	return v, nil
+}
+
+func (s *memKVStore) List(_ context.Context, prefix string) ([]KVValue, error) {
+	var resp []KVValue
+	s.kv.Ascend(KVValue{Key: prefix}, func(item KVValue) bool {
+		if !strings.HasPrefix(item.Key, prefix) {
+			return false
+		}
+		resp = append(resp, item)
+		return true
+	})
+	return resp, nil
+}
+
+func (s *memKVStore) Set(_ context.Context, key string, val []byte) error {
+	v := KVValue{Key: key, Value: val}
+	_, _ = s.kv.Set(v)
+	s.watchers.notify(KVEvent{KVValue: v})
+	return nil
+}
+
+func (s *memKVStore) Del(_ context.Context, key string) error {
+	if _, ok := s.kv.Delete(KVValue{Key: key}); ok {
+		s.watchers.notify(KVEvent{KVValue: KVValue{Key: key}, Deleted: true})
+	}
+	return nil
+}
+
+func (s *memKVStore) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	return s.watchers.add(ctx, prefix)
+}
+
+func (s *memKVStore) Close() error {
+	return nil
+}
+
+// fileKVStore stores each key-value as a file under the work directory.
+// It survives restarts, but it's not shared by other TiProxy instances.
+type fileKVStore struct {
+	// mu makes writing and reading files exclusive.
+	mu       sync.RWMutex
+	dir      string
+	watchers kvWatchers
+}
+
+// NewFileKVStore creates a KVStore that persists key-values under dir.
+func NewFileKVStore(dir string) (KVStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &fileKVStore{
+		dir: dir,
+	}, nil
+}
+
+// filePath converts the key to a file path and forbids it from escaping the directory.
+func (s *fileKVStore) filePath(key string) (string, error) {
+	key = path.Clean("/" + key)
+	if key == "/" {
+		return "", errors.Errorf("invalid key %s", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *fileKVStore) Get(_ context.Context, key string) (KVValue, error) {
+	v := KVValue{Key: key}
+	fileName, err := s.filePath(key)
+	if err != nil {
+		return v, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if v.Value, err = os.ReadFile(fileName); err != nil {
+		if os.IsNotExist(err) {
+			return v, errors.WithStack(errors.Wrapf(ErrNoResults, "key=%s", key))
+		}
+		return v, errors.WithStack(err)
+	}
+	return v, nil
+}
+
+func (s *fileKVStore) List(_ context.Context, prefix string) ([]KVValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var resp []KVValue
+	err := filepath.WalkDir(s.dir, func(fileName string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, fileName)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			// Skip the directories that can't contain the prefix, such as the log directory.
+			if rel != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(fileName, fileTmpSuffix) || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		val, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		resp = append(resp, KVValue{Key: key, Value: val})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Key < resp[j].Key
+	})
+	return resp, nil
+}
+
+func (s *fileKVStore) Set(_ context.Context, key string, val []byte) error {
+	fileName, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if err = os.MkdirAll(filepath.Dir(fileName), 0755); err == nil {
+		// Write a temporary file and then rename it, so that the file won't be half-written if TiProxy crashes.
+		tmpName := fileName + fileTmpSuffix
+		if err = os.WriteFile(tmpName, val, 0644); err == nil {
+			err = os.Rename(tmpName, fileName)
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.watchers.notify(KVEvent{KVValue: KVValue{Key: key, Value: val}})
+	return nil
+}
+
+func (s *fileKVStore) Del(_ context.Context, key string) error {
+	fileName, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	err = os.Remove(fileName)
+	s.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	s.watchers.notify(KVEvent{KVValue: KVValue{Key: key}, Deleted: true})
+	return nil
+}
+
+func (s *fileKVStore) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	return s.watchers.add(ctx, prefix)
+}
+
+func (s *fileKVStore) Close() error {
+	return nil
+}
+
+// etcdKVStore stores key-values in the PD ETCD, so that they are shared by all the TiProxy instances in the cluster.
+type etcdKVStore struct {
+	cli    *clientv3.Client
+	logger *zap.Logger
+}
+
+// NewEtcdKVStore creates a KVStore on the PD ETCD. The client is shared with others, so the store doesn't close it.
+func NewEtcdKVStore(cli *clientv3.Client, logger *zap.Logger) KVStore {
+	return &etcdKVStore{
+		cli:    cli,
+		logger: logger,
+	}
+}
+
+func (s *etcdKVStore) Get(ctx context.Context, key string) (KVValue, error) {
+	v := KVValue{Key: key}
+	resp, err := s.cli.Get(ctx, etcdKeyPrefix+key)
+	if err != nil {
+		return v, errors.WithStack(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return v, errors.WithStack(errors.Wrapf(ErrNoResults, "key=%s", key))
+	}
+	v.Value = resp.Kvs[0].Value
+	return v, nil
+}
+
+func (s *etcdKVStore) List(ctx context.Context, prefix string) ([]KVValue, error) {
+	resp, err := s.cli.Get(ctx, etcdKeyPrefix+prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	kvs := make([]KVValue, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs = append(kvs, KVValue{Key: strings.TrimPrefix(string(kv.Key), etcdKeyPrefix), Value: kv.Value})
+	}
+	return kvs, nil
+}
+
+func (s *etcdKVStore) Set(ctx context.Context, key string, val []byte) error {
+	_, err := s.cli.Put(ctx, etcdKeyPrefix+key, string(val))
+	return errors.WithStack(err)
+}
+
+func (s *etcdKVStore) Del(ctx context.Context, key string) error {
+	_, err := s.cli.Delete(ctx, etcdKeyPrefix+key)
+	return errors.WithStack(err)
+}
+
+// etcdWatcher remembers the revision of each key, so that it can tell the changes after listing again.
+type etcdWatcher struct {
+	*etcdKVStore
+	prefix string
+	ch     chan KVEvent
+	// rev is the revision until which all the changes are sent. It's 0 if the keys need to be listed again.
+	rev int64
+	// keys are the mod revisions of the existing keys.
+	keys map[string]int64
+}
+
+// Watch watches the changes from all the TiProxy instances.
+// It lists the keys before watching and watches from the listed revision, so the changes after Watch returns are
+// all sent. If the watch channel is broken, it watches again from the next revision. If the revision is compacted,
+// it lists the keys again and sends the differences before watching from the listed revision.
+func (s *etcdKVStore) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	w := &etcdWatcher{
+		etcdKVStore: s,
+		prefix:      etcdKeyPrefix + prefix,
+		ch:          make(chan KVEvent),
+		keys:        make(map[string]int64),
+	}
+	// Nothing is sent for the first listing, so it won't block.
+	if err := w.list(ctx, false); err != nil {
+		s.logger.Warn("list etcd failed, retrying", zap.Error(err))
+	}
+	go func() {
+		defer close(w.ch)
+		for ctx.Err() == nil {
+			if w.rev > 0 {
+				childCtx, cancel := context.WithCancel(ctx)
+				wch := s.cli.Watch(clientv3.WithRequireLeader(childCtx), w.prefix, clientv3.WithPrefix(), clientv3.WithRev(w.rev+1))
+				w.forwardEvents(ctx, wch)
+				cancel()
+			} else if err := w.list(ctx, true); err != nil {
+				s.logger.Warn("list etcd failed, retrying", zap.Error(err))
+			} else {
+				continue
+			}
+			select {
+			case <-time.After(etcdRewatchInterval):
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return w.ch
+}
+
+// list lists the keys and sends the differences from the last listed or watched keys if notify is true.
+func (w *etcdWatcher) list(ctx context.Context, notify bool) error {
+	resp, err := w.cli.Get(ctx, w.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	keys := make(map[string]int64, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys[string(kv.Key)] = kv.ModRevision
+		if modRev, ok := w.keys[string(kv.Key)]; notify && (!ok || modRev != kv.ModRevision) {
+			if !w.send(ctx, KVEvent{KVValue: KVValue{Key: strings.TrimPrefix(string(kv.Key), etcdKeyPrefix), Value: kv.Value}}) {
+				return ctx.Err()
+			}
+		}
+	}
+	for key := range w.keys {
+		if _, ok := keys[key]; notify && !ok {
+			if !w.send(ctx, KVEvent{KVValue: KVValue{Key: strings.TrimPrefix(key, etcdKeyPrefix)}, Deleted: true}) {
+				return ctx.Err()
+			}
+		}
+	}
+	w.keys, w.rev = keys, resp.Header.Revision
+	return nil
+}
+
+// forwardEvents forwards the events until the watch channel is broken.
+func (w *etcdWatcher) forwardEvents(ctx context.Context, wch clientv3.WatchChan) {
+	for resp := range wch {
+		if err := resp.Err(); err != nil {
+			if resp.CompactRevision > 0 {
+				w.rev = 0
+			}
+			w.logger.Warn("watch etcd failed, rewatching", zap.Error(err))
+			return
+		}
+		for _, ev := range resp.Events {
+			kvEvent := KVEvent{
+				KVValue: KVValue{
+					Key:   strings.TrimPrefix(string(ev.Kv.Key), etcdKeyPrefix),
+					Value: ev.Kv.Value,
+				},
+				Deleted: ev.Type == clientv3.EventTypeDelete,
+			}
+			if !w.send(ctx, kvEvent) {
+				return
+			}
+			if kvEvent.Deleted {
+				delete(w.keys, string(ev.Kv.Key))
+			} else {
+				w.keys[string(ev.Kv.Key)] = ev.Kv.ModRevision
+			}
+			w.rev = ev.Kv.ModRevision
+		}
+	}
+}
+
+func (w *etcdWatcher) send(ctx context.Context, ev KVEvent) bool {
+	select {
+	case w.ch <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *etcdKVStore) Close() error {
+	return nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func TestKVStores(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	fileStore, err := NewFileKVStore(t.TempDir())
+	require.NoError(t, err)
+	stores := map[string]KVStore{
+		"mem":  newMemKVStore(),
+		"file": fileStore,
+		"etcd": NewEtcdKVStore(createEtcdClient(t), lg),
+	}
+
+	for name, store := range stores {
+		ctx := context.Background()
+		_, err := store.Get(ctx, "ns/a")
+		require.ErrorIs(t, err, ErrNoResults, name)
+
+		require.NoError(t, store.Set(ctx, "ns/b", []byte("b")), name)
+		require.NoError(t, store.Set(ctx, "ns/a", []byte("a")), name)
+		require.NoError(t, store.Set(ctx, "ns1/c", []byte("c")), name)
+		require.NoError(t, store.Set(ctx, "ns/a", []byte("aa")), name)
+		v, err := store.Get(ctx, "ns/a")
+		require.NoError(t, err, name)
+		require.Equal(t, "ns/a", v.Key, name)
+		require.Equal(t, "aa", string(v.Value), name)
+
+		vals, err := store.List(ctx, "ns/")
+		require.NoError(t, err, name)
+		require.Equal(t, []KVValue{{Key: "ns/a", Value: []byte("aa")}, {Key: "ns/b", Value: []byte("b")}}, vals, name)
+
+		require.NoError(t, store.Del(ctx, "ns/a"), name)
+		require.NoError(t, store.Del(ctx, "ns/a"), name)
+		vals, err = store.List(ctx, "ns/")
+		require.NoError(t, err, name)
+		require.Len(t, vals, 1, name)
+		require.NoError(t, store.Close(), name)
+	}
+}
+
+func TestWatchKVStores(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	fileStore, err := NewFileKVStore(t.TempDir())
+	require.NoError(t, err)
+	stores := map[string]KVStore{
+		"mem":  newMemKVStore(),
+		"file": fileStore,
+		"etcd": NewEtcdKVStore(createEtcdClient(t), lg),
+	}
+
+	for name, store := range stores {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := store.Watch(ctx, "ns/")
+		go func() {
+			require.NoError(t, store.Set(ctx, "ns1/a", []byte("b")))
+			require.NoError(t, store.Set(ctx, "ns/a", []byte("a")))
+			require.NoError(t, store.Del(ctx, "ns/a"))
+		}()
+		ev := <-ch
+		require.Equal(t, KVEvent{KVValue: KVValue{Key: "ns/a", Value: []byte("a")}}, ev, name)
+		ev = <-ch
+		require.Equal(t, "ns/a", ev.Key, name)
+		require.True(t, ev.Deleted, name)
+
+		cancel()
+		require.Eventually(t, func() bool {
+			_, ok := <-ch
+			return !ok
+		}, 3*time.Second, 10*time.Millisecond, name)
+		require.NoError(t, store.Close(), name)
+	}
+}
+
+// Test that the changes during compaction are sent after listing again.
+func TestWatchEtcdCompaction(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	cli := createEtcdClient(t)
+	store := NewEtcdKVStore(cli, lg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, store.Set(ctx, "ns/a", []byte("a")))
+	require.NoError(t, store.Set(ctx, "ns/b", []byte("b")))
+
+	w := &etcdWatcher{
+		etcdKVStore: store.(*etcdKVStore),
+		prefix:      etcdKeyPrefix + "ns/",
+		ch:          make(chan KVEvent),
+		keys:        make(map[string]int64),
+	}
+	require.NoError(t, w.list(ctx, false))
+	require.NoError(t, store.Set(ctx, "ns/a", []byte("aa")))
+	require.NoError(t, store.Del(ctx, "ns/b"))
+	require.NoError(t, store.Set(ctx, "ns/c", []byte("c")))
+	resp, err := cli.Put(ctx, etcdKeyPrefix+"other", "")
+	require.NoError(t, err)
+	_, err = cli.Compact(ctx, resp.Header.Revision)
+	require.NoError(t, err)
+
+	// The revision is compacted, so the watcher lists again.
+	w.forwardEvents(ctx, cli.Watch(ctx, w.prefix, clientv3.WithPrefix(), clientv3.WithRev(w.rev+1)))
+	require.Zero(t, w.rev)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.list(ctx, true)
+	}()
+	events := make(map[string]KVEvent)
+	for i := 0; i < 3; i++ {
+		ev := <-w.ch
+		events[ev.Key] = ev
+	}
+	require.Equal(t, map[string]KVEvent{
+		"ns/a": {KVValue: KVValue{Key: "ns/a", Value: []byte("aa")}},
+		"ns/b": {KVValue: KVValue{Key: "ns/b"}, Deleted: true},
+		"ns/c": {KVValue: KVValue{Key: "ns/c", Value: []byte("c")}},
+	}, events)
+	require.NoError(t, <-errCh)
+}
+
+func TestFileKVStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileKVStore(dir)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, store.Set(ctx, "ns/a", []byte("a")))
+	// Other files in the work directory are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ns.log"), []byte("log"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ns", "b"+fileTmpSuffix), []byte("b"), 0644))
+	// Keys can not escape the directory.
+	require.NoError(t, store.Set(ctx, "../../ns/c", []byte("c")))
+	require.Error(t, store.Set(ctx, "..", []byte("c")))
+	require.NoError(t, store.Close())
+
+	// The key-values are persisted after restart.
+	store, err = NewFileKVStore(dir)
+	require.NoError(t, err)
+	vals, err := store.List(ctx, "ns/")
+	require.NoError(t, err)
+	require.Equal(t, []KVValue{{Key: "ns/a", Value: []byte("a")}, {Key: "ns/c", Value: []byte("c")}}, vals)
+	require.NoError(t, store.Close())
+}
+
+func createEtcdClient(t *testing.T) *clientv3.Client {
+	lg, _ := logger.CreateLoggerForTest(t)
+	serverURL, err := url.Parse("http://127.0.0.1:0")
+	require.NoError(t, err)
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LCUrls = []url.URL{*serverURL}
+	cfg.LPUrls = []url.URL{*serverURL}
+	cfg.ZapLoggerBuilder = embed.NewZapLoggerBuilder(lg)
+	cfg.LogLevel = "fatal"
+	etcd, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	<-etcd.Server.ReadyNotify()
+	t.Cleanup(etcd.Close)
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{fmt.Sprintf("http://%s", etcd.Clients[0].Addr().String())},
+		DialTimeout: 3 * time.Second,
+		Logger:      lg,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, cli.Close())
+	})
+	return cli
+}
@@ -269,6 +269,11 @@ func (is *InfoSyncer) GetTiDBTopology(ctx context.Context) (map[string]*TiDBInfo
 	return infos, nil
 }
 
+// GetEtcdClient returns the etcd client, which is closed when the syncer is closed.
+func (is *InfoSyncer) GetEtcdClient() *clientv3.Client {
+	return is.etcdCli
+}
+
 func (is *InfoSyncer) Close() error {
 	if is.cancelFunc != nil {
 		is.cancelFunc()
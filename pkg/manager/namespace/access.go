@@ -0,0 +1,99 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"net"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/pkg/metrics"
+)
+
+const (
+	// AccessRuleAllow is the rule that rejects the clients that match no entry of the allow list.
+	AccessRuleAllow = "allow"
+	// AccessRuleDeny is the rule that rejects the clients that match an entry of the deny list.
+	AccessRuleDeny = "deny"
+)
+
+type accessEntry struct {
+	// entry is the item in the access list, which is logged when it rejects a client.
+	entry string
+	ipNet *net.IPNet
+}
+
+// AccessFilter checks the client addresses against an access list and counts the rejections by rules.
+// A nil filter allows any client.
+type AccessFilter struct {
+	// namespace is empty for the global access list.
+	namespace string
+	allow     []accessEntry
+	deny      []accessEntry
+}
+
+// NewAccessFilter builds the filter of the access list. It returns nil if the list is empty.
+func NewAccessFilter(namespace string, cfg config.AccessList) (*AccessFilter, error) {
+	if cfg.Empty() {
+		return nil, nil
+	}
+	filter := &AccessFilter{namespace: namespace}
+	for _, entry := range cfg.Allow {
+		ipNet, err := config.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		filter.allow = append(filter.allow, accessEntry{entry: entry, ipNet: ipNet})
+	}
+	for _, entry := range cfg.Deny {
+		ipNet, err := config.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		filter.deny = append(filter.deny, accessEntry{entry: entry, ipNet: ipNet})
+	}
+	return filter, nil
+}
+
+// Check returns ErrClientAddrDenied if the client address is denied. The address may contain a port.
+// An address that isn't an IP, such as a unix socket, is only allowed when the allow list is empty.
+func (f *AccessFilter) Check(addr string) error {
+	if f == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	rule, matched := "", ""
+	if ip != nil {
+		for _, entry := range f.deny {
+			if entry.ipNet.Contains(ip) {
+				rule, matched = AccessRuleDeny, entry.entry
+				break
+			}
+		}
+	}
+	if rule == "" && len(f.allow) > 0 {
+		rule = AccessRuleAllow
+		for _, entry := range f.allow {
+			if ip != nil && entry.ipNet.Contains(ip) {
+				rule = ""
+				break
+			}
+		}
+	}
+	if rule == "" {
+		return nil
+	}
+	metrics.AccessRejectCounter.WithLabelValues(f.namespace, rule).Inc()
+	if matched != "" {
+		rule += " " + matched
+	}
+	if f.namespace == "" {
+		return errors.Wrapf(ErrClientAddrDenied, "client %s, rule %s", host, rule)
+	}
+	return errors.Wrapf(ErrClientAddrDenied, "client %s, namespace %s, rule %s", host, f.namespace, rule)
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"testing"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/pkg/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessFilter(t *testing.T) {
+	filter, err := NewAccessFilter("", config.AccessList{})
+	require.NoError(t, err)
+	require.Nil(t, filter)
+	require.NoError(t, filter.Check("10.0.0.1:3306"))
+
+	_, err = NewAccessFilter("ns", config.AccessList{Deny: []string{"10.0.0.0/x"}})
+	require.ErrorIs(t, err, config.ErrInvalidAccessList)
+
+	filter, err = NewAccessFilter("ns", config.AccessList{
+		Allow: []string{"10.0.0.0/8", "192.168.1.1", "::1"},
+		Deny:  []string{"10.1.0.0/16"},
+	})
+	require.NoError(t, err)
+	tests := []struct {
+		addr string
+		rule string
+	}{
+		{"10.0.0.1:3306", ""},
+		{"10.0.0.1", ""},
+		{"192.168.1.1:3306", ""},
+		{"[::1]:3306", ""},
+		{"10.1.0.1:3306", AccessRuleDeny},
+		{"192.168.1.2:3306", AccessRuleAllow},
+		{"/tmp/tiproxy.sock", AccessRuleAllow},
+	}
+	for _, test := range tests {
+		if test.rule == "" {
+			require.NoError(t, filter.Check(test.addr), test.addr)
+			continue
+		}
+		prev, err := metrics.ReadCounter(metrics.AccessRejectCounter.WithLabelValues("ns", test.rule))
+		require.NoError(t, err)
+		err = filter.Check(test.addr)
+		require.ErrorIs(t, err, ErrClientAddrDenied, test.addr)
+		if test.rule == AccessRuleDeny {
+			// The matched entry is logged instead of being a label value.
+			require.ErrorContains(t, err, "deny 10.1.0.0/16", test.addr)
+		}
+		cur, err := metrics.ReadCounter(metrics.AccessRejectCounter.WithLabelValues("ns", test.rule))
+		require.NoError(t, err)
+		require.Equal(t, prev+1, cur, test.addr)
+	}
+
+	// Only the deny list takes effect if the allow list is empty.
+	filter, err = NewAccessFilter("", config.AccessList{Deny: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	require.NoError(t, filter.Check("192.168.1.1:3306"))
+	require.NoError(t, filter.Check("/tmp/tiproxy.sock"))
+	require.ErrorIs(t, filter.Check("10.0.0.1:3306"), ErrClientAddrDenied)
+}
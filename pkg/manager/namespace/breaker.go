@@ -0,0 +1,189 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/pkg/metrics"
+)
+
+type BreakerState int
+
+const (
+	// BreakerStateClosed allows all requests.
+	BreakerStateClosed BreakerState = iota
+	// BreakerStateOpen rejects all requests.
+	BreakerStateOpen
+	// BreakerStateHalfOpen allows a limited number of requests to probe the backends.
+	BreakerStateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerStateClosed:
+		return "closed"
+	case BreakerStateOpen:
+		return "open"
+	case BreakerStateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStatus is the snapshot of a circuit breaker, which is shown in the API.
+type BreakerStatus struct {
+	Namespace string    `json:"namespace"`
+	State     string    `json:"state"`
+	Successes int       `json:"successes"`
+	Failures  int       `json:"failures"`
+	OpenedAt  time.Time `json:"opened-at,omitempty"`
+}
+
+// CircuitBreaker counts the results of connecting to the backends of a namespace in a fixed window.
+// It opens when the failure rate exceeds the threshold, and then it rejects new connections immediately
+// instead of letting them wait until the backends are available.
+// After OpenStatusDurationMs, it turns half-open and allows some requests to probe the backends.
+type CircuitBreaker struct {
+	sync.Mutex
+	name  string
+	cfg   config.CircuitBreaker
+	state BreakerState
+	// The results in the current window.
+	windowStart time.Time
+	successes   int
+	failures    int
+	openedAt    time.Time
+	// The requests that are allowed in the half-open state.
+	probes int
+	now    func() time.Time
+}
+
+// NewCircuitBreaker creates a CircuitBreaker for the namespace.
+func NewCircuitBreaker(name string, cfg config.CircuitBreaker) (*CircuitBreaker, error) {
+	if name == "" {
+		return nil, ErrNilBreakerName
+	}
+	if cfg.FailureRateThreshold <= 0 || cfg.FailureRateThreshold > 100 {
+		return nil, errors.Wrapf(ErrInvalidFailureRateThreshold, "failure rate threshold %d is not in (0, 100]", cfg.FailureRateThreshold)
+	}
+	if cfg.OpenStatusDurationMs <= 0 {
+		return nil, errors.Wrapf(ErrInvalidopenStatusDurationMs, "open status duration %dms is not positive", cfg.OpenStatusDurationMs)
+	}
+	cfg.Check()
+	cb := &CircuitBreaker{
+		name: name,
+		cfg:  cfg,
+		now:  time.Now,
+	}
+	cb.windowStart = cb.now()
+	cb.setState(BreakerStateClosed)
+	return cb, nil
+}
+
+// Allow is called before connecting to a backend. It returns ErrBreakerOpen if the request is rejected.
+func (cb *CircuitBreaker) Allow() error {
+	cb.Lock()
+	defer cb.Unlock()
+	now := cb.now()
+	switch cb.state {
+	case BreakerStateOpen:
+		if now.Sub(cb.openedAt) < time.Duration(cb.cfg.OpenStatusDurationMs)*time.Millisecond {
+			metrics.BreakerRejectCounter.WithLabelValues(cb.name).Inc()
+			return errors.Wrapf(ErrBreakerOpen, "namespace %s", cb.name)
+		}
+		cb.setState(BreakerStateHalfOpen)
+		cb.resetWindow(now)
+	case BreakerStateHalfOpen:
+		if cb.probes >= cb.cfg.HalfOpenRequests {
+			metrics.BreakerRejectCounter.WithLabelValues(cb.name).Inc()
+			return errors.Wrapf(ErrBreakerOpen, "namespace %s", cb.name)
+		}
+	}
+	if cb.state == BreakerStateHalfOpen {
+		cb.probes++
+	}
+	return nil
+}
+
+// Release gives back the request allowed by Allow if it doesn't connect to any backend, e.g. no backend is available.
+// It must be called instead of OnResult, otherwise the half-open breaker may reject all requests forever.
+func (cb *CircuitBreaker) Release() {
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.state == BreakerStateHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// OnResult reports the result of connecting to a backend.
+func (cb *CircuitBreaker) OnResult(success bool) {
+	cb.Lock()
+	defer cb.Unlock()
+	now := cb.now()
+	switch cb.state {
+	case BreakerStateOpen:
+		// The requests that were allowed before opening may report later, ignore them.
+		return
+	case BreakerStateHalfOpen:
+		if !success {
+			cb.open(now)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.cfg.HalfOpenRequests {
+			cb.setState(BreakerStateClosed)
+			cb.resetWindow(now)
+		}
+		return
+	}
+
+	if now.Sub(cb.windowStart) >= cb.cfg.Window {
+		cb.resetWindow(now)
+	}
+	if success {
+		cb.successes++
+	} else {
+		cb.failures++
+	}
+	total := cb.successes + cb.failures
+	if total >= cb.cfg.MinRequests && cb.failures*100 >= cb.cfg.FailureRateThreshold*total {
+		cb.open(now)
+	}
+}
+
+// Status returns the snapshot of the breaker.
+func (cb *CircuitBreaker) Status() BreakerStatus {
+	cb.Lock()
+	defer cb.Unlock()
+	status := BreakerStatus{
+		Namespace: cb.name,
+		State:     cb.state.String(),
+		Successes: cb.successes,
+		Failures:  cb.failures,
+	}
+	if cb.state != BreakerStateClosed {
+		status.OpenedAt = cb.openedAt
+	}
+	return status
+}
+
+func (cb *CircuitBreaker) open(now time.Time) {
+	cb.openedAt = now
+	cb.setState(BreakerStateOpen)
+	cb.resetWindow(now)
+}
+
+func (cb *CircuitBreaker) resetWindow(now time.Time) {
+	cb.windowStart = now
+	cb.successes, cb.failures, cb.probes = 0, 0, 0
+}
+
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	cb.state = state
+	metrics.BreakerStateGauge.WithLabelValues(cb.name).Set(float64(state))
+}
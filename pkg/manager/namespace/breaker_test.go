@@ -0,0 +1,83 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerConfig(t *testing.T) {
+	_, err := NewCircuitBreaker("", config.CircuitBreaker{FailureRateThreshold: 50, OpenStatusDurationMs: 1000})
+	require.ErrorIs(t, err, ErrNilBreakerName)
+	_, err = NewCircuitBreaker("ns", config.CircuitBreaker{FailureRateThreshold: 0, OpenStatusDurationMs: 1000})
+	require.ErrorIs(t, err, ErrInvalidFailureRateThreshold)
+	_, err = NewCircuitBreaker("ns", config.CircuitBreaker{FailureRateThreshold: 101, OpenStatusDurationMs: 1000})
+	require.ErrorIs(t, err, ErrInvalidFailureRateThreshold)
+	_, err = NewCircuitBreaker("ns", config.CircuitBreaker{FailureRateThreshold: 50})
+	require.ErrorIs(t, err, ErrInvalidopenStatusDurationMs)
+	cb, err := NewCircuitBreaker("ns", config.CircuitBreaker{FailureRateThreshold: 50, OpenStatusDurationMs: 1000})
+	require.NoError(t, err)
+	require.Equal(t, 10, cb.cfg.MinRequests)
+	require.Equal(t, 10*time.Second, cb.cfg.Window)
+	require.Equal(t, 1, cb.cfg.HalfOpenRequests)
+}
+
+func TestBreakerState(t *testing.T) {
+	cb, err := NewCircuitBreaker("ns", config.CircuitBreaker{
+		FailureRateThreshold: 50,
+		MinRequests:          4,
+		Window:               time.Minute,
+		OpenStatusDurationMs: 1000,
+		HalfOpenRequests:     2,
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	cb.now = func() time.Time {
+		return now
+	}
+
+	// The failure rate is not calculated until there are enough results.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, cb.Allow())
+		cb.OnResult(false)
+	}
+	require.Equal(t, BreakerStateClosed.String(), cb.Status().State)
+
+	// The window expires and the results are reset.
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		cb.OnResult(true)
+		cb.OnResult(false)
+	}
+	require.Equal(t, BreakerStateOpen.String(), cb.Status().State)
+	require.ErrorIs(t, cb.Allow(), ErrBreakerOpen)
+
+	// Turn half-open after the open duration and only allow limited requests.
+	now = now.Add(time.Second)
+	require.NoError(t, cb.Allow())
+	require.NoError(t, cb.Allow())
+	require.ErrorIs(t, cb.Allow(), ErrBreakerOpen)
+	require.Equal(t, BreakerStateHalfOpen.String(), cb.Status().State)
+	// The released requests don't occupy the probes.
+	cb.Release()
+	require.NoError(t, cb.Allow())
+	require.ErrorIs(t, cb.Allow(), ErrBreakerOpen)
+	// Any failure opens it again.
+	cb.OnResult(false)
+	require.Equal(t, BreakerStateOpen.String(), cb.Status().State)
+	require.Equal(t, now, cb.Status().OpenedAt)
+
+	now = now.Add(time.Second)
+	require.NoError(t, cb.Allow())
+	require.NoError(t, cb.Allow())
+	cb.OnResult(true)
+	require.Equal(t, BreakerStateHalfOpen.String(), cb.Status().State)
+	cb.OnResult(true)
+	require.Equal(t, BreakerStateClosed.String(), cb.Status().State)
+	require.NoError(t, cb.Allow())
+}
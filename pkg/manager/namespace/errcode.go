@@ -8,11 +8,17 @@ import "github.com/pingcap/TiProxy/lib/util/errors"
 var (
 	ErrDuplicatedUser      = errors.New("duplicated user")
 	ErrInvalidSelectorType = errors.New("invalid selector type")
+	ErrNamespaceNotFound   = errors.New("namespace not found")
 
 	ErrNilBreakerName              = errors.New("breaker name nil")
 	ErrInvalidFailureRateThreshold = errors.New("invalid FailureRateThreshold")
 	ErrInvalidopenStatusDurationMs = errors.New("invalid OpenStatusDurationMs")
+	ErrBreakerOpen                 = errors.New("circuit breaker is open")
 	ErrInvalidSqlTimeout           = errors.New("invalid sql timeout")
 
 	ErrInvalidScope = errors.New("invalid scope")
+
+	ErrClientCertRequired = errors.New("the namespace requires a client certificate")
+	ErrCertUserMismatch   = errors.New("the client certificate doesn't match the user")
+	ErrClientAddrDenied   = errors.New("the client address is denied")
 )
@@ -10,20 +10,41 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/pkg/manager/cert"
 	"github.com/pingcap/TiProxy/pkg/manager/router"
+	"github.com/pingcap/TiProxy/pkg/metrics"
 	"go.uber.org/zap"
 )
 
 type NamespaceManager struct {
 	sync.RWMutex
-	tpFetcher router.TopologyFetcher
-	httpCli   *http.Client
-	logger    *zap.Logger
-	nsm       map[string]*Namespace
+	// commitLock serializes committing namespaces, which may come from both the API and the namespace watcher.
+	commitLock sync.Mutex
+	tpFetcher  router.TopologyFetcher
+	httpCli    *http.Client
+	certMgr    *cert.CertManager
+	logger     *zap.Logger
+	nsm        map[string]*Namespace
+	// rules are the match rules of all namespaces, sorted by precedence.
+	rules []*matchRule
+	// zone is the zone of TiProxy, which makes the routers prefer the backends in the same zone.
+	zone string
+	// rebalanceCfg, pausedRebalance and drainedBackends are applied to the routers when they are built.
+	rebalanceCfg    *config.Rebalance
+	pausedRebalance map[string]struct{}
+	// drainedBackends are the draining backends of each namespace.
+	drainedBackends map[string]map[string]struct{}
+}
+
+// RebalanceStatus shows whether the rebalancing of a namespace is paused.
+type RebalanceStatus struct {
+	Namespace string `json:"namespace"`
+	Paused    bool   `json:"paused"`
 }
 
 func NewNamespaceManager() *NamespaceManager {
@@ -32,25 +53,120 @@ func NewNamespaceManager() *NamespaceManager {
 
 func (mgr *NamespaceManager) buildNamespace(cfg *config.Namespace) (*Namespace, error) {
 	logger := mgr.logger.With(zap.String("namespace", cfg.Namespace))
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+	rules, err := newMatchRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSQLTimeout(cfg.Backend.SQLTimeout); err != nil {
+		return nil, err
+	}
+	accessFilter, err := NewAccessFilter(cfg.Namespace, cfg.Frontend.AccessList)
+	if err != nil {
+		return nil, err
+	}
+	var breaker *CircuitBreaker
+	if cfg.Backend.CircuitBreaker != nil {
+		if breaker, err = NewCircuitBreaker(cfg.Namespace, *cfg.Backend.CircuitBreaker); err != nil {
+			return nil, err
+		}
+	}
 
 	var fetcher router.BackendFetcher
 	if !reflect.ValueOf(mgr.tpFetcher).IsNil() {
-		fetcher = router.NewPDFetcher(mgr.tpFetcher, logger.Named("be_fetcher"), config.NewDefaultHealthCheckConfig())
+		fetcher = router.NewPDFetcher(mgr.tpFetcher, logger.Named("be_fetcher"), cfg.Backend.GetHealthCheck())
 	} else {
 		fetcher = router.NewStaticFetcher(cfg.Backend.Instances)
 	}
-	rt := router.NewScoreBasedRouter(logger.Named("router"))
-	if err := rt.Init(mgr.httpCli, fetcher, config.NewDefaultHealthCheckConfig()); err != nil {
+	build, ok := router.GetRouterBuilder(cfg.Backend.SelectorType)
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidSelectorType, "selector type %s", cfg.Backend.SelectorType)
+	}
+	rt, err := build(logger.Named("router"), mgr.httpCli, fetcher, &cfg.Backend)
+	if err != nil {
 		return nil, errors.Errorf("build router error: %w", err)
 	}
+	rt.SetZone(mgr.zone)
+	var hashCfg *config.ConsistentHash
+	if cfg.Backend.SelectorType == router.SelectorTypeConsistentHash {
+		hashCfg = cfg.Backend.GetConsistentHash()
+	}
 	return &Namespace{
-		name:   cfg.Namespace,
-		user:   cfg.Frontend.User,
-		router: rt,
+		name:    cfg.Namespace,
+		user:    cfg.Frontend.User,
+		cfg:     cfg,
+		router:  rt,
+		certMgr: mgr.certMgr,
+		rules:   rules,
+		breaker: breaker,
+		// The config is immutable after it's committed.
+		sqlTimeout:        cfg.Backend.SQLTimeout,
+		hashCfg:           hashCfg,
+		requireClientCert: cfg.Frontend.RequireClientCert,
+		accessFilter:      accessFilter,
 	}, nil
 }
 
+// restoreRouterState applies the states that are set through the API to the newly built router.
+// NOTE: the write lock should be held before calling this function, and the namespace should take effect
+// before unlocking. Otherwise, the API may update the previous router after the states are read.
+func (mgr *NamespaceManager) restoreRouterState(ns *Namespace) {
+	rt := ns.GetRouter()
+	if mgr.rebalanceCfg != nil {
+		rt.SetRebalanceConfig(mgr.rebalanceCfg)
+	}
+	if _, ok := mgr.pausedRebalance[ns.Name()]; ok {
+		_ = rt.PauseRebalance(true)
+	}
+	if drained := mgr.drainedBackends[ns.Name()]; len(drained) > 0 {
+		addrs := make([]string, 0, len(drained))
+		for addr := range drained {
+			addrs = append(addrs, addr)
+		}
+		rt.RestoreDraining(addrs)
+	}
+}
+
+// loadNamespaceTLS loads the certs of the namespace. They take effect after the namespaces are committed.
+func (mgr *NamespaceManager) loadNamespaceTLS(cfg *config.Namespace) (*cert.NamespaceCerts, error) {
+	if mgr.certMgr == nil {
+		return nil, nil
+	}
+	return mgr.certMgr.LoadNamespaceTLS(cfg.Namespace, cfg.Frontend.Security, cfg.Backend.Security)
+}
+
+func checkSQLTimeout(cfg *config.SQLTimeout) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Timeout < 0 {
+		return errors.Wrapf(ErrInvalidSqlTimeout, "timeout %s is negative", cfg.Timeout)
+	}
+	for user, timeout := range cfg.Users {
+		if timeout < 0 {
+			return errors.Wrapf(ErrInvalidSqlTimeout, "timeout %s of user %s is negative", timeout, user)
+		}
+	}
+	return nil
+}
+
+// onlyHealthCheckChanged returns true if the namespace can be updated by applying the health check config
+// to the running router instead of rebuilding it.
+func onlyHealthCheckChanged(prev, cur *config.Namespace) bool {
+	if prev == nil {
+		return false
+	}
+	prevCopy, curCopy := *prev, *cur
+	prevCopy.Backend.HealthCheck, curCopy.Backend.HealthCheck = nil, nil
+	return reflect.DeepEqual(prevCopy, curCopy)
+}
+
 func (mgr *NamespaceManager) CommitNamespaces(nss []*config.Namespace, nss_delete []bool) error {
+	mgr.commitLock.Lock()
+	defer mgr.commitLock.Unlock()
+
 	nsm := make(map[string]*Namespace)
 	mgr.RLock()
 	for k, v := range mgr.nsm {
@@ -58,29 +174,93 @@ func (mgr *NamespaceManager) CommitNamespaces(nss []*config.Namespace, nss_delet
 	}
 	mgr.RUnlock()
 
+	// The replaced namespaces are closed after the new ones take effect so that the observers are not leaked.
+	// The built namespaces are closed if the commit fails.
+	var replaced, built []*Namespace
+	var deletedNames []string
+	// The certs and the health check configs are applied after all the namespaces are built.
+	// A nil value removes the certs of the namespace.
+	nsCerts := make(map[string]*cert.NamespaceCerts)
+	healthChecks := make(map[router.Router]*config.HealthCheck)
+	fail := func(err error) error {
+		for _, ns := range built {
+			ns.Close()
+		}
+		return err
+	}
 	for i, nsc := range nss {
-		if nss_delete != nil && nss_delete[i] {
+		deleted := nss_delete != nil && nss_delete[i]
+		if prev, ok := nsm[nsc.Namespace]; ok {
+			if !deleted && onlyHealthCheckChanged(prev.cfg, nsc) {
+				if err := nsc.Check(); err != nil {
+					return fail(fmt.Errorf("%w: update namespace error, namespace: %s", err, nsc.Namespace))
+				}
+				healthChecks[prev.GetRouter()] = nsc.Backend.GetHealthCheck()
+				// The namespace may be held by others, so replace it with a copy that shares the router.
+				ns := *prev
+				ns.cfg = nsc
+				nsm[ns.Name()] = &ns
+				continue
+			}
+			replaced = append(replaced, prev)
+		}
+		if deleted {
+			nsCerts[nsc.Namespace] = nil
 			delete(nsm, nsc.Namespace)
+			deletedNames = append(deletedNames, nsc.Namespace)
 			continue
 		}
 
+		certs, err := mgr.loadNamespaceTLS(nsc)
+		if err != nil {
+			return fail(fmt.Errorf("%w: load certs error, namespace: %s", err, nsc.Namespace))
+		}
 		ns, err := mgr.buildNamespace(nsc)
 		if err != nil {
-			return fmt.Errorf("%w: create namespace error, namespace: %s", err, nsc.Namespace)
+			return fail(fmt.Errorf("%w: create namespace error, namespace: %s", err, nsc.Namespace))
 		}
+		nsCerts[nsc.Namespace] = certs
 		nsm[ns.Name()] = ns
+		built = append(built, ns)
 	}
 
+	if mgr.certMgr != nil {
+		for name, certs := range nsCerts {
+			mgr.certMgr.ApplyNamespaceTLS(name, certs)
+		}
+	}
+	for rt, cfg := range healthChecks {
+		rt.SetHealthCheckConfig(cfg)
+	}
+	rules := sortMatchRules(nsm)
 	mgr.Lock()
+	for _, name := range deletedNames {
+		delete(mgr.pausedRebalance, name)
+		delete(mgr.drainedBackends, name)
+	}
+	for _, ns := range built {
+		mgr.restoreRouterState(ns)
+	}
 	mgr.nsm = nsm
+	mgr.rules = rules
 	mgr.Unlock()
+	for _, ns := range replaced {
+		if cur, ok := nsm[ns.Name()]; !ok || cur.breaker == nil {
+			metrics.BreakerStateGauge.DeleteLabelValues(ns.Name())
+			metrics.BreakerRejectCounter.DeleteLabelValues(ns.Name())
+		}
+		ns.Close()
+	}
 	return nil
 }
 
-func (mgr *NamespaceManager) Init(logger *zap.Logger, nscs []*config.Namespace, tpFetcher router.TopologyFetcher, httpCli *http.Client) error {
+func (mgr *NamespaceManager) Init(logger *zap.Logger, nscs []*config.Namespace, tpFetcher router.TopologyFetcher,
+	httpCli *http.Client, certMgr *cert.CertManager, zone string) error {
 	mgr.Lock()
 	mgr.tpFetcher = tpFetcher
+	mgr.zone = zone
 	mgr.httpCli = httpCli
+	mgr.certMgr = certMgr
 	mgr.logger = logger
 	mgr.Unlock()
 
@@ -107,6 +287,173 @@ func (n *NamespaceManager) GetNamespaceByUser(user string) (*Namespace, bool) {
 	return nil, false
 }
 
+// MatchNamespace chooses the namespace for a connection and explains why it's chosen.
+// The precedence is: the namespace whose user equals the connection user, the first matched rule, and then
+// the default namespace.
+func (n *NamespaceManager) MatchNamespace(info *MatchInfo) (*Namespace, *MatchResult, bool) {
+	n.RLock()
+	defer n.RUnlock()
+
+	for _, ns := range n.nsm {
+		if ns.User() == info.User {
+			return ns, &MatchResult{Namespace: ns.Name(), Reason: MatchReasonUser}, true
+		}
+	}
+	for _, rule := range n.rules {
+		if !rule.match(info) {
+			continue
+		}
+		if ns, ok := n.nsm[rule.namespace]; ok {
+			cfg := rule.cfg
+			return ns, &MatchResult{Namespace: ns.Name(), Reason: MatchReasonRule, RuleIndex: rule.index, Rule: &cfg}, true
+		}
+	}
+	if ns, ok := n.nsm["default"]; ok {
+		return ns, &MatchResult{Namespace: ns.Name(), Reason: MatchReasonDefault}, true
+	}
+	return nil, nil, false
+}
+
+// BreakerStatuses returns the status of the circuit breakers of all namespaces, sorted by namespace names.
+func (n *NamespaceManager) BreakerStatuses() []BreakerStatus {
+	n.RLock()
+	defer n.RUnlock()
+
+	statuses := make([]BreakerStatus, 0, len(n.nsm))
+	for _, ns := range n.nsm {
+		if ns.breaker != nil {
+			statuses = append(statuses, ns.breaker.Status())
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Namespace < statuses[j].Namespace
+	})
+	return statuses
+}
+
+// DrainBackend starts or stops draining the backend in the namespace. If the namespace is empty,
+// it applies to all the namespaces that contain the backend. It's kept after the namespace is rebuilt.
+func (n *NamespaceManager) DrainBackend(nsName, addr string, drain bool) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if nsName != "" {
+		ns, ok := n.nsm[nsName]
+		if !ok {
+			return errors.Wrapf(ErrNamespaceNotFound, "namespace %s", nsName)
+		}
+		err := ns.GetRouter().DrainBackend(addr, drain)
+		if err == nil || !drain {
+			n.setDrained(nsName, addr, drain)
+		}
+		return err
+	}
+	found := false
+	for name, ns := range n.nsm {
+		err := ns.GetRouter().DrainBackend(addr, drain)
+		if err == nil || !drain {
+			n.setDrained(name, addr, drain)
+		}
+		if err == nil {
+			found = true
+		} else if !errors.Is(err, router.ErrBackendNotFound) && !errors.Is(err, router.ErrDrainNotSupported) {
+			return err
+		}
+	}
+	if !found {
+		return errors.Wrapf(router.ErrBackendNotFound, "backend %s", addr)
+	}
+	return nil
+}
+
+// setDrained records the draining backend so that it keeps draining after the namespace is rebuilt.
+// NOTE: the lock should be held before calling this function.
+func (n *NamespaceManager) setDrained(nsName, addr string, drain bool) {
+	if !drain {
+		delete(n.drainedBackends[nsName], addr)
+		return
+	}
+	if n.drainedBackends == nil {
+		n.drainedBackends = make(map[string]map[string]struct{})
+	}
+	if n.drainedBackends[nsName] == nil {
+		n.drainedBackends[nsName] = make(map[string]struct{})
+	}
+	n.drainedBackends[nsName][addr] = struct{}{}
+}
+
+// DrainStatuses returns the progress of the draining backends, keyed by namespace names.
+func (n *NamespaceManager) DrainStatuses() map[string][]router.DrainStatus {
+	n.RLock()
+	defer n.RUnlock()
+
+	statuses := make(map[string][]router.DrainStatus)
+	for name, ns := range n.nsm {
+		if drainStatuses := ns.GetRouter().DrainStatuses(); len(drainStatuses) > 0 {
+			statuses[name] = drainStatuses
+		}
+	}
+	return statuses
+}
+
+// SetRebalanceConfig applies the rebalance config to all the routers.
+func (n *NamespaceManager) SetRebalanceConfig(cfg *config.Rebalance) {
+	n.Lock()
+	defer n.Unlock()
+	n.rebalanceCfg = cfg
+	for _, ns := range n.nsm {
+		ns.GetRouter().SetRebalanceConfig(cfg)
+	}
+}
+
+// PauseRebalance pauses or resumes the rebalancing of the namespace. It's kept after the namespace is rebuilt.
+func (n *NamespaceManager) PauseRebalance(nsName string, pause bool) error {
+	n.Lock()
+	defer n.Unlock()
+	ns, ok := n.nsm[nsName]
+	if !ok {
+		return errors.Wrapf(ErrNamespaceNotFound, "namespace %s", nsName)
+	}
+	if err := ns.GetRouter().PauseRebalance(pause); err != nil {
+		return err
+	}
+	if pause {
+		if n.pausedRebalance == nil {
+			n.pausedRebalance = make(map[string]struct{})
+		}
+		n.pausedRebalance[nsName] = struct{}{}
+	} else {
+		delete(n.pausedRebalance, nsName)
+	}
+	return nil
+}
+
+// RebalanceStatuses returns whether the rebalancing of each namespace is paused, sorted by namespace names.
+func (n *NamespaceManager) RebalanceStatuses() []RebalanceStatus {
+	n.RLock()
+	defer n.RUnlock()
+	statuses := make([]RebalanceStatus, 0, len(n.nsm))
+	for name := range n.nsm {
+		_, paused := n.pausedRebalance[name]
+		statuses = append(statuses, RebalanceStatus{Namespace: name, Paused: paused})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Namespace < statuses[j].Namespace
+	})
+	return statuses
+}
+
+// PreviewRebalance returns the migrations that the next round of rebalancing of the namespace would make.
+func (n *NamespaceManager) PreviewRebalance(nsName string) ([]router.Migration, error) {
+	n.RLock()
+	defer n.RUnlock()
+	ns, ok := n.nsm[nsName]
+	if !ok {
+		return nil, errors.Wrapf(ErrNamespaceNotFound, "namespace %s", nsName)
+	}
+	return ns.GetRouter().PreviewRebalance()
+}
+
 func (n *NamespaceManager) RedirectConnections() []error {
 	n.RLock()
 	defer n.RUnlock()
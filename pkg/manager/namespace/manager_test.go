@@ -0,0 +1,86 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/logger"
+	"github.com/pingcap/TiProxy/pkg/manager/cert"
+	"github.com/pingcap/TiProxy/pkg/manager/infosync"
+	"github.com/pingcap/TiProxy/pkg/manager/router"
+	"github.com/stretchr/testify/require"
+)
+
+// healthCheckRouter records the health check config applied to the router.
+type healthCheckRouter struct {
+	router.Router
+	healthCheck *config.HealthCheck
+}
+
+func (r *healthCheckRouter) SetHealthCheckConfig(cfg *config.HealthCheck) {
+	r.healthCheck = cfg
+}
+
+// Test that a failed commit changes neither the namespaces nor their certs.
+func TestCommitNamespacesFail(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	certMgr := cert.NewCertManager()
+	require.NoError(t, certMgr.Init(&config.Config{Workdir: t.TempDir()}, lg, nil))
+	t.Cleanup(certMgr.Close)
+	mgr := NewNamespaceManager()
+	nsc := &config.Namespace{
+		Namespace: "ns1",
+		Backend:   config.BackendNamespace{Security: config.TLSConfig{SkipCA: true}},
+	}
+	// The nil fetcher makes the namespaces read the backends from the config.
+	require.NoError(t, mgr.Init(lg, []*config.Namespace{nsc}, (*infosync.InfoSyncer)(nil), nil, certMgr, ""))
+	t.Cleanup(func() {
+		require.NoError(t, mgr.Close())
+	})
+	ns1, ok := mgr.GetNamespace("ns1")
+	require.True(t, ok)
+	backendTLS := certMgr.NamespaceBackendTLS("ns1")
+	require.NotNil(t, backendTLS)
+
+	// ns1 is rebuilt without certs but ns2 is invalid.
+	err := mgr.CommitNamespaces([]*config.Namespace{
+		{Namespace: "ns1"},
+		{Namespace: "ns2", Backend: config.BackendNamespace{SQLTimeout: &config.SQLTimeout{Timeout: -time.Second}}},
+	}, nil)
+	require.ErrorIs(t, err, ErrInvalidSqlTimeout)
+	ns, ok := mgr.GetNamespace("ns1")
+	require.True(t, ok)
+	require.Same(t, ns1, ns)
+	_, ok = mgr.GetNamespace("ns2")
+	require.False(t, ok)
+	require.Same(t, backendTLS, certMgr.NamespaceBackendTLS("ns1"))
+
+	// Only the health check of ns1 changes but ns2 is invalid.
+	rt := &healthCheckRouter{Router: ns1.router}
+	ns1.router = rt
+	hcNsc := *nsc
+	hcNsc.Backend.HealthCheck = &config.HealthCheck{Enable: true, Interval: time.Second}
+	err = mgr.CommitNamespaces([]*config.Namespace{
+		&hcNsc,
+		{Namespace: "ns2", Backend: config.BackendNamespace{SQLTimeout: &config.SQLTimeout{Timeout: -time.Second}}},
+	}, nil)
+	require.ErrorIs(t, err, ErrInvalidSqlTimeout)
+	require.Nil(t, rt.healthCheck)
+	ns, ok = mgr.GetNamespace("ns1")
+	require.True(t, ok)
+	require.Same(t, ns1, ns)
+	// The health check is applied to the running router after the commit succeeds.
+	require.NoError(t, mgr.CommitNamespaces([]*config.Namespace{&hcNsc}, nil))
+	require.Equal(t, time.Second, rt.healthCheck.Interval)
+	ns, ok = mgr.GetNamespace("ns1")
+	require.True(t, ok)
+	require.Same(t, &hcNsc, ns.cfg)
+
+	// The certs are removed after the commit succeeds.
+	require.NoError(t, mgr.CommitNamespaces([]*config.Namespace{{Namespace: "ns1"}}, nil))
+	require.Nil(t, certMgr.NamespaceBackendTLS("ns1"))
+}
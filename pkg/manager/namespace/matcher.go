@@ -0,0 +1,214 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
+// The reasons why a namespace is chosen.
+const (
+	MatchReasonUser    = "user"
+	MatchReasonRule    = "rule"
+	MatchReasonDefault = "default"
+)
+
+// MatchInfo is the information of a connection that is used to choose a namespace.
+type MatchInfo struct {
+	User string `json:"user"`
+	DB   string `json:"db"`
+	// ClientAddr is the source address in the proxy protocol if it's enabled.
+	ClientAddr string            `json:"client-addr"`
+	Attrs      map[string]string `json:"attrs"`
+	SNI        string            `json:"sni"`
+	// HasCert is true if the client presents a certificate. The other cert fields are empty otherwise.
+	HasCert bool   `json:"has-cert"`
+	CertCN  string `json:"cert-cn,omitempty"`
+	// CertSANs are the DNS names, email addresses and IP addresses in the client certificate.
+	CertSANs []string `json:"cert-sans,omitempty"`
+	CertURIs []string `json:"cert-uris,omitempty"`
+}
+
+// SetClientCert fills the identities of the client certificate.
+func (info *MatchInfo) SetClientCert(cert *x509.Certificate) {
+	info.HasCert = true
+	info.CertCN = cert.Subject.CommonName
+	info.CertSANs = append(append([]string{}, cert.DNSNames...), cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		info.CertSANs = append(info.CertSANs, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.CertURIs = append(info.CertURIs, uri.String())
+	}
+}
+
+// certMatchesUser checks whether the user name equals the identity of the client certificate.
+func (info *MatchInfo) certMatchesUser(field string) bool {
+	if !info.HasCert {
+		return false
+	}
+	switch field {
+	case config.CertUserCN:
+		return info.CertCN == info.User
+	case config.CertUserSAN:
+		for _, san := range info.CertSANs {
+			if san == info.User {
+				return true
+			}
+		}
+	case config.CertUserURI:
+		// E.g. the user of spiffe://example.org/ns/prod/sa/app is app.
+		for _, s := range info.CertURIs {
+			if uri, err := url.Parse(s); err == nil && uri.Path != "" && path.Base(uri.Path) == info.User {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchResult explains why a namespace is chosen.
+type MatchResult struct {
+	Namespace string `json:"namespace"`
+	Reason    string `json:"reason"`
+	// RuleIndex and Rule are only set when the reason is MatchReasonRule.
+	RuleIndex int               `json:"rule-index"`
+	Rule      *config.MatchRule `json:"rule,omitempty"`
+}
+
+func (r *MatchResult) String() string {
+	if r.Reason != MatchReasonRule {
+		return fmt.Sprintf("%s: %s", r.Namespace, r.Reason)
+	}
+	return fmt.Sprintf("%s: rules[%d] %s", r.Namespace, r.RuleIndex, r.Rule.Name)
+}
+
+type matchRule struct {
+	namespace string
+	index     int
+	cfg       config.MatchRule
+	userRegex *regexp.Regexp
+	nets      []*net.IPNet
+}
+
+func newMatchRules(nsc *config.Namespace) ([]*matchRule, error) {
+	rules := make([]*matchRule, 0, len(nsc.Frontend.Rules))
+	for i, cfg := range nsc.Frontend.Rules {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		rule := &matchRule{
+			namespace: nsc.Namespace,
+			index:     i,
+			cfg:       cfg,
+		}
+		if cfg.UserRegex != "" {
+			// Match the whole user name.
+			re, err := regexp.Compile("^(?:" + cfg.UserRegex + ")$")
+			if err != nil {
+				return nil, errors.Wrapf(config.ErrInvalidMatchRule, "regex %s: %s", cfg.UserRegex, err.Error())
+			}
+			rule.userRegex = re
+		}
+		for _, cidr := range cfg.CIDRs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, errors.Wrapf(config.ErrInvalidMatchRule, "cidr %s", cidr)
+			}
+			rule.nets = append(rule.nets, ipNet)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// sortMatchRules sorts the rules of all namespaces by the precedence.
+func sortMatchRules(nsm map[string]*Namespace) []*matchRule {
+	var rules []*matchRule
+	for _, ns := range nsm {
+		rules = append(rules, ns.rules...)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].cfg.Priority != rules[j].cfg.Priority {
+			return rules[i].cfg.Priority > rules[j].cfg.Priority
+		}
+		if rules[i].namespace != rules[j].namespace {
+			return rules[i].namespace < rules[j].namespace
+		}
+		return rules[i].index < rules[j].index
+	})
+	return rules
+}
+
+func matchGlob(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, s)
+	return err == nil && matched
+}
+
+// matchAnyGlob returns true if the pattern is empty or it matches any of the strings.
+func matchAnyGlob(pattern string, ss []string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, s := range ss {
+		if matchGlob(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *matchRule) match(info *MatchInfo) bool {
+	if !matchGlob(r.cfg.User, info.User) || !matchGlob(r.cfg.DB, info.DB) || !matchGlob(r.cfg.SNI, info.SNI) {
+		return false
+	}
+	if r.userRegex != nil && !r.userRegex.MatchString(info.User) {
+		return false
+	}
+	if r.cfg.CertCN != "" && (!info.HasCert || !matchGlob(r.cfg.CertCN, info.CertCN)) {
+		return false
+	}
+	if !matchAnyGlob(r.cfg.CertSAN, info.CertSANs) || !matchAnyGlob(r.cfg.CertURI, info.CertURIs) {
+		return false
+	}
+	for k, pattern := range r.cfg.Attrs {
+		v, ok := info.Attrs[k]
+		if !ok || !matchGlob(pattern, v) {
+			return false
+		}
+	}
+	if len(r.nets) > 0 {
+		host, _, err := net.SplitHostPort(info.ClientAddr)
+		if err != nil {
+			host = info.ClientAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		matched := false
+		for _, ipNet := range r.nets {
+			if ipNet.Contains(ip) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newMatchTestManager(t *testing.T, nscs []*config.Namespace) *NamespaceManager {
+	mgr := NewNamespaceManager()
+	mgr.nsm = make(map[string]*Namespace, len(nscs))
+	for _, nsc := range nscs {
+		rules, err := newMatchRules(nsc)
+		require.NoError(t, err)
+		mgr.nsm[nsc.Namespace] = &Namespace{name: nsc.Namespace, user: nsc.Frontend.User, cfg: nsc, rules: rules}
+	}
+	mgr.rules = sortMatchRules(mgr.nsm)
+	return mgr
+}
+
+func TestMatchNamespace(t *testing.T) {
+	mgr := newMatchTestManager(t, []*config.Namespace{
+		{Namespace: "default"},
+		{
+			Namespace: "exact",
+			Frontend:  config.FrontendNamespace{User: "root"},
+		},
+		{
+			Namespace: "app",
+			Frontend: config.FrontendNamespace{
+				Rules: []config.MatchRule{
+					{Name: "app-user", User: "app_*"},
+					{Name: "app-regex", UserRegex: "svc[0-9]+"},
+					{Name: "app-db", DB: "app_db"},
+				},
+			},
+		},
+		{
+			Namespace: "internal",
+			Frontend: config.FrontendNamespace{
+				Rules: []config.MatchRule{
+					{Name: "office", Priority: 10, CIDRs: []string{"10.0.0.0/8"}, Attrs: map[string]string{"program_name": "mysql*"}},
+					{Name: "sni", SNI: "*.internal.tiproxy"},
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		info      MatchInfo
+		namespace string
+		reason    string
+		rule      string
+	}{
+		{MatchInfo{User: "root", ClientAddr: "10.0.0.1:3000"}, "exact", MatchReasonUser, ""},
+		{MatchInfo{User: "app_1"}, "app", MatchReasonRule, "app-user"},
+		{MatchInfo{User: "svc12"}, "app", MatchReasonRule, "app-regex"},
+		// The regex must match the whole user name.
+		{MatchInfo{User: "svc12x"}, "default", MatchReasonDefault, ""},
+		{MatchInfo{User: "u1", DB: "app_db"}, "app", MatchReasonRule, "app-db"},
+		// The rule with a higher priority is evaluated first.
+		{MatchInfo{User: "app_1", ClientAddr: "10.0.0.1:3000", Attrs: map[string]string{"program_name": "mysql"}}, "internal", MatchReasonRule, "office"},
+		// All the conditions in a rule must be satisfied.
+		{MatchInfo{User: "u1", ClientAddr: "10.0.0.1:3000"}, "default", MatchReasonDefault, ""},
+		{MatchInfo{User: "u1", ClientAddr: "192.168.0.1:3000", Attrs: map[string]string{"program_name": "mysql"}}, "default", MatchReasonDefault, ""},
+		// Namespaces are sorted by names when priorities are equal.
+		{MatchInfo{User: "app_1", SNI: "a.internal.tiproxy"}, "app", MatchReasonRule, "app-user"},
+		{MatchInfo{User: "u1", SNI: "a.internal.tiproxy"}, "internal", MatchReasonRule, "sni"},
+	}
+	for i, test := range tests {
+		ns, result, ok := mgr.MatchNamespace(&test.info)
+		require.True(t, ok, "case %d", i)
+		require.Equal(t, test.namespace, ns.Name(), "case %d", i)
+		require.Equal(t, test.namespace, result.Namespace, "case %d", i)
+		require.Equal(t, test.reason, result.Reason, "case %d", i)
+		if test.rule != "" {
+			require.Equal(t, test.rule, result.Rule.Name, "case %d", i)
+		} else {
+			require.Nil(t, result.Rule, "case %d", i)
+		}
+	}
+
+	// No namespace is matched without the default namespace.
+	delete(mgr.nsm, "default")
+	_, _, ok := mgr.MatchNamespace(&MatchInfo{User: "u1"})
+	require.False(t, ok)
+}
+
+func TestHashKey(t *testing.T) {
+	info := &MatchInfo{User: "u1", DB: "db1", ClientAddr: "10.0.0.1:3000", Attrs: map[string]string{"app": "a1"}}
+	ns := &Namespace{}
+	require.Empty(t, ns.HashKey(info))
+	tests := []struct {
+		cfg config.ConsistentHash
+		key string
+	}{
+		{config.ConsistentHash{Key: config.HashKeyClientIP}, "10.0.0.1"},
+		{config.ConsistentHash{Key: config.HashKeyUser}, "u1"},
+		{config.ConsistentHash{Key: config.HashKeyDB}, "db1"},
+		{config.ConsistentHash{Key: config.HashKeyAttr, Attr: "app"}, "a1"},
+		{config.ConsistentHash{Key: config.HashKeyAttr, Attr: "unknown"}, ""},
+	}
+	for i, test := range tests {
+		test := test
+		ns.hashCfg = &test.cfg
+		require.Equal(t, test.key, ns.HashKey(info), "case %d", i)
+	}
+}
+
+func TestMatchClientCert(t *testing.T) {
+	mgr := newMatchTestManager(t, []*config.Namespace{
+		{Namespace: "default"},
+		{
+			Namespace: "mtls",
+			Frontend: config.FrontendNamespace{
+				Rules: []config.MatchRule{
+					{Name: "cn", CertCN: "app-*", CertUser: config.CertUserCN},
+					{Name: "san", CertSAN: "*.svc.cluster.local"},
+					{Name: "spiffe", CertURI: "spiffe://example.org/ns/prod/sa/*", CertUser: config.CertUserURI},
+				},
+			},
+		},
+	})
+	mgr.nsm["mtls"].requireClientCert = true
+
+	spiffeID, err := url.Parse("spiffe://example.org/ns/prod/sa/svc1")
+	require.NoError(t, err)
+	certs := []*x509.Certificate{
+		{Subject: pkix.Name{CommonName: "app-1"}},
+		{Subject: pkix.Name{CommonName: "db"}, DNSNames: []string{"db.svc.cluster.local"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+		{URIs: []*url.URL{spiffeID}},
+	}
+	tests := []struct {
+		user      string
+		cert      *x509.Certificate
+		namespace string
+		rule      string
+		err       error
+	}{
+		{"app-1", certs[0], "mtls", "cn", nil},
+		{"u1", certs[0], "mtls", "cn", ErrCertUserMismatch},
+		{"u1", certs[1], "mtls", "san", nil},
+		{"svc1", certs[2], "mtls", "spiffe", nil},
+		{"svc2", certs[2], "mtls", "spiffe", ErrCertUserMismatch},
+		// The cert conditions are not satisfied without a client certificate.
+		{"app-1", nil, "default", "", nil},
+	}
+	for i, test := range tests {
+		info := &MatchInfo{User: test.user}
+		if test.cert != nil {
+			info.SetClientCert(test.cert)
+		}
+		ns, result, ok := mgr.MatchNamespace(info)
+		require.True(t, ok, "case %d", i)
+		require.Equal(t, test.namespace, ns.Name(), "case %d", i)
+		if test.rule != "" {
+			require.Equal(t, test.rule, result.Rule.Name, "case %d", i)
+		}
+		err := ns.CheckClientCert(info, result)
+		if test.err != nil {
+			require.ErrorIs(t, err, test.err, "case %d", i)
+		} else {
+			require.NoError(t, err, "case %d", i)
+		}
+	}
+
+	// The namespace requires a client certificate.
+	info := &MatchInfo{User: "u1"}
+	require.ErrorIs(t, mgr.nsm["mtls"].CheckClientCert(info, nil), ErrClientCertRequired)
+	info.SetClientCert(certs[1])
+	require.Equal(t, []string{"db.svc.cluster.local", "10.0.0.1"}, info.CertSANs)
+	require.NoError(t, mgr.nsm["mtls"].CheckClientCert(info, nil))
+}
@@ -7,13 +7,33 @@
 package namespace
 
 import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/pkg/manager/cert"
 	"github.com/pingcap/TiProxy/pkg/manager/router"
 )
 
 type Namespace struct {
-	name   string
-	user   string
-	router router.Router
+	name string
+	user string
+	// cfg is the config that the namespace is built from. It's only accessed when committing namespaces.
+	cfg     *config.Namespace
+	router  router.Router
+	certMgr *cert.CertManager
+	rules   []*matchRule
+	breaker *CircuitBreaker
+	// sqlTimeout is nil if the statements are not limited.
+	sqlTimeout *config.SQLTimeout
+	// hashCfg is nil if the namespace doesn't use the consistent-hash selector.
+	hashCfg *config.ConsistentHash
+	// requireClientCert rejects the connections without client certificates.
+	requireClientCert bool
+	// accessFilter is nil if the namespace doesn't have an access list.
+	accessFilter *AccessFilter
 }
 
 func (n *Namespace) Name() string {
@@ -28,6 +48,70 @@ func (n *Namespace) GetRouter() router.Router {
 	return n.router
 }
 
+// BackendTLS returns the TLS config to connect to the backends of the namespace.
+// It returns nil if the namespace doesn't specify backend certs.
+func (n *Namespace) BackendTLS() *tls.Config {
+	if n.certMgr == nil {
+		return nil
+	}
+	return n.certMgr.NamespaceBackendTLS(n.name)
+}
+
+// SQLTimeout returns the maximum duration of each statement of the user. Zero means no limit.
+func (n *Namespace) SQLTimeout(user string) time.Duration {
+	if n.sqlTimeout == nil {
+		return 0
+	}
+	return n.sqlTimeout.GetTimeout(user)
+}
+
+// HashKey returns the key that the consistent-hash router hashes the connection by.
+// It returns an empty string if the namespace doesn't use the consistent-hash selector or the key is missing.
+func (n *Namespace) HashKey(info *MatchInfo) string {
+	if n.hashCfg == nil {
+		return ""
+	}
+	switch n.hashCfg.Key {
+	case config.HashKeyUser:
+		return info.User
+	case config.HashKeyDB:
+		return info.DB
+	case config.HashKeyAttr:
+		return info.Attrs[n.hashCfg.Attr]
+	}
+	// The port changes across reconnects, so only the IP is hashed.
+	host, _, err := net.SplitHostPort(info.ClientAddr)
+	if err != nil {
+		return info.ClientAddr
+	}
+	return host
+}
+
+// CheckClientCert checks the client certificate against the namespace and the matched rule.
+// The result may be nil, and then only the namespace is checked.
+func (n *Namespace) CheckClientCert(info *MatchInfo, result *MatchResult) error {
+	if n.requireClientCert && !info.HasCert {
+		return errors.Wrapf(ErrClientCertRequired, "namespace %s", n.name)
+	}
+	if result == nil || result.Rule == nil || result.Rule.CertUser == "" {
+		return nil
+	}
+	if !info.certMatchesUser(result.Rule.CertUser) {
+		return errors.Wrapf(ErrCertUserMismatch, "user %s, rule %s, cert-user %s", info.User, result.Rule.Name, result.Rule.CertUser)
+	}
+	return nil
+}
+
+// CheckClientAddr checks the client address against the access list of the namespace.
+func (n *Namespace) CheckClientAddr(addr string) error {
+	return n.accessFilter.Check(addr)
+}
+
+// Breaker returns the circuit breaker of the namespace. It returns nil if the circuit breaker is disabled.
+func (n *Namespace) Breaker() *CircuitBreaker {
+	return n.breaker
+}
+
 func (n *Namespace) Close() {
 	n.router.Close()
 }
@@ -5,6 +5,7 @@ package router
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/TiProxy/lib/config"
@@ -21,6 +22,11 @@ type BackendFetcher interface {
 	GetBackendList(context.Context) (map[string]*BackendInfo, error)
 }
 
+// healthCheckConfigSetter is implemented by the BackendFetchers that rely on the health check config.
+type healthCheckConfigSetter interface {
+	SetHealthCheckConfig(cfg *config.HealthCheck)
+}
+
 // TopologyFetcher is an interface to fetch the tidb topology from ETCD.
 type TopologyFetcher interface {
 	GetTiDBTopology(ctx context.Context) (map[string]*infosync.TiDBInfo, error)
@@ -30,16 +36,23 @@ type TopologyFetcher interface {
 type PDFetcher struct {
 	tpFetcher TopologyFetcher
 	logger    *zap.Logger
-	config    *config.HealthCheck
+	// config may be updated while fetching.
+	config atomic.Pointer[config.HealthCheck]
 }
 
 func NewPDFetcher(tpFetcher TopologyFetcher, logger *zap.Logger, config *config.HealthCheck) *PDFetcher {
-	config.Check()
-	return &PDFetcher{
+	pf := &PDFetcher{
 		tpFetcher: tpFetcher,
 		logger:    logger,
-		config:    config,
 	}
+	pf.SetHealthCheckConfig(config)
+	return pf
+}
+
+// SetHealthCheckConfig updates the health check config, which takes effect from the next fetch.
+func (pf *PDFetcher) SetHealthCheckConfig(cfg *config.HealthCheck) {
+	cfg.Check()
+	pf.config.Store(cfg)
 }
 
 func (pf *PDFetcher) GetBackendList(ctx context.Context) (map[string]*BackendInfo, error) {
@@ -57,6 +70,7 @@ func (pf *PDFetcher) GetBackendList(ctx context.Context) (map[string]*BackendInf
 		infos[addr] = &BackendInfo{
 			IP:         backend.IP,
 			StatusPort: backend.StatusPort,
+			Labels:     backend.Labels,
 		}
 	}
 	return infos, nil
@@ -69,7 +83,7 @@ func (pf *PDFetcher) fetchBackendList(ctx context.Context) map[string]*infosync.
 		var err error
 		backends, err = pf.tpFetcher.GetTiDBTopology(ctx)
 		return err
-	}, ctx, pf.config.RetryInterval, retry.InfiniteCnt,
+	}, ctx, pf.config.Load().RetryInterval, retry.InfiniteCnt,
 		func(err error, duration time.Duration) {
 			// Ignore errors when TiProxy shuts down.
 			if ctx.Err() != nil {
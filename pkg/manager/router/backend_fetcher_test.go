@@ -6,6 +6,7 @@ package router
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/pingcap/TiProxy/lib/util/logger"
 	"github.com/pingcap/TiProxy/pkg/manager/infosync"
@@ -115,6 +116,14 @@ func TestPDFetcher(t *testing.T) {
 		test.check(info)
 		require.NoError(t, err)
 	}
+
+	// The health check config is updated along with the observer.
+	cfg := newHealthCheckConfigForTest()
+	cfg.RetryInterval = time.Minute
+	bo, err := NewBackendObserver(lg, nil, nil, newHealthCheckConfigForTest(), pf)
+	require.NoError(t, err)
+	bo.SetHealthCheckConfig(cfg)
+	require.Equal(t, time.Minute, pf.config.Load().RetryInterval)
 }
 
 type mockTpFetcher struct {
@@ -0,0 +1,230 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+// The metrics of TiDB that indicate the load.
+const (
+	metricMemoryUsage     = "process_resident_memory_bytes"
+	metricCPUSeconds      = "process_cpu_seconds_total"
+	metricMaxProcs        = "tidb_server_maxprocs"
+	metricQueryDuration   = "tidb_server_handle_query_duration_seconds"
+	queryDurationQuantile = 0.99
+)
+
+// tidbStatus is the response of the status API of TiDB.
+type tidbStatus struct {
+	Connections int `json:"connections"`
+}
+
+func parseStatus(r io.Reader) (*tidbStatus, error) {
+	var status tidbStatus
+	if err := json.NewDecoder(r).Decode(&status); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &status, nil
+}
+
+// loadSample is the load of a backend scraped from its metrics and status API.
+type loadSample struct {
+	time       time.Time
+	memory     float64
+	cpuSeconds float64
+	maxProcs   float64
+	// queryBuckets are the cumulative counts of the query duration histogram, keyed by the upper bounds.
+	queryBuckets map[float64]uint64
+	queryCount   uint64
+	// status is nil if the status API returns nothing recognized.
+	status *tidbStatus
+}
+
+// loadState is the load status of a backend. The status changes only after the evaluations are the same
+// in several consecutive checks, so that the backend doesn't flap between healthy and busy.
+type loadState struct {
+	sample *loadSample
+	status BackendStatus
+	reason string
+	// failures and successes count the consecutive evaluations that differ from the status.
+	failures  int
+	successes int
+}
+
+func newLoadState() *loadState {
+	return &loadState{status: StatusHealthy}
+}
+
+// update evaluates the new sample and changes the status if the thresholds of consecutive checks are reached.
+// A busy backend turns to another busy status immediately because it's busy anyway.
+func (ls *loadState) update(cfg *config.LoadThreshold, sample *loadSample) {
+	status, reason := evaluateLoad(cfg, ls.sample, sample)
+	ls.sample = sample
+	switch {
+	case status == StatusHealthy && ls.status != StatusHealthy:
+		ls.failures = 0
+		if ls.successes++; ls.successes >= cfg.ConsecutiveSuccesses {
+			ls.status, ls.reason, ls.successes = status, reason, 0
+		}
+	case status != StatusHealthy && ls.status == StatusHealthy:
+		ls.successes = 0
+		if ls.failures++; ls.failures >= cfg.ConsecutiveFailures {
+			ls.status, ls.reason, ls.failures = status, reason, 0
+		}
+	default:
+		ls.status, ls.reason, ls.failures, ls.successes = status, reason, 0, 0
+	}
+}
+
+// scrapeLoad reads the metrics of the backend if any threshold needs them. It returns nil if it fails,
+// and then the load status of the backend stays the same.
+func (bo *BackendObserver) scrapeLoad(addr string, info *BackendInfo, status *tidbStatus) *loadSample {
+	if !bo.healthCheckConfig.Load.NeedMetrics() {
+		if status == nil {
+			return nil
+		}
+		return &loadSample{time: time.Now(), status: status}
+	}
+	httpCli := *bo.httpCli
+	httpCli.Timeout = bo.healthCheckConfig.DialTimeout
+	resp, err := httpCli.Get(bo.statusURL(info, metricsPathSuffix))
+	if err != nil {
+		bo.logger.Debug("scrape backend metrics failed", zap.String("backend_addr", addr), zap.Error(err))
+		return nil
+	}
+	defer func() {
+		if ignoredErr := resp.Body.Close(); ignoredErr != nil {
+			bo.logger.Warn("close http response in scraping metrics failed", zap.Error(ignoredErr))
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		bo.logger.Debug("scrape backend metrics failed", zap.String("backend_addr", addr), zap.Int("http_status", resp.StatusCode))
+		return nil
+	}
+	sample, err := parseLoadSample(resp.Body, time.Now())
+	if err != nil {
+		bo.logger.Debug("parse backend metrics failed", zap.String("backend_addr", addr), zap.Error(err))
+		return nil
+	}
+	sample.status = status
+	return sample
+}
+
+func parseLoadSample(r io.Reader, now time.Time) (*loadSample, error) {
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sample := &loadSample{time: now}
+	if mf, ok := mfs[metricMemoryUsage]; ok {
+		sample.memory = sumMetricFamily(mf)
+	}
+	if mf, ok := mfs[metricCPUSeconds]; ok {
+		sample.cpuSeconds = sumMetricFamily(mf)
+	}
+	if mf, ok := mfs[metricMaxProcs]; ok {
+		sample.maxProcs = sumMetricFamily(mf)
+	}
+	// The histogram has a label of statement types, so the buckets of all types are added up.
+	if mf, ok := mfs[metricQueryDuration]; ok && mf.GetType() == dto.MetricType_HISTOGRAM {
+		sample.queryBuckets = make(map[float64]uint64)
+		for _, m := range mf.GetMetric() {
+			histogram := m.GetHistogram()
+			sample.queryCount += histogram.GetSampleCount()
+			for _, bucket := range histogram.GetBucket() {
+				sample.queryBuckets[bucket.GetUpperBound()] += bucket.GetCumulativeCount()
+			}
+		}
+	}
+	return sample, nil
+}
+
+func sumMetricFamily(mf *dto.MetricFamily) float64 {
+	var sum float64
+	for _, m := range mf.GetMetric() {
+		switch mf.GetType() {
+		case dto.MetricType_GAUGE:
+			sum += m.GetGauge().GetValue()
+		case dto.MetricType_COUNTER:
+			sum += m.GetCounter().GetValue()
+		case dto.MetricType_UNTYPED:
+			sum += m.GetUntyped().GetValue()
+		}
+	}
+	return sum
+}
+
+// evaluateLoad compares the load with the thresholds and returns the status and the reason.
+// The CPU usage and query duration are calculated in the interval between the 2 samples,
+// so they are not checked until the second sample.
+func evaluateLoad(cfg *config.LoadThreshold, prev, cur *loadSample) (BackendStatus, string) {
+	if cfg.MemoryUsage > 0 && cur.memory > float64(cfg.MemoryUsage) {
+		return StatusMemoryHigh, fmt.Sprintf("memory usage %.0f bytes exceeds %d bytes", cur.memory, cfg.MemoryUsage)
+	}
+	if cfg.Connections > 0 && cur.status != nil && cur.status.Connections > cfg.Connections {
+		return StatusRunSlow, fmt.Sprintf("%d connections exceed %d", cur.status.Connections, cfg.Connections)
+	}
+	if prev == nil {
+		return StatusHealthy, ""
+	}
+	if cfg.CPUUsage > 0 && cur.maxProcs > 0 {
+		elapsed := cur.time.Sub(prev.time).Seconds()
+		// The counter is reset if the backend restarts.
+		if elapsed > 0 && cur.cpuSeconds >= prev.cpuSeconds {
+			usage := (cur.cpuSeconds - prev.cpuSeconds) / elapsed / cur.maxProcs * 100
+			if usage > float64(cfg.CPUUsage) {
+				return StatusRunSlow, fmt.Sprintf("cpu usage %.0f%% exceeds %d%%", usage, cfg.CPUUsage)
+			}
+		}
+	}
+	if cfg.QueryDuration > 0 {
+		if duration, ok := histogramQuantile(prev, cur, queryDurationQuantile); ok && duration > cfg.QueryDuration {
+			return StatusRunSlow, fmt.Sprintf("p99 query duration %s exceeds %s", duration, cfg.QueryDuration)
+		}
+	}
+	return StatusHealthy, ""
+}
+
+// histogramQuantile returns the upper bound of the bucket that contains the quantile of the queries
+// between the 2 samples. It returns false if there are no queries.
+func histogramQuantile(prev, cur *loadSample, q float64) (time.Duration, bool) {
+	if cur.queryCount <= prev.queryCount {
+		return 0, false
+	}
+	total := cur.queryCount - prev.queryCount
+	bounds := make([]float64, 0, len(cur.queryBuckets))
+	for bound := range cur.queryBuckets {
+		if !math.IsInf(bound, 1) {
+			bounds = append(bounds, bound)
+		}
+	}
+	if len(bounds) == 0 {
+		return 0, false
+	}
+	sort.Float64s(bounds)
+	for _, bound := range bounds {
+		if cur.queryBuckets[bound] < prev.queryBuckets[bound] {
+			return 0, false
+		}
+		if float64(cur.queryBuckets[bound]-prev.queryBuckets[bound]) >= q*float64(total) {
+			return time.Duration(bound * float64(time.Second)), true
+		}
+	}
+	// The quantile falls in the +Inf bucket, so the largest bound is the best estimation.
+	return time.Duration(bounds[len(bounds)-1] * float64(time.Second)), true
+}
@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -61,6 +62,10 @@ type backendHealth struct {
 	status        BackendStatus
 	pingErr       error
 	serverVersion string
+	// labels are the topology labels of the backend, which are used to group backends.
+	labels map[string]string
+	// reason explains why the backend is busy.
+	reason string
 }
 
 func (bh *backendHealth) String() string {
@@ -68,11 +73,15 @@ func (bh *backendHealth) String() string {
 	if bh.pingErr != nil {
 		str += fmt.Sprintf(", err: %s", bh.pingErr.Error())
 	}
+	if len(bh.reason) > 0 {
+		str += fmt.Sprintf(", reason: %s", bh.reason)
+	}
 	return str
 }
 
 const (
-	statusPathSuffix = "/status"
+	statusPathSuffix  = "/status"
+	metricsPathSuffix = "/metrics"
 )
 
 // BackendEventReceiver receives the event of backend status change.
@@ -85,6 +94,7 @@ type BackendEventReceiver interface {
 type BackendInfo struct {
 	IP         string
 	StatusPort uint
+	Labels     map[string]string
 }
 
 // BackendObserver refreshes backend list and notifies BackendEventReceiver.
@@ -100,6 +110,12 @@ type BackendObserver struct {
 	wg             waitgroup.WaitGroup
 	cancelFunc     context.CancelFunc
 	refreshChan    chan struct{}
+	// pendingConfig is set by SetHealthCheckConfig and applied by the observing goroutine,
+	// so that healthCheckConfig is only accessed by one goroutine.
+	pendingConfig atomic.Pointer[config.HealthCheck]
+	configChan    chan struct{}
+	// loadStates are the load statuses and the last samples of the backends, which are used to calculate the rates.
+	loadStates map[string]*loadState
 }
 
 // StartBackendObserver creates a BackendObserver and starts watching.
@@ -131,6 +147,8 @@ func NewBackendObserver(logger *zap.Logger, eventReceiver BackendEventReceiver,
 		httpTLS:           httpTLS,
 		eventReceiver:     eventReceiver,
 		refreshChan:       make(chan struct{}),
+		configChan:        make(chan struct{}, 1),
+		loadStates:        make(map[string]*loadState),
 	}
 	bo.fetcher = backendFetcher
 	return bo, nil
@@ -154,8 +172,30 @@ func (bo *BackendObserver) Refresh() {
 	}
 }
 
+// SetHealthCheckConfig updates the health check config without restarting the observer.
+// The new config takes effect from the next round, which starts immediately.
+func (bo *BackendObserver) SetHealthCheckConfig(cfg *config.HealthCheck) {
+	cfg.Check()
+	if setter, ok := bo.fetcher.(healthCheckConfigSetter); ok {
+		setter.SetHealthCheckConfig(cfg)
+	}
+	bo.pendingConfig.Store(cfg)
+	select {
+	case bo.configChan <- struct{}{}:
+	default:
+	}
+}
+
+func (bo *BackendObserver) applyPendingConfig() {
+	if cfg := bo.pendingConfig.Swap(nil); cfg != nil {
+		bo.logger.Info("update health check config", zap.Any("cfg", cfg))
+		bo.healthCheckConfig = cfg
+	}
+}
+
 func (bo *BackendObserver) observe(ctx context.Context) {
 	for ctx.Err() == nil {
+		bo.applyPendingConfig()
 		backendInfo, err := bo.fetcher.GetBackendList(ctx)
 		if err != nil {
 			bo.logger.Error("fetching backends encounters error", zap.Error(err))
@@ -170,6 +210,7 @@ func (bo *BackendObserver) observe(ctx context.Context) {
 		select {
 		case <-time.After(bo.healthCheckConfig.Interval):
 		case <-bo.refreshChan:
+		case <-bo.configChan:
 		case <-ctx.Done():
 			return
 		}
@@ -187,13 +228,25 @@ func (bo *BackendObserver) connectWithRetry(ctx context.Context, connect func()
 	return err
 }
 
+func (bo *BackendObserver) statusURL(info *BackendInfo, path string) string {
+	schema := "http"
+	if bo.httpTLS {
+		schema = "https"
+	}
+	return fmt.Sprintf("%s://%s:%d%s", schema, info.IP, info.StatusPort, path)
+}
+
 func (bo *BackendObserver) checkHealth(ctx context.Context, backends map[string]*BackendInfo) map[string]*backendHealth {
 	curBackendHealth := make(map[string]*backendHealth, len(backends))
+	loadStates := make(map[string]*loadState, len(bo.loadStates))
 	for addr, info := range backends {
 		bh := &backendHealth{
 			status: StatusHealthy,
 		}
 		curBackendHealth[addr] = bh
+		if info != nil {
+			bh.labels = info.Labels
+		}
 		if !bo.healthCheckConfig.Enable {
 			continue
 		}
@@ -202,21 +255,23 @@ func (bo *BackendObserver) checkHealth(ctx context.Context, backends map[string]
 		}
 
 		// Skip checking the status port if it's not fetched.
+		var status *tidbStatus
 		if info != nil && len(info.IP) > 0 {
 			// When a backend gracefully shut down, the status port returns 500 but the SQL port still accepts
 			// new connections, so we must check the status port first.
-			schema := "http"
-			if bo.httpTLS {
-				schema = "https"
-			}
 			httpCli := *bo.httpCli
 			httpCli.Timeout = bo.healthCheckConfig.DialTimeout
-			url := fmt.Sprintf("%s://%s:%d%s", schema, info.IP, info.StatusPort, statusPathSuffix)
+			url := bo.statusURL(info, statusPathSuffix)
 			err := bo.connectWithRetry(ctx, func() error {
 				resp, err := httpCli.Get(url)
 				if err == nil {
 					if resp.StatusCode != http.StatusOK {
 						err = backoff.Permanent(errors.Errorf("http status %d", resp.StatusCode))
+					} else if bo.healthCheckConfig.Load.Connections > 0 {
+						var parseErr error
+						if status, parseErr = parseStatus(resp.Body); parseErr != nil {
+							bo.logger.Debug("parse backend status failed", zap.String("backend_addr", addr), zap.Error(parseErr))
+						}
 					}
 					if ignoredErr := resp.Body.Close(); ignoredErr != nil {
 						bo.logger.Warn("close http response in health check failed", zap.Error(ignoredErr))
@@ -253,41 +308,61 @@ func (bo *BackendObserver) checkHealth(ctx context.Context, backends map[string]
 		if err != nil {
 			bh.status = StatusCannotConnect
 			bh.pingErr = errors.Wrapf(err, "connect sql port failed")
+			continue
+		}
+
+		if bo.healthCheckConfig.Load.Enabled() && info != nil && len(info.IP) > 0 {
+			state, ok := bo.loadStates[addr]
+			if !ok {
+				state = newLoadState()
+			}
+			if sample := bo.scrapeLoad(addr, info, status); sample != nil {
+				state.update(&bo.healthCheckConfig.Load, sample)
+			}
+			bh.status, bh.reason = state.status, state.reason
+			loadStates[addr] = state
 		}
 	}
+	bo.loadStates = loadStates
 	return curBackendHealth
 }
 
 func (bo *BackendObserver) notifyIfChanged(bhMap map[string]*backendHealth) {
 	updatedBackends := make(map[string]*backendHealth)
 	for addr, lastHealth := range bo.curBackendInfo {
-		if lastHealth.status == StatusHealthy {
+		if lastHealth.status != StatusCannotConnect {
 			if newHealth, ok := bhMap[addr]; !ok {
 				updatedBackends[addr] = &backendHealth{
 					status:  StatusCannotConnect,
 					pingErr: errors.New("removed from backend list"),
 				}
 				updateBackendStatusMetrics(addr, lastHealth.status, StatusCannotConnect)
-			} else if newHealth.status != StatusHealthy {
+			} else if newHealth.status != lastHealth.status {
 				updatedBackends[addr] = newHealth
 				updateBackendStatusMetrics(addr, lastHealth.status, newHealth.status)
 			}
 		}
 	}
 	for addr, newHealth := range bhMap {
-		if newHealth.status == StatusHealthy {
+		if _, ok := updatedBackends[addr]; ok {
+			continue
+		}
+		if newHealth.status != StatusCannotConnect {
 			lastHealth, ok := bo.curBackendInfo[addr]
 			if !ok {
 				lastHealth = &backendHealth{
 					status: StatusCannotConnect,
 				}
 			}
-			if lastHealth.status != StatusHealthy {
+			if lastHealth.status != newHealth.status {
 				updatedBackends[addr] = newHealth
 				updateBackendStatusMetrics(addr, lastHealth.status, newHealth.status)
 			} else if lastHealth.serverVersion != newHealth.serverVersion {
 				// Not possible here: the backend finishes upgrading between two health checks.
 				updatedBackends[addr] = newHealth
+			} else if !labelsEqual(lastHealth.labels, newHealth.labels) {
+				// The labels are updated online, which may change the group of the backend.
+				updatedBackends[addr] = newHealth
 			}
 		}
 	}
@@ -296,6 +371,18 @@ func (bo *BackendObserver) notifyIfChanged(bhMap map[string]*backendHealth) {
 	bo.curBackendInfo = bhMap
 }
 
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
 // Close releases all resources.
 func (bo *BackendObserver) Close() {
 	if bo.cancelFunc != nil {
@@ -5,6 +5,7 @@ package router
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"strconv"
@@ -84,6 +85,95 @@ func TestObserveBackends(t *testing.T) {
 	backend2.close()
 }
 
+// Test that the backends are marked busy when their load exceeds the thresholds.
+func TestObserveLoad(t *testing.T) {
+	ts := newObserverTestSuite(t)
+	t.Cleanup(ts.close)
+	ts.bo.healthCheckConfig.Load = config.LoadThreshold{
+		MemoryUsage:          1 << 30,
+		QueryDuration:        time.Second,
+		Connections:          100,
+		ConsecutiveFailures:  1,
+		ConsecutiveSuccesses: 1,
+	}
+	backend := ts.addBackend()
+	backend.setMetrics("process_resident_memory_bytes 2147483648\n")
+	ts.bo.Start()
+	ts.checkStatus(backend, StatusMemoryHigh)
+	backend.setMetrics("process_resident_memory_bytes 1048576\n")
+	ts.checkStatus(backend, StatusHealthy)
+
+	// Only the queries after the last sample are counted.
+	histogram := func(fast, slow int) string {
+		return fmt.Sprintf(`# TYPE tidb_server_handle_query_duration_seconds histogram
+tidb_server_handle_query_duration_seconds_bucket{sql_type="Select",le="0.5"} %d
+tidb_server_handle_query_duration_seconds_bucket{sql_type="Select",le="4"} %d
+tidb_server_handle_query_duration_seconds_bucket{sql_type="Select",le="+Inf"} %d
+tidb_server_handle_query_duration_seconds_sum{sql_type="Select"} 0
+tidb_server_handle_query_duration_seconds_count{sql_type="Select"} %d
+`, fast, fast+slow, fast+slow, fast+slow)
+	}
+	backend.setMetrics(histogram(1000, 100))
+	ts.checkStatus(backend, StatusRunSlow)
+	backend.setMetrics(histogram(2000, 100))
+	ts.checkStatus(backend, StatusHealthy)
+
+	// The connections are read from the status API.
+	backend.setStatus(`{"connections":200,"version":"8.0.11-TiDB-None"}`)
+	ts.checkStatus(backend, StatusRunSlow)
+	backend.setStatus(`{"connections":10,"version":"8.0.11-TiDB-None"}`)
+	ts.checkStatus(backend, StatusHealthy)
+	backend.close()
+}
+
+// Test that the load status changes only after the consecutive evaluations reach the thresholds.
+func TestLoadHysteresis(t *testing.T) {
+	cfg := &config.LoadThreshold{Connections: 100, ConsecutiveFailures: 2, ConsecutiveSuccesses: 3}
+	busy := &loadSample{status: &tidbStatus{Connections: 200}}
+	idle := &loadSample{status: &tidbStatus{Connections: 10}}
+	state := newLoadState()
+	for _, test := range []struct {
+		sample *loadSample
+		status BackendStatus
+	}{
+		{busy, StatusHealthy},
+		// A healthy evaluation resets the counter.
+		{idle, StatusHealthy},
+		{busy, StatusHealthy},
+		{busy, StatusRunSlow},
+		{idle, StatusRunSlow},
+		{idle, StatusRunSlow},
+		{busy, StatusRunSlow},
+		{idle, StatusRunSlow},
+		{idle, StatusRunSlow},
+		{idle, StatusHealthy},
+	} {
+		state.update(cfg, test.sample)
+		require.Equal(t, test.status, state.status)
+	}
+	require.Empty(t, state.reason)
+}
+
+func TestEvaluateLoad(t *testing.T) {
+	now := time.Now()
+	cfg := &config.LoadThreshold{CPUUsage: 80}
+	prev := &loadSample{time: now, cpuSeconds: 100, maxProcs: 4}
+	cur := &loadSample{time: now.Add(10 * time.Second), cpuSeconds: 130, maxProcs: 4}
+	status, _ := evaluateLoad(cfg, nil, cur)
+	require.Equal(t, StatusHealthy, status)
+	// 3 cores in 4 cores.
+	status, _ = evaluateLoad(cfg, prev, cur)
+	require.Equal(t, StatusHealthy, status)
+	cur.cpuSeconds = 140
+	status, reason := evaluateLoad(cfg, prev, cur)
+	require.Equal(t, StatusRunSlow, status)
+	require.Contains(t, reason, "cpu usage 100%")
+	// The backend restarts.
+	cur.cpuSeconds = 1
+	status, _ = evaluateLoad(cfg, prev, cur)
+	require.Equal(t, StatusHealthy, status)
+}
+
 // Test that the health check can exit when the context is cancelled.
 func TestCancelObserver(t *testing.T) {
 	ts := newObserverTestSuite(t)
@@ -134,6 +224,28 @@ func TestReadServerVersion(t *testing.T) {
 	backend1.close()
 }
 
+// Test that the health check config takes effect without restarting the observer.
+func TestSetHealthCheckConfig(t *testing.T) {
+	ts := newObserverTestSuite(t)
+	t.Cleanup(ts.close)
+	ts.bo.Start()
+
+	backend1 := ts.addBackend()
+	ts.checkStatus(backend1, StatusHealthy)
+	backend1.stopSQLServer()
+	ts.checkStatus(backend1, StatusCannotConnect)
+	// The backend is regarded as healthy when the health check is disabled.
+	cfg := newHealthCheckConfigForTest()
+	cfg.Enable = false
+	ts.bo.SetHealthCheckConfig(cfg)
+	ts.checkStatus(backend1, StatusHealthy)
+	cfg = newHealthCheckConfigForTest()
+	ts.bo.SetHealthCheckConfig(cfg)
+	ts.checkStatus(backend1, StatusCannotConnect)
+	// The SQL server is stopped already.
+	backend1.stopHTTPServer()
+}
+
 type observerTestSuite struct {
 	t           *testing.T
 	bo          *BackendObserver
@@ -221,9 +333,19 @@ func newBackendServer(t *testing.T) *backendServer {
 }
 
 type mockHttpHandler struct {
-	t      *testing.T
-	httpOK atomic.Bool
-	wait   atomic.Int64
+	t       *testing.T
+	httpOK  atomic.Bool
+	wait    atomic.Int64
+	metrics atomic.String
+	status  atomic.String
+}
+
+func (handler *mockHttpHandler) setMetrics(metrics string) {
+	handler.metrics.Store(metrics)
+}
+
+func (handler *mockHttpHandler) setStatus(status string) {
+	handler.status.Store(status)
 }
 
 func (handler *mockHttpHandler) setHTTPResp(succeed bool) {
@@ -241,6 +363,12 @@ func (handler *mockHttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	}
 	if handler.httpOK.Load() {
 		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case metricsPathSuffix:
+			_, _ = w.Write([]byte(handler.metrics.Load()))
+		case statusPathSuffix:
+			_, _ = w.Write([]byte(handler.status.Load()))
+		}
 	} else {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
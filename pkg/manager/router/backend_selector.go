@@ -3,11 +3,25 @@
 
 package router
 
+// RouteHint contains the information of a connection that restricts routing.
+type RouteHint struct {
+	// Group pins the connection to a backend group. Empty means any backend.
+	Group string
+	// Key is hashed by the consistent-hash router. Empty means the connection has no affinity.
+	Key string
+}
+
 type BackendSelector struct {
 	excluded  []string
 	cur       string
-	routeOnce func(excluded []string) (string, error)
-	onCreate  func(addr string, conn RedirectableConn, succeed bool)
+	hint      RouteHint
+	routeOnce func(excluded []string, hint RouteHint) (string, error)
+	onCreate  func(addr string, conn RedirectableConn, succeed bool, hint RouteHint)
+}
+
+// SetRouteHint restricts the backends that the selector chooses from.
+func (bs *BackendSelector) SetRouteHint(hint RouteHint) {
+	bs.hint = hint
 }
 
 func (bs *BackendSelector) Reset() {
@@ -15,7 +29,7 @@ func (bs *BackendSelector) Reset() {
 }
 
 func (bs *BackendSelector) Next() (string, error) {
-	addr, err := bs.routeOnce(bs.excluded)
+	addr, err := bs.routeOnce(bs.excluded, bs.hint)
 	if err != nil {
 		return addr, err
 	}
@@ -27,5 +41,5 @@ func (bs *BackendSelector) Next() (string, error) {
 }
 
 func (bs *BackendSelector) Finish(conn RedirectableConn, succeed bool) {
-	bs.onCreate(bs.cur, conn, succeed)
+	bs.onCreate(bs.cur, conn, succeed, bs.hint)
 }
@@ -49,6 +49,14 @@ func readMigrateCounter(from, to string, succeed bool) (int, error) {
 	return metrics.ReadCounter(metrics.MigrateCounter.WithLabelValues(from, to, succeedToLabel(succeed)))
 }
 
+func addMigratePingPongMetrics(from, to string) {
+	metrics.MigratePingPongCounter.WithLabelValues(from, to).Inc()
+}
+
+func readMigratePingPongCounter(from, to string) (int, error) {
+	return metrics.ReadCounter(metrics.MigratePingPongCounter.WithLabelValues(from, to))
+}
+
 func setPingBackendMetrics(addr string, succeed bool, startTime time.Time) {
 	cost := time.Since(startTime)
 	metrics.PingBackendGauge.WithLabelValues(addr).Set(cost.Seconds())
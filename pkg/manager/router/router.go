@@ -4,14 +4,23 @@
 package router
 
 import (
+	"math"
 	"time"
 
 	glist "github.com/bahlo/generic-list-go"
+	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/errors"
 )
 
+// ZoneLabel is the key of the topology label that indicates the zone of TiDB and TiProxy.
+const ZoneLabel = "zone"
+
 var (
 	ErrNoInstanceToSelect = errors.New("no instances to route")
+	ErrBackendNotFound    = errors.New("backend not found")
+	ErrDrainNotSupported  = errors.New("the router doesn't support draining backends")
+	// ErrRebalanceNotSupported is returned by the routers that never migrate connections.
+	ErrRebalanceNotSupported = errors.New("the router doesn't support rebalancing")
 )
 
 // ConnEventReceiver receives connection events.
@@ -28,7 +37,24 @@ type Router interface {
 
 	GetBackendSelector() BackendSelector
 	RefreshBackend()
+	// SetHealthCheckConfig applies the health check config to the running router.
+	SetHealthCheckConfig(cfg *config.HealthCheck)
 	RedirectConnections() error
+	// DrainBackend starts or stops draining the backend. New connections are not routed to a draining backend
+	// and the connections on it are migrated away.
+	DrainBackend(addr string, drain bool) error
+	// DrainStatuses returns the progress of the draining backends.
+	DrainStatuses() []DrainStatus
+	// RestoreDraining drains the backends once they are found, which keeps them draining after the router is rebuilt.
+	RestoreDraining(addrs []string)
+	// SetZone sets the zone of TiProxy so that the backends in the same zone are preferred.
+	SetZone(zone string)
+	// SetRebalanceConfig applies the rebalance config to the running router.
+	SetRebalanceConfig(cfg *config.Rebalance)
+	// PauseRebalance pauses or resumes migrating connections between backends.
+	PauseRebalance(pause bool) error
+	// PreviewRebalance returns the migrations that the next round of rebalancing would make without making them.
+	PreviewRebalance() ([]Migration, error)
 	ConnCount() int
 	// ServerVersion returns the TiDB version.
 	ServerVersion() string
@@ -48,8 +74,24 @@ const (
 	phaseRedirectFail
 )
 
+// The reasons to migrate connections.
+const (
+	MigrateReasonZone      = "zone"
+	MigrateReasonScore     = "score"
+	MigrateReasonUnhealthy = "unhealthy"
+)
+
+// Migration is a connection that the router is going to migrate.
+type Migration struct {
+	ConnectionID uint64 `json:"connection-id"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Reason       string `json:"reason"`
+}
+
 const (
 	// The interval to rebalance connections.
+	// rebalanceInterval, rebalanceConnsPerLoop and rebalanceMaxScoreRatio are the defaults of config.Rebalance.
 	rebalanceInterval = 10 * time.Millisecond
 	// The number of connections to rebalance during each interval.
 	// Limit the number to avoid creating too many connections suddenly on a backend.
@@ -60,6 +102,12 @@ const (
 	// After a connection fails to redirect, it may contain some unmigratable status.
 	// Limit its redirection interval to avoid unnecessary retrial to reduce latency jitter.
 	redirectFailMinInterval = 3 * time.Second
+	// migrationWindow is the window to limit the migrations of a connection and detect ping-pong migrations.
+	migrationWindow = time.Hour
+	// drainingScore is added to the score of a draining backend so that it's emptied before other backends.
+	drainingScore = 100000000
+	// warmUpMinFactor is the capacity factor of a backend when it starts warming up.
+	warmUpMinFactor = 0.1
 )
 
 // RedirectableConn indicates a redirect-able connection.
@@ -70,6 +118,9 @@ type RedirectableConn interface {
 	// Redirect returns false if the current conn is not redirectable.
 	Redirect(addr string) bool
 	NotifyBackendStatus(status BackendStatus)
+	// NotifyEvacuating notifies whether the backend of the connection is being drained or removed,
+	// so that the connection doesn't pin the backend forever by keeping a transaction open.
+	NotifyEvacuating(evacuating bool)
 	ConnectionID() uint64
 }
 
@@ -83,11 +134,84 @@ type backendWrapper struct {
 	// A list of *connWrapper and is ordered by the connecting or redirecting time.
 	// connList only includes the connections that are currently on this backend.
 	connList *glist.List[*connWrapper]
+	// group is the name of the backend group that the backend belongs to.
+	group string
+	// weight is the relative capacity of the backend, which is at least 1.
+	weight int
+	// draining is set by the admin before maintaining the backend.
+	draining bool
+	// drainFailures is the number of failed migrations from the backend since it starts draining.
+	drainFailures int
+	// warmUpSince is the time when the backend starts warming up. It's zero if the backend is warmed up.
+	warmUpSince time.Time
+	// warmUpFactor is the fraction of the capacity during warm-up, which rises from warmUpMinFactor to 1.
+	// It's updated periodically instead of being calculated in score() so that the order of the backends is stable.
+	warmUpFactor float64
 }
 
 // score calculates the score of the backend. Larger score indicates higher load.
+// The connections are counted per unit of weight so that a larger backend carries proportionally more connections.
+// A warming-up backend looks busier so that it receives fewer connections.
 func (b *backendWrapper) score() int {
-	return b.status.ToScore() + b.connScore
+	conns := b.connScore
+	capacity := 1.0
+	if b.weight > 1 {
+		capacity = float64(b.weight)
+	}
+	if b.warmingUp() {
+		capacity *= b.warmUpFactor
+	}
+	if capacity != 1 {
+		conns = int(math.Ceil(float64(b.connScore) / capacity))
+	}
+	score := b.status.ToScore() + conns
+	if b.draining {
+		score += drainingScore
+	}
+	return score
+}
+
+// routable returns true if new connections can be routed to the backend.
+func (b *backendWrapper) routable() bool {
+	if b.draining {
+		return false
+	}
+	switch b.status {
+	// These backends may be recycled, so we should not connect to them again.
+	case StatusCannotConnect, StatusSchemaOutdated:
+		return false
+	}
+	return true
+}
+
+// evacuating returns true if all the connections on the backend should leave.
+func (b *backendWrapper) evacuating() bool {
+	return b.draining || b.status == StatusCannotConnect
+}
+
+// warmingUp returns true if the backend is warming up.
+func (b *backendWrapper) warmingUp() bool {
+	return !b.warmUpSince.IsZero()
+}
+
+// inZone returns true if the zone label of the backend matches the zone.
+func (b *backendWrapper) inZone(zone string) bool {
+	return b.labels[ZoneLabel] == zone
+}
+
+// inGroup returns true if the backend belongs to the group. An empty group contains all backends.
+func (b *backendWrapper) inGroup(group string) bool {
+	return len(group) == 0 || b.group == group
+}
+
+// DrainStatus shows the progress of draining a backend.
+type DrainStatus struct {
+	Addr string `json:"addr"`
+	// Remaining is the number of connections that are still on the backend.
+	Remaining int `json:"remaining"`
+	// Failures is the number of failed migrations since draining starts.
+	Failures int  `json:"failures"`
+	Done     bool `json:"done"`
 }
 
 // connWrapper wraps RedirectableConn.
@@ -96,4 +220,39 @@ type connWrapper struct {
 	phase connPhase
 	// Last redirect start time of this connection.
 	lastRedirect time.Time
+	// group is the backend group that the connection is pinned to. Empty means any backend.
+	group string
+	// lastMigrated is the time when the connection was migrated to the current backend.
+	lastMigrated time.Time
+	// prevAddr is the backend that the connection left in the last successful migration.
+	prevAddr string
+	// balanceMigrations are the start times of the migrations for balance in the last migrationWindow.
+	balanceMigrations []time.Time
+}
+
+// recentMigrations removes the expired migrations and returns the number of migrations for balance in the window.
+func (conn *connWrapper) recentMigrations(curTime time.Time) int {
+	i := 0
+	for ; i < len(conn.balanceMigrations); i++ {
+		if conn.balanceMigrations[i].Add(migrationWindow).After(curTime) {
+			break
+		}
+	}
+	conn.balanceMigrations = conn.balanceMigrations[i:]
+	return len(conn.balanceMigrations)
+}
+
+// canMigrate returns true if the connection can be redirected now.
+func (conn *connWrapper) canMigrate(curTime time.Time) bool {
+	switch conn.phase {
+	case phaseRedirectNotify:
+		// A connection cannot be redirected again when it has not finished redirecting.
+		return false
+	case phaseRedirectFail:
+		// If it failed recently, it will probably fail this time.
+		if conn.lastRedirect.Add(redirectFailMinInterval).After(curTime) {
+			return false
+		}
+	}
+	return true
 }
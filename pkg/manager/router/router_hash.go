@@ -0,0 +1,145 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+	"go.uber.org/zap"
+)
+
+// hashVirtualNodes is the number of points of each unit of weight on the hash ring.
+// More points spread the keys more evenly.
+const hashVirtualNodes = 100
+
+// ConsistentHashRouter routes the connections with the same key to the same backend, so that the sessions of
+// an application land on the same TiDB across reconnects and benefit from its plan cache and coprocessor cache.
+// The load of each backend is bounded, so a hot key doesn't overload a backend.
+// Only the connections on unhealthy or draining backends are migrated, otherwise the affinity is broken.
+type ConsistentHashRouter struct {
+	*ScoreBasedRouter
+}
+
+// NewConsistentHashRouter creates a ConsistentHashRouter. Each backend carries at most loadFactor times
+// the average connections per unit of weight.
+func NewConsistentHashRouter(logger *zap.Logger, loadFactor float64) *ConsistentHashRouter {
+	router := NewScoreBasedRouter(logger)
+	router.policy = &consistentHashPolicy{
+		loadFactor: loadFactor,
+	}
+	return &ConsistentHashRouter{ScoreBasedRouter: router}
+}
+
+type hashRingNode struct {
+	hash uint64
+	addr string
+}
+
+// consistentHashPolicy implements consistent hashing with bounded loads.
+// The points of a backend on the ring only depend on its address and weight, so when a backend joins or leaves,
+// only the keys that are mapped to it are remapped. The excluded and unhealthy backends are skipped when walking
+// the ring, so the ring may contain more backends than the candidates, which doesn't change the result.
+type consistentHashPolicy struct {
+	loadFactor float64
+	// ring is sorted by the hashes.
+	ring []hashRingNode
+	// members are the weights of the backends on the ring.
+	members map[string]int
+}
+
+func hashWeight(backend *backendWrapper) int {
+	if backend.weight > 1 {
+		return backend.weight
+	}
+	return 1
+}
+
+func (p *consistentHashPolicy) pick(candidates []*backendWrapper, key string) int {
+	// The connections without keys, such as the migrating connections, go to the idlest backend.
+	if len(key) == 0 {
+		return idlestByWeight(candidates)
+	}
+	p.buildRing(candidates)
+	indexes := make(map[string]int, len(candidates))
+	totalConns, totalWeight := 0, 0
+	for i, backend := range candidates {
+		indexes[backend.addr] = i
+		totalConns += backend.connScore
+		totalWeight += hashWeight(backend)
+	}
+	hash := xxhash.Sum64String(key)
+	start := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= hash
+	})
+	visited := make(map[string]struct{}, len(candidates))
+	first := -1
+	for i := 0; i < len(p.ring) && len(visited) < len(candidates); i++ {
+		node := p.ring[(start+i)%len(p.ring)]
+		idx, ok := indexes[node.addr]
+		if !ok {
+			continue
+		}
+		if _, ok := visited[node.addr]; ok {
+			continue
+		}
+		visited[node.addr] = struct{}{}
+		if first < 0 {
+			first = idx
+		}
+		// The bound includes the new connection. The sum of the bounds exceeds the total connections,
+		// so there is always a backend under the bound.
+		backend := candidates[idx]
+		bound := math.Ceil(p.loadFactor * float64(totalConns+1) * float64(hashWeight(backend)) / float64(totalWeight))
+		if float64(backend.connScore+1) <= bound {
+			return idx
+		}
+	}
+	return first
+}
+
+// buildRing rebuilds the ring if any candidate is not on it or its weight changes.
+func (p *consistentHashPolicy) buildRing(candidates []*backendWrapper) {
+	rebuild := false
+	for _, backend := range candidates {
+		if weight, ok := p.members[backend.addr]; !ok || weight != hashWeight(backend) {
+			rebuild = true
+			break
+		}
+	}
+	if !rebuild {
+		return
+	}
+	p.members = make(map[string]int, len(candidates))
+	p.ring = p.ring[:0]
+	for _, backend := range candidates {
+		weight := hashWeight(backend)
+		p.members[backend.addr] = weight
+		for i := 0; i < hashVirtualNodes*weight; i++ {
+			p.ring = append(p.ring, hashRingNode{
+				hash: xxhash.Sum64String(backend.addr + "#" + strconv.Itoa(i)),
+				addr: backend.addr,
+			})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		if p.ring[i].hash != p.ring[j].hash {
+			return p.ring[i].hash < p.ring[j].hash
+		}
+		return p.ring[i].addr < p.ring[j].addr
+	})
+}
+
+// idlestByWeight returns the backend with the fewest connections per unit of weight.
+func idlestByWeight(candidates []*backendWrapper) int {
+	idx := 0
+	for i, backend := range candidates {
+		if backend.connScore*hashWeight(candidates[idx]) < candidates[idx].connScore*hashWeight(backend) {
+			idx = i
+		}
+	}
+	return idx
+}
@@ -0,0 +1,289 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	glist "github.com/bahlo/generic-list-go"
+	"github.com/pingcap/TiProxy/lib/config"
+	"go.uber.org/zap"
+)
+
+// The selector types of namespaces. An empty selector type means SelectorTypeScore.
+const (
+	SelectorTypeScore      = "score"
+	SelectorTypeRoundRobin = "round-robin"
+	SelectorTypeLeastConn  = "least-conn"
+	SelectorTypeRandom     = "random"
+	SelectorTypeWeighted   = "weighted"
+	// SelectorTypeConsistentHash routes the connections with the same key to the same backend.
+	SelectorTypeConsistentHash = "consistent-hash"
+)
+
+// RouterBuilder creates and initializes a router for a namespace.
+type RouterBuilder func(logger *zap.Logger, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error)
+
+var routerBuilders = map[string]RouterBuilder{
+	"":                buildScoreBasedRouter,
+	SelectorTypeScore: buildScoreBasedRouter,
+	SelectorTypeRoundRobin: func(logger *zap.Logger, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error) {
+		rt := NewRoundRobinRouter(logger)
+		return initRouter(rt, rt.ScoreBasedRouter, httpCli, fetcher, cfg)
+	},
+	SelectorTypeLeastConn: func(logger *zap.Logger, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error) {
+		rt := NewLeastConnRouter(logger)
+		return initRouter(rt, rt.ScoreBasedRouter, httpCli, fetcher, cfg)
+	},
+	SelectorTypeRandom: func(logger *zap.Logger, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error) {
+		rt := NewRandomRouter(logger)
+		return initRouter(rt, rt.ScoreBasedRouter, httpCli, fetcher, cfg)
+	},
+	SelectorTypeWeighted: func(logger *zap.Logger, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error) {
+		rt := NewWeightedRouter(logger, cfg.Weights)
+		return initRouter(rt, rt.ScoreBasedRouter, httpCli, fetcher, cfg)
+	},
+	SelectorTypeConsistentHash: func(logger *zap.Logger, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error) {
+		rt := NewConsistentHashRouter(logger, cfg.GetConsistentHash().LoadFactor)
+		return initRouter(rt, rt.ScoreBasedRouter, httpCli, fetcher, cfg)
+	},
+}
+
+// GetRouterBuilder returns the builder of the selector type. It returns false if the selector type is unknown.
+func GetRouterBuilder(selectorType string) (RouterBuilder, bool) {
+	builder, ok := routerBuilders[selectorType]
+	return builder, ok
+}
+
+func buildScoreBasedRouter(logger *zap.Logger, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error) {
+	rt := NewScoreBasedRouter(logger)
+	return initRouter(rt, rt, httpCli, fetcher, cfg)
+}
+
+func initRouter(rt Router, base *ScoreBasedRouter, httpCli *http.Client, fetcher BackendFetcher, cfg *config.BackendNamespace) (Router, error) {
+	base.setBackendConfig(cfg)
+	if err := base.Init(httpCli, fetcher, cfg.GetHealthCheck()); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// routePolicy picks a backend from the candidates, which are sorted by addresses.
+// The key is the hash key of the connection, which is empty when migrating connections.
+// It's always called within the lock of the router, so it needs no lock itself.
+type routePolicy interface {
+	pick(candidates []*backendWrapper, key string) int
+}
+
+// routableBackends returns the backends in the group that can be connected, except the excluded ones.
+// The healthy ones are returned separately so that the policies prefer them.
+func (router *ScoreBasedRouter) routableBackends(excluded []string, group string) (healthy, degraded []*glist.Element[*backendWrapper]) {
+	for be := router.backends.Front(); be != nil; be = be.Next() {
+		backend := be.Value
+		if !backend.routable() || !backend.inGroup(group) {
+			continue
+		}
+		found := false
+		for _, ex := range excluded {
+			if ex == backend.addr {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if backend.status == StatusHealthy {
+			healthy = append(healthy, be)
+		} else {
+			degraded = append(degraded, be)
+		}
+	}
+	return
+}
+
+func (router *ScoreBasedRouter) pickFrom(candidates []*glist.Element[*backendWrapper], key string) *glist.Element[*backendWrapper] {
+	if len(candidates) == 0 {
+		return nil
+	}
+	// The order of the list changes with scores, so sort them to make the policies stable.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Value.addr < candidates[j].Value.addr
+	})
+	backends := make([]*backendWrapper, 0, len(candidates))
+	for _, be := range candidates {
+		backends = append(backends, be.Value)
+	}
+	return candidates[router.policy.pick(backends, key)]
+}
+
+// pickByPolicy picks a backend in the group of the hint for a new connection.
+func (router *ScoreBasedRouter) pickByPolicy(excluded []string, hint RouteHint) *glist.Element[*backendWrapper] {
+	healthy, degraded := router.routableBackends(excluded, hint.Group)
+	if local := router.localBackends(healthy); len(local) > 0 {
+		return router.pickFrom(local, hint.Key)
+	}
+	if len(healthy) > 0 {
+		return router.pickFrom(healthy, hint.Key)
+	}
+	return router.pickFrom(degraded, hint.Key)
+}
+
+// pickUnhealthyMigration returns a connection on an unhealthy or draining backend and the backend to migrate it to.
+// Unlike the score-based router, the routers with policies don't balance the connections between healthy backends,
+// otherwise the policies are broken.
+func (router *ScoreBasedRouter) pickUnhealthyMigration(curTime time.Time) (*glist.Element[*backendWrapper],
+	*glist.Element[*backendWrapper], *glist.Element[*connWrapper]) {
+	// The draining backends are in the front because of their scores, so they are emptied first.
+	for be := router.backends.Front(); be != nil; be = be.Next() {
+		backend := be.Value
+		if (backend.status == StatusHealthy && !backend.draining) || backend.connList.Len() == 0 {
+			continue
+		}
+		to, ce := router.pickConn(be, curTime, false, func(group string) *glist.Element[*backendWrapper] {
+			healthy, degraded := router.routableBackends([]string{backend.addr}, group)
+			if local := router.localBackends(healthy); len(local) > 0 {
+				return router.pickFrom(local, "")
+			}
+			if len(healthy) > 0 {
+				return router.pickFrom(healthy, "")
+			}
+			// Migrating from a degraded backend to another degraded backend makes no difference.
+			if !backend.routable() && len(degraded) > 0 {
+				return router.pickFrom(degraded, "")
+			}
+			return nil
+		})
+		if ce != nil {
+			return be, to, ce
+		}
+	}
+	return nil, nil, nil
+}
+
+var _ Router = &RoundRobinRouter{}
+var _ Router = &LeastConnRouter{}
+var _ Router = &RandomRouter{}
+var _ Router = &WeightedRouter{}
+var _ Router = &ConsistentHashRouter{}
+
+// RoundRobinRouter routes new connections to the backends in turn.
+type RoundRobinRouter struct {
+	*ScoreBasedRouter
+}
+
+// NewRoundRobinRouter creates a RoundRobinRouter.
+func NewRoundRobinRouter(logger *zap.Logger) *RoundRobinRouter {
+	router := NewScoreBasedRouter(logger)
+	router.policy = &roundRobinPolicy{}
+	return &RoundRobinRouter{ScoreBasedRouter: router}
+}
+
+type roundRobinPolicy struct {
+	next int
+}
+
+func (p *roundRobinPolicy) pick(candidates []*backendWrapper, _ string) int {
+	idx := p.next % len(candidates)
+	p.next = idx + 1
+	return idx
+}
+
+// LeastConnRouter routes new connections to the backend with the fewest connections.
+// Different from ScoreBasedRouter, it ignores the load of backends.
+type LeastConnRouter struct {
+	*ScoreBasedRouter
+}
+
+// NewLeastConnRouter creates a LeastConnRouter.
+func NewLeastConnRouter(logger *zap.Logger) *LeastConnRouter {
+	router := NewScoreBasedRouter(logger)
+	router.policy = &leastConnPolicy{}
+	return &LeastConnRouter{ScoreBasedRouter: router}
+}
+
+type leastConnPolicy struct{}
+
+func (p *leastConnPolicy) pick(candidates []*backendWrapper, _ string) int {
+	idx := 0
+	for i, backend := range candidates {
+		// connScore includes the connections that are being created or redirected.
+		if backend.connScore < candidates[idx].connScore {
+			idx = i
+		}
+	}
+	return idx
+}
+
+// RandomRouter routes new connections to random backends.
+type RandomRouter struct {
+	*ScoreBasedRouter
+}
+
+// NewRandomRouter creates a RandomRouter.
+func NewRandomRouter(logger *zap.Logger) *RandomRouter {
+	router := NewScoreBasedRouter(logger)
+	router.policy = &randomPolicy{
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	return &RandomRouter{ScoreBasedRouter: router}
+}
+
+type randomPolicy struct {
+	rnd *rand.Rand
+}
+
+func (p *randomPolicy) pick(candidates []*backendWrapper, _ string) int {
+	return p.rnd.Intn(len(candidates))
+}
+
+// WeightedRouter routes new connections to the backends in proportion to their weights.
+type WeightedRouter struct {
+	*ScoreBasedRouter
+}
+
+// NewWeightedRouter creates a WeightedRouter. The weights are keyed by backend addresses and override the
+// weights of the backends, which come from the weight label or default to 1.
+func NewWeightedRouter(logger *zap.Logger, weights map[string]int) *WeightedRouter {
+	router := NewScoreBasedRouter(logger)
+	router.policy = &weightedPolicy{
+		weights: weights,
+		current: make(map[string]int),
+	}
+	return &WeightedRouter{ScoreBasedRouter: router}
+}
+
+// weightedPolicy implements the smooth weighted round-robin, which spreads the connections evenly
+// instead of routing a burst of connections to the heaviest backend.
+type weightedPolicy struct {
+	weights map[string]int
+	current map[string]int
+}
+
+func (p *weightedPolicy) weight(backend *backendWrapper) int {
+	if weight, ok := p.weights[backend.addr]; ok {
+		return weight
+	}
+	if backend.weight > 1 {
+		return backend.weight
+	}
+	return 1
+}
+
+func (p *weightedPolicy) pick(candidates []*backendWrapper, _ string) int {
+	idx, total := 0, 0
+	for i, backend := range candidates {
+		weight := p.weight(backend)
+		total += weight
+		p.current[backend.addr] += weight
+		if p.current[backend.addr] > p.current[candidates[idx].addr] {
+			idx = i
+		}
+	}
+	p.current[candidates[idx].addr] -= total
+	return idx
+}
@@ -0,0 +1,259 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func newPolicyRouterTester(t *testing.T, policy routePolicy) *routerTester {
+	tester := newRouterTester(t)
+	tester.router.policy = policy
+	return tester
+}
+
+// countConns returns the connection count of each backend.
+func (tester *routerTester) countConns() map[string]int {
+	counts := make(map[string]int)
+	for be := tester.router.backends.Front(); be != nil; be = be.Next() {
+		counts[be.Value.addr] = be.Value.connList.Len()
+	}
+	return counts
+}
+
+func TestRoundRobinPolicy(t *testing.T) {
+	tester := newPolicyRouterTester(t, &roundRobinPolicy{})
+	tester.addBackends(3)
+	for i := 0; i < 6; i++ {
+		conn := tester.createConn()
+		require.Equal(t, strconv.Itoa(i%3+1), tester.simpleRoute(conn))
+	}
+	// The unhealthy backend is skipped.
+	tester.updateBackendStatusByAddr("2", StatusMemoryHigh)
+	for i := 0; i < 4; i++ {
+		require.NotEqual(t, "2", tester.simpleRoute(tester.createConn()))
+	}
+}
+
+func TestLeastConnPolicy(t *testing.T) {
+	tester := newPolicyRouterTester(t, &leastConnPolicy{})
+	tester.addBackends(3)
+	tester.addConnections(30)
+	require.Equal(t, map[string]int{"1": 10, "2": 10, "3": 10}, tester.countConns())
+
+	// Close the connections on backend 2 and the new connections go to backend 2.
+	for id, conn := range tester.conns {
+		if conn.from == "2" {
+			require.NoError(t, tester.router.OnConnClosed(conn.from, conn))
+			delete(tester.conns, id)
+		}
+	}
+	tester.addConnections(10)
+	require.Equal(t, map[string]int{"1": 10, "2": 10, "3": 10}, tester.countConns())
+}
+
+func TestRandomPolicy(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.router.policy = NewRandomRouter(tester.router.logger).policy
+	tester.addBackends(2)
+	tester.updateBackendStatusByAddr("1", StatusCannotConnect)
+	tester.addConnections(10)
+	require.Equal(t, 10, tester.countConns()["2"])
+
+	// The excluded backends are not routed.
+	selector := tester.router.GetBackendSelector()
+	addr, err := selector.Next()
+	require.NoError(t, err)
+	require.Equal(t, "2", addr)
+	selector.Finish(nil, false)
+	addr, err = selector.Next()
+	require.NoError(t, err)
+	require.Empty(t, addr)
+}
+
+func TestWeightedPolicy(t *testing.T) {
+	tester := newPolicyRouterTester(t, NewWeightedRouter(nil, map[string]int{"1": 3, "3": 2}).policy)
+	tester.addBackends(3)
+	// Smooth weighted round-robin doesn't route continuously to the heaviest backend.
+	var addrs []string
+	for i := 0; i < 6; i++ {
+		addrs = append(addrs, tester.simpleRoute(tester.createConn()))
+	}
+	require.Equal(t, []string{"1", "3", "1", "2", "3", "1"}, addrs)
+	tester.addConnections(60)
+	require.Equal(t, map[string]int{"1": 33, "2": 11, "3": 22}, tester.countConns())
+}
+
+// Test that the weights come from the labels if they're not in the config.
+func TestWeightedPolicyLabel(t *testing.T) {
+	tester := newPolicyRouterTester(t, NewWeightedRouter(nil, map[string]int{"3": 2}).policy)
+	tester.router.setBackendConfig(&config.BackendNamespace{WeightLabel: "weight"})
+	tester.router.OnBackendChanged(map[string]*backendHealth{
+		"1": {status: StatusHealthy, labels: map[string]string{"weight": "3"}},
+		"2": {status: StatusHealthy},
+		"3": {status: StatusHealthy, labels: map[string]string{"weight": "4"}},
+	}, nil)
+	tester.addConnections(60)
+	require.Equal(t, map[string]int{"1": 30, "2": 10, "3": 20}, tester.countConns())
+}
+
+// Test that the routers with policies only migrate connections from unhealthy backends.
+func TestPolicyRebalance(t *testing.T) {
+	tester := newPolicyRouterTester(t, &roundRobinPolicy{})
+	tester.addBackends(1)
+	tester.addConnections(10)
+	tester.addBackends(2)
+	tester.rebalance(10)
+	tester.checkRedirectingNum(0)
+
+	tester.updateBackendStatusByAddr("1", StatusMemoryHigh)
+	tester.rebalance(10)
+	tester.checkRedirectingNum(10)
+	for _, conn := range tester.conns {
+		require.Contains(t, []string{"2", "3"}, conn.GetRedirectingAddr())
+	}
+	tester.redirectFinish(10, true)
+	require.Equal(t, map[string]int{"1": 0, "2": 5, "3": 5}, tester.countConns())
+}
+
+func TestRouterBuilders(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	_, ok := GetRouterBuilder("unknown")
+	require.False(t, ok)
+
+	cfg := &config.BackendNamespace{
+		HealthCheck: &config.HealthCheck{Enable: false},
+	}
+	for _, selectorType := range []string{"", SelectorTypeScore, SelectorTypeRoundRobin, SelectorTypeLeastConn,
+		SelectorTypeRandom, SelectorTypeWeighted, SelectorTypeConsistentHash} {
+		build, ok := GetRouterBuilder(selectorType)
+		require.True(t, ok, selectorType)
+		rt, err := build(lg, nil, &mockBackendFetcher{}, cfg)
+		require.NoError(t, err, selectorType)
+		require.Equal(t, 0, rt.ConnCount(), selectorType)
+		rt.Close()
+	}
+}
+
+func TestPolicyDrainBackend(t *testing.T) {
+	tester := newPolicyRouterTester(t, &roundRobinPolicy{})
+	tester.addBackends(2)
+	tester.addConnections(10)
+	require.NoError(t, tester.router.DrainBackend("1", true))
+	for i := 0; i < 4; i++ {
+		require.Equal(t, "2", tester.simpleRoute(tester.createConn()))
+	}
+	tester.rebalance(10)
+	tester.checkRedirectingNum(5)
+	tester.redirectFinish(5, true)
+	require.Equal(t, []DrainStatus{{Addr: "1", Done: true}}, tester.router.DrainStatuses())
+}
+
+func TestPolicyZoneAware(t *testing.T) {
+	tester := newPolicyRouterTester(t, &roundRobinPolicy{})
+	tester.router.SetZone("z1")
+	tester.router.OnBackendChanged(map[string]*backendHealth{
+		"1": {status: StatusHealthy, labels: map[string]string{ZoneLabel: "z1"}},
+		"2": {status: StatusHealthy, labels: map[string]string{ZoneLabel: "z1"}},
+		"3": {status: StatusHealthy, labels: map[string]string{ZoneLabel: "z2"}},
+	}, nil)
+	tester.addConnections(10)
+	require.Equal(t, map[string]int{"1": 5, "2": 5, "3": 0}, tester.countConns())
+}
+
+func (tester *routerTester) routeByKey(key string) string {
+	selector := tester.router.GetBackendSelector()
+	selector.SetRouteHint(RouteHint{Key: key})
+	addr, err := selector.Next()
+	require.NoError(tester.t, err)
+	require.NotEmpty(tester.t, addr)
+	selector.Finish(tester.createConn(), true)
+	return addr
+}
+
+func TestConsistentHashPolicy(t *testing.T) {
+	// A large load factor doesn't bound the load.
+	tester := newPolicyRouterTester(t, NewConsistentHashRouter(nil, 100).policy)
+	tester.addBackends(3)
+	mapping := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		mapping[key] = tester.routeByKey(key)
+	}
+	counts := tester.countConns()
+	for _, addr := range []string{"1", "2", "3"} {
+		require.Greater(t, counts[addr], 0, addr)
+	}
+	for key, addr := range mapping {
+		require.Equal(t, addr, tester.routeByKey(key), key)
+	}
+
+	// Only the keys that are mapped to the new backend are remapped.
+	tester.addBackends(1)
+	moved := 0
+	for key, addr := range mapping {
+		newAddr := tester.routeByKey(key)
+		if newAddr != addr {
+			require.Equal(t, "4", newAddr, key)
+			mapping[key] = newAddr
+			moved++
+		}
+	}
+	require.Greater(t, moved, 0)
+
+	// Only the keys on the unavailable backend are remapped.
+	tester.updateBackendStatusByAddr("2", StatusCannotConnect)
+	for key, addr := range mapping {
+		newAddr := tester.routeByKey(key)
+		if addr == "2" {
+			require.NotEqual(t, "2", newAddr, key)
+		} else {
+			require.Equal(t, addr, newAddr, key)
+		}
+	}
+
+	// The selector tries the next backend on the ring if the backend fails to connect.
+	selector := tester.router.GetBackendSelector()
+	selector.SetRouteHint(RouteHint{Key: "0"})
+	addrs := make(map[string]struct{})
+	for i := 0; i < 3; i++ {
+		addr, err := selector.Next()
+		require.NoError(t, err)
+		require.NotEmpty(t, addr)
+		addrs[addr] = struct{}{}
+		selector.Finish(nil, false)
+	}
+	require.Len(t, addrs, 3)
+	addr, err := selector.Next()
+	require.NoError(t, err)
+	require.Empty(t, addr)
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	tester := newPolicyRouterTester(t, NewConsistentHashRouter(nil, 1.25).policy)
+	tester.addBackends(3)
+	home := tester.routeByKey("hot")
+	for i := 1; i < 30; i++ {
+		tester.routeByKey("hot")
+	}
+	counts := tester.countConns()
+	for addr, count := range counts {
+		// ceil(1.25 * 30 / 3) = 13
+		require.LessOrEqual(t, count, 13, addr)
+		require.LessOrEqual(t, count, counts[home], addr)
+	}
+	// The connections without keys go to the idlest backend.
+	idlest := "1"
+	for addr, count := range counts {
+		if count < counts[idlest] || (count == counts[idlest] && addr < idlest) {
+			idlest = addr
+		}
+	}
+	require.Equal(t, idlest, tester.simpleRoute(tester.createConn()))
+}
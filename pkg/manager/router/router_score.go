@@ -6,11 +6,14 @@ package router
 import (
 	"context"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	glist "github.com/bahlo/generic-list-go"
 	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
 	"github.com/pingcap/TiProxy/lib/util/waitgroup"
 	"go.uber.org/zap"
 )
@@ -34,14 +37,47 @@ type ScoreBasedRouter struct {
 	observeError error
 	// Only store the version of a random backend, so the client may see a wrong version when backends are upgrading.
 	serverVersion string
+	// policy picks backends for new connections. If it's nil, the backend with the lowest score is picked.
+	policy routePolicy
+	// groups and weights are set before Init and never change.
+	groups      []config.BackendGroup
+	weights     map[string]int
+	weightLabel string
+	// zone is the zone of TiProxy. The backends in the same zone are preferred if it's set.
+	zone string
+	// restoredDrains are the backends to drain once they are found, which are drained before the router is rebuilt.
+	restoredDrains map[string]struct{}
+	// rebalanceCfg is updated online and the zero values are replaced by the defaults.
+	rebalanceCfg    config.Rebalance
+	rebalancePaused bool
+	// slowStart is the warm-up duration of the backends. It's set before Init and never changes.
+	slowStart time.Duration
+	// backendsInited is set after the first backend list is received. The backends that exist when
+	// the router starts don't need warm-up.
+	backendsInited bool
 }
 
 // NewScoreBasedRouter creates a ScoreBasedRouter.
 func NewScoreBasedRouter(logger *zap.Logger) *ScoreBasedRouter {
-	return &ScoreBasedRouter{
+	router := &ScoreBasedRouter{
 		logger:   logger,
 		backends: glist.New[*backendWrapper](),
 	}
+	router.SetRebalanceConfig(&config.Rebalance{})
+	return router
+}
+
+// setBackendConfig sets the groups and weights of the backends. It's called before Init.
+func (r *ScoreBasedRouter) setBackendConfig(cfg *config.BackendNamespace) {
+	r.Lock()
+	defer r.Unlock()
+	r.groups = cfg.Groups
+	r.weights = cfg.Weights
+	r.weightLabel = cfg.WeightLabel
+	// The routers with policies don't route by scores, so warm-up doesn't work for them.
+	if r.policy == nil {
+		r.slowStart = cfg.SlowStart
+	}
 }
 
 func (r *ScoreBasedRouter) Init(httpCli *http.Client, fetcher BackendFetcher, cfg *config.HealthCheck) error {
@@ -75,31 +111,32 @@ func (router *ScoreBasedRouter) setConnWrapper(conn RedirectableConn, ce *glist.
 	conn.SetValue(_routerKey, ce)
 }
 
-func (router *ScoreBasedRouter) routeOnce(excluded []string) (string, error) {
+func (router *ScoreBasedRouter) routeOnce(excluded []string, hint RouteHint) (string, error) {
 	router.Lock()
 	defer router.Unlock()
 	if router.observeError != nil {
 		return "", router.observeError
 	}
-	for be := router.backends.Back(); be != nil; be = be.Prev() {
-		backend := be.Value
-		// These backends may be recycled, so we should not connect to them again.
-		switch backend.status {
-		case StatusCannotConnect, StatusSchemaOutdated:
-			continue
-		}
-		found := false
-		for _, ex := range excluded {
-			if ex == backend.addr {
-				found = true
-				break
-			}
-		}
-		if !found {
-			backend.connScore++
+	if router.policy != nil {
+		if be := router.pickByPolicy(excluded, hint); be != nil {
+			be.Value.connScore++
 			router.adjustBackendList(be)
-			return backend.addr, nil
+			return be.Value.addr, nil
+		}
+		if router.observer != nil {
+			router.observer.Refresh()
 		}
+		return "", nil
+	}
+	// Prefer the backends in the local zone and fall back to other zones if none of them is healthy.
+	be := router.idlestBackend(hint.Group, excluded, true)
+	if be == nil {
+		be = router.idlestBackend(hint.Group, excluded, false)
+	}
+	if be != nil {
+		be.Value.connScore++
+		router.adjustBackendList(be)
+		return be.Value.addr, nil
 	}
 	// No available backends, maybe the health check result is outdated during rolling restart.
 	// Refresh the backends asynchronously in this case.
@@ -109,7 +146,7 @@ func (router *ScoreBasedRouter) routeOnce(excluded []string) (string, error) {
 	return "", nil
 }
 
-func (router *ScoreBasedRouter) onCreateConn(addr string, conn RedirectableConn, succeed bool) {
+func (router *ScoreBasedRouter) onCreateConn(addr string, conn RedirectableConn, succeed bool, hint RouteHint) {
 	router.Lock()
 	defer router.Unlock()
 	be := router.ensureBackend(addr, true)
@@ -118,6 +155,7 @@ func (router *ScoreBasedRouter) onCreateConn(addr string, conn RedirectableConn,
 		connWrapper := &connWrapper{
 			RedirectableConn: conn,
 			phase:            phaseNotRedirected,
+			group:            hint.Group,
 		}
 		router.addConn(be, connWrapper)
 		conn.SetEventReceiver(router)
@@ -140,6 +178,7 @@ func (router *ScoreBasedRouter) addConn(be *glist.Element[*backendWrapper], conn
 	setBackendConnMetrics(backend.addr, backend.connList.Len())
 	router.setConnWrapper(conn, ce)
 	conn.NotifyBackendStatus(backend.status)
+	conn.NotifyEvacuating(backend.evacuating())
 	router.adjustBackendList(be)
 }
 
@@ -180,6 +219,72 @@ func (router *ScoreBasedRouter) RefreshBackend() {
 	router.observer.Refresh()
 }
 
+// SetHealthCheckConfig implements Router.SetHealthCheckConfig interface.
+func (router *ScoreBasedRouter) SetHealthCheckConfig(cfg *config.HealthCheck) {
+	router.Lock()
+	observer := router.observer
+	router.Unlock()
+	if observer != nil {
+		observer.SetHealthCheckConfig(cfg)
+	}
+}
+
+// SetZone implements Router.SetZone interface.
+func (router *ScoreBasedRouter) SetZone(zone string) {
+	router.Lock()
+	router.zone = zone
+	router.Unlock()
+}
+
+// SetRebalanceConfig implements Router.SetRebalanceConfig interface.
+func (router *ScoreBasedRouter) SetRebalanceConfig(cfg *config.Rebalance) {
+	rc := *cfg
+	if rc.Interval <= 0 {
+		rc.Interval = rebalanceInterval
+	}
+	if rc.ConnsPerLoop <= 0 {
+		rc.ConnsPerLoop = rebalanceConnsPerLoop
+	}
+	if rc.MaxScoreRatio <= 0 {
+		rc.MaxScoreRatio = rebalanceMaxScoreRatio
+	}
+	router.Lock()
+	router.rebalanceCfg = rc
+	router.Unlock()
+}
+
+// PauseRebalance implements Router.PauseRebalance interface.
+func (router *ScoreBasedRouter) PauseRebalance(pause bool) error {
+	router.Lock()
+	router.rebalancePaused = pause
+	router.Unlock()
+	return nil
+}
+
+// PreviewRebalance implements Router.PreviewRebalance interface.
+func (router *ScoreBasedRouter) PreviewRebalance() ([]Migration, error) {
+	router.Lock()
+	defer router.Unlock()
+	if router.rebalancePaused {
+		return nil, nil
+	}
+	plans := router.planMigrations(time.Now(), router.rebalanceCfg.ConnsPerLoop)
+	migrations := make([]Migration, 0, len(plans))
+	for _, plan := range plans {
+		migrations = append(migrations, plan.toMigration())
+	}
+	// Revert the plans in the reverse order so that the router stays unchanged.
+	for i := len(plans) - 1; i >= 0; i-- {
+		plan := plans[i]
+		plan.from.Value.connScore++
+		router.adjustBackendList(plan.from)
+		plan.to.Value.connScore--
+		router.adjustBackendList(plan.to)
+		plan.conn.Value.phase = plan.prevPhase
+	}
+	return migrations, nil
+}
+
 // RedirectConnections implements Router.RedirectConnections interface.
 // It redirects all connections compulsively. It's only used for testing.
 func (router *ScoreBasedRouter) RedirectConnections() error {
@@ -200,6 +305,76 @@ func (router *ScoreBasedRouter) RedirectConnections() error {
 	return nil
 }
 
+// DrainBackend implements Router.DrainBackend interface.
+func (router *ScoreBasedRouter) DrainBackend(addr string, drain bool) error {
+	router.Lock()
+	defer router.Unlock()
+	if !drain {
+		delete(router.restoredDrains, addr)
+	}
+	be := router.lookupBackend(addr, true)
+	if be == nil {
+		return errors.Wrapf(ErrBackendNotFound, "backend %s", addr)
+	}
+	router.setDraining(be, drain)
+	return nil
+}
+
+func (router *ScoreBasedRouter) setDraining(be *glist.Element[*backendWrapper], drain bool) {
+	backend := be.Value
+	if backend.draining == drain {
+		return
+	}
+	backend.draining = drain
+	backend.drainFailures = 0
+	router.adjustBackendList(be)
+	for ele := backend.connList.Front(); ele != nil; ele = ele.Next() {
+		ele.Value.NotifyEvacuating(backend.evacuating())
+	}
+	router.logger.Info("update backend draining", zap.String("backend_addr", backend.addr), zap.Bool("draining", drain),
+		zap.Int("conns", backend.connList.Len()))
+}
+
+// RestoreDraining implements Router.RestoreDraining interface.
+func (router *ScoreBasedRouter) RestoreDraining(addrs []string) {
+	router.Lock()
+	defer router.Unlock()
+	for _, addr := range addrs {
+		if be := router.lookupBackend(addr, true); be != nil {
+			router.setDraining(be, true)
+			continue
+		}
+		if router.restoredDrains == nil {
+			router.restoredDrains = make(map[string]struct{})
+		}
+		router.restoredDrains[addr] = struct{}{}
+	}
+}
+
+// DrainStatuses implements Router.DrainStatuses interface.
+func (router *ScoreBasedRouter) DrainStatuses() []DrainStatus {
+	router.Lock()
+	defer router.Unlock()
+	var statuses []DrainStatus
+	for be := router.backends.Front(); be != nil; be = be.Next() {
+		backend := be.Value
+		if !backend.draining {
+			continue
+		}
+		statuses = append(statuses, DrainStatus{
+			Addr:      backend.addr,
+			Remaining: backend.connList.Len(),
+			Failures:  backend.drainFailures,
+			// connScore > 0 means some connections are still connecting to the backend.
+			Done: backend.connList.Len() == 0 && backend.connScore <= 0,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Addr < statuses[j].Addr
+	})
+	return statuses
+}
+
 // forward is a hint to speed up searching.
 func (router *ScoreBasedRouter) lookupBackend(addr string, forward bool) *glist.Element[*backendWrapper] {
 	if forward {
@@ -259,12 +434,22 @@ func (router *ScoreBasedRouter) onRedirectFinished(from, to string, conn Redirec
 		router.removeConn(fromBe, router.getConnWrapper(conn))
 		router.addConn(toBe, connWrapper)
 		connWrapper.phase = phaseRedirectEnd
+		curTime := time.Now()
+		// The connection goes back to the backend that it just left.
+		if connWrapper.prevAddr == to && connWrapper.lastMigrated.Add(migrationWindow).After(curTime) {
+			addMigratePingPongMetrics(from, to)
+		}
+		connWrapper.prevAddr = from
+		connWrapper.lastMigrated = curTime
 	} else {
 		fromBe.Value.connScore++
 		router.adjustBackendList(fromBe)
 		toBe.Value.connScore--
 		router.adjustBackendList(toBe)
 		connWrapper.phase = phaseRedirectFail
+		if fromBe.Value.draining {
+			fromBe.Value.drainFailures++
+		}
 	}
 	addMigrateMetrics(from, to, succeed, connWrapper.lastRedirect)
 }
@@ -286,6 +471,7 @@ func (router *ScoreBasedRouter) OnBackendChanged(backends map[string]*backendHea
 	router.Lock()
 	defer router.Unlock()
 	router.observeError = err
+	curTime := time.Now()
 	for addr, health := range backends {
 		be := router.lookupBackend(addr, true)
 		if be == nil && health.status != StatusCannotConnect {
@@ -296,31 +482,86 @@ func (router *ScoreBasedRouter) OnBackendChanged(backends map[string]*backendHea
 				addr:          addr,
 				connList:      glist.New[*connWrapper](),
 			})
+			router.updateBackendAttrs(be.Value)
+			// A restarted backend is removed when it's down and added back as a new one.
+			if router.backendsInited && health.status == StatusHealthy {
+				router.startWarmUp(be.Value, curTime)
+			}
+			if _, ok := router.restoredDrains[addr]; ok {
+				be.Value.draining = true
+				delete(router.restoredDrains, addr)
+			}
 			router.adjustBackendList(be)
 		} else if be != nil {
 			backend := be.Value
 			router.logger.Info("update backend", zap.String("backend_addr", addr),
 				zap.String("prev", backend.String()), zap.String("cur", health.String()))
+			// The backend may recover before it's removed.
+			if health.status == StatusHealthy && (backend.status == StatusCannotConnect || backend.status == StatusSchemaOutdated) {
+				router.startWarmUp(backend, curTime)
+			} else if health.status != StatusHealthy {
+				backend.warmUpSince = time.Time{}
+			}
 			backend.backendHealth = health
+			router.updateBackendAttrs(backend)
 			router.adjustBackendList(be)
 			for ele := backend.connList.Front(); ele != nil; ele = ele.Next() {
 				conn := ele.Value
 				conn.NotifyBackendStatus(health.status)
+				conn.NotifyEvacuating(backend.evacuating())
 			}
 		}
 	}
 	if len(backends) > 0 {
 		router.updateServerVersion()
 	}
+	if err == nil {
+		router.backendsInited = true
+	}
+}
+
+// startWarmUp starts warming up the backend if slow start is enabled.
+func (router *ScoreBasedRouter) startWarmUp(backend *backendWrapper, curTime time.Time) {
+	if router.slowStart <= 0 {
+		return
+	}
+	backend.warmUpSince = curTime
+	backend.warmUpFactor = warmUpMinFactor
+	router.logger.Info("backend starts warming up", zap.String("backend_addr", backend.addr), zap.Duration("slow_start", router.slowStart))
+}
+
+// updateWarmUp raises the capacity of the warming-up backends as time goes on.
+func (router *ScoreBasedRouter) updateWarmUp(curTime time.Time) {
+	var warming []*glist.Element[*backendWrapper]
+	for be := router.backends.Front(); be != nil; be = be.Next() {
+		if be.Value.warmingUp() {
+			warming = append(warming, be)
+		}
+	}
+	// Adjust the list after iterating it because adjusting moves the elements.
+	for _, be := range warming {
+		backend := be.Value
+		factor := float64(curTime.Sub(backend.warmUpSince)) / float64(router.slowStart)
+		if factor >= 1 {
+			backend.warmUpSince = time.Time{}
+			router.logger.Info("backend is warmed up", zap.String("backend_addr", backend.addr))
+		} else if factor > warmUpMinFactor {
+			backend.warmUpFactor = factor
+		}
+		router.adjustBackendList(be)
+	}
 }
 
 func (router *ScoreBasedRouter) rebalanceLoop(ctx context.Context) {
 	for {
-		router.rebalance(rebalanceConnsPerLoop)
+		router.Lock()
+		interval, maxNum := router.rebalanceCfg.Interval, router.rebalanceCfg.ConnsPerLoop
+		router.Unlock()
+		router.rebalance(maxNum)
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(rebalanceInterval):
+		case <-time.After(interval):
 		}
 	}
 }
@@ -329,54 +570,257 @@ func (router *ScoreBasedRouter) rebalance(maxNum int) {
 	curTime := time.Now()
 	router.Lock()
 	defer router.Unlock()
+	// The warm-up also affects routing, so it's updated even if rebalancing is paused.
+	router.updateWarmUp(curTime)
+	if router.rebalancePaused {
+		return
+	}
+	for _, plan := range router.planMigrations(curTime, maxNum) {
+		busiestBackend, idlestBackend := plan.from.Value, plan.to.Value
+		conn := plan.conn.Value
+		router.logger.Info("begin redirect connection", zap.Uint64("connID", conn.ConnectionID()),
+			zap.String("from", busiestBackend.addr), zap.String("to", idlestBackend.addr),
+			zap.Int("from_score", busiestBackend.score()), zap.Int("to_score", idlestBackend.score()),
+			zap.String("reason", plan.reason))
+		conn.lastRedirect = curTime
+		if isBalanceMigration(busiestBackend, plan.reason) {
+			conn.balanceMigrations = append(conn.balanceMigrations, curTime)
+		}
+		conn.Redirect(idlestBackend.addr)
+	}
+}
+
+// migrationPlan is a connection to be migrated in this round.
+type migrationPlan struct {
+	from      *glist.Element[*backendWrapper]
+	to        *glist.Element[*backendWrapper]
+	conn      *glist.Element[*connWrapper]
+	prevPhase connPhase
+	reason    string
+}
+
+func (plan *migrationPlan) toMigration() Migration {
+	return Migration{
+		ConnectionID: plan.conn.Value.ConnectionID(),
+		From:         plan.from.Value.addr,
+		To:           plan.to.Value.addr,
+		Reason:       plan.reason,
+	}
+}
+
+// planMigrations picks at most maxNum connections to migrate. The scores and phases are updated as if
+// the connections were redirecting, so that the following picks consider the previous ones.
+func (router *ScoreBasedRouter) planMigrations(curTime time.Time, maxNum int) []migrationPlan {
+	var plans []migrationPlan
 	for i := 0; i < maxNum; i++ {
-		var busiestEle *glist.Element[*backendWrapper]
-		for be := router.backends.Front(); be != nil; be = be.Next() {
-			backend := be.Value
-			if backend.connList.Len() > 0 {
-				busiestEle = be
-				break
+		reason := MigrateReasonZone
+		busiestEle, idlestEle, ce := router.pickZoneMigration(curTime)
+		if ce == nil {
+			if router.policy != nil {
+				reason = MigrateReasonUnhealthy
+				busiestEle, idlestEle, ce = router.pickUnhealthyMigration(curTime)
+			} else {
+				reason = MigrateReasonScore
+				busiestEle, idlestEle, ce = router.pickScoreMigration(curTime)
 			}
 		}
-		if busiestEle == nil {
+		if ce == nil {
 			break
 		}
-		busiestBackend := busiestEle.Value
-		idlestEle := router.backends.Back()
-		idlestBackend := idlestEle.Value
-		if float64(busiestBackend.score())/float64(idlestBackend.score()+1) < rebalanceMaxScoreRatio {
+		plans = append(plans, migrationPlan{
+			from:      busiestEle,
+			to:        idlestEle,
+			conn:      ce,
+			prevPhase: ce.Value.phase,
+			reason:    reason,
+		})
+		busiestEle.Value.connScore--
+		router.adjustBackendList(busiestEle)
+		idlestEle.Value.connScore++
+		router.adjustBackendList(idlestEle)
+		ce.Value.phase = phaseRedirectNotify
+	}
+	return plans
+}
+
+// pickConn returns a connection on the backend that can be migrated and the backend to migrate it to.
+// The connections in the same group have the same target, so pickTarget is called once for each group.
+func (router *ScoreBasedRouter) pickConn(be *glist.Element[*backendWrapper], curTime time.Time, forBalance bool,
+	pickTarget func(group string) *glist.Element[*backendWrapper]) (*glist.Element[*backendWrapper], *glist.Element[*connWrapper]) {
+	targets := make(map[string]*glist.Element[*backendWrapper])
+	for ele := be.Value.connList.Front(); ele != nil; ele = ele.Next() {
+		conn := ele.Value
+		if !conn.canMigrate(curTime) || (forBalance && !router.canMigrateForBalance(conn, curTime)) {
+			continue
+		}
+		target, ok := targets[conn.group]
+		if !ok {
+			target = pickTarget(conn.group)
+			targets[conn.group] = target
+		}
+		if target != nil {
+			return target, ele
+		}
+		// Without groups, no connection on this backend has a target.
+		if len(router.groups) == 0 {
 			break
 		}
-		var ce *glist.Element[*connWrapper]
-		for ele := busiestBackend.connList.Front(); ele != nil; ele = ele.Next() {
-			conn := ele.Value
-			switch conn.phase {
-			case phaseRedirectNotify:
-				// A connection cannot be redirected again when it has not finished redirecting.
-				continue
-			case phaseRedirectFail:
-				// If it failed recently, it will probably fail this time.
-				if conn.lastRedirect.Add(redirectFailMinInterval).After(curTime) {
-					continue
-				}
+	}
+	return nil, nil
+}
+
+// pickZoneMigration returns a connection on a backend in another zone and a healthy backend in the local zone
+// to migrate it back to. The connections go to other zones only when the local zone has no healthy backends,
+// so they return once the local zone recovers.
+func (router *ScoreBasedRouter) pickZoneMigration(curTime time.Time) (*glist.Element[*backendWrapper],
+	*glist.Element[*backendWrapper], *glist.Element[*connWrapper]) {
+	if len(router.zone) == 0 {
+		return nil, nil, nil
+	}
+	for be := router.backends.Front(); be != nil; be = be.Next() {
+		backend := be.Value
+		if backend.inZone(router.zone) || backend.connList.Len() == 0 {
+			continue
+		}
+		forBalance := isBalanceMigration(backend, MigrateReasonZone)
+		to, ce := router.pickConn(be, curTime, forBalance, func(group string) *glist.Element[*backendWrapper] {
+			if router.policy != nil {
+				healthy, _ := router.routableBackends(nil, group)
+				return router.pickFrom(router.localBackends(healthy), "")
 			}
-			ce = ele
+			return router.idlestBackend(group, nil, true)
+		})
+		if ce != nil {
+			return be, to, ce
+		}
+	}
+	return nil, nil, nil
+}
+
+// pickScoreMigration returns a connection on a busy backend and the idlest backend in its group
+// if their scores differ too much.
+func (router *ScoreBasedRouter) pickScoreMigration(curTime time.Time) (*glist.Element[*backendWrapper],
+	*glist.Element[*backendWrapper], *glist.Element[*connWrapper]) {
+	idlestEle := router.idlestBackend("", nil, false)
+	if idlestEle == nil {
+		return nil, nil, nil
+	}
+	for be := router.backends.Front(); be != nil; be = be.Next() {
+		backend := be.Value
+		// The backends are in descending order of scores, so the rest are balanced if this one is balanced.
+		if !router.needRebalance(backend, idlestEle.Value) {
 			break
 		}
-		if ce == nil {
+		if backend.connList.Len() == 0 {
+			continue
+		}
+		forBalance := isBalanceMigration(backend, MigrateReasonScore)
+		to, ce := router.pickConn(be, curTime, forBalance, func(group string) *glist.Element[*backendWrapper] {
+			// Don't migrate the connections to other zones if the local zone is healthy.
+			target := router.idlestBackend(group, []string{backend.addr}, router.hasLocalBackend(group))
+			if target == nil || !router.needRebalance(backend, target.Value) {
+				return nil
+			}
+			return target
+		})
+		if ce != nil {
+			return be, to, ce
+		}
+	}
+	return nil, nil, nil
+}
+
+// isBalanceMigration returns true if the migration is made for balance instead of evacuating an unhealthy
+// or draining backend.
+func isBalanceMigration(from *backendWrapper, reason string) bool {
+	return reason != MigrateReasonUnhealthy && from.status == StatusHealthy && !from.draining
+}
+
+// canMigrateForBalance returns true if the connection can be migrated for balance. It's limited so that
+// a connection isn't migrated back and forth when the scores oscillate. The migrations from unhealthy or
+// draining backends are not limited.
+func (router *ScoreBasedRouter) canMigrateForBalance(conn *connWrapper, curTime time.Time) bool {
+	if dwell := router.rebalanceCfg.MinDwellTime; dwell > 0 && conn.lastMigrated.Add(dwell).After(curTime) {
+		return false
+	}
+	if maxNum := router.rebalanceCfg.MaxMigrationsPerHour; maxNum > 0 && conn.recentMigrations(curTime) >= maxNum {
+		return false
+	}
+	return true
+}
+
+func (router *ScoreBasedRouter) needRebalance(from, to *backendWrapper) bool {
+	return float64(from.score())/float64(to.score()+1) >= router.rebalanceCfg.MaxScoreRatio
+}
+
+// idlestBackend returns the routable backend with the lowest score in the group, except the excluded ones.
+// An empty group means any backend. If local is true, only the healthy backends in the local zone are returned.
+func (router *ScoreBasedRouter) idlestBackend(group string, excluded []string, local bool) *glist.Element[*backendWrapper] {
+	if local && len(router.zone) == 0 {
+		return nil
+	}
+	for be := router.backends.Back(); be != nil; be = be.Prev() {
+		backend := be.Value
+		if !backend.routable() || !backend.inGroup(group) || (local && !router.isLocal(backend)) {
+			continue
+		}
+		found := false
+		for _, ex := range excluded {
+			if ex == backend.addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return be
+		}
+	}
+	return nil
+}
+
+// isLocal returns true if the backend is healthy and in the same zone as TiProxy.
+func (router *ScoreBasedRouter) isLocal(backend *backendWrapper) bool {
+	return backend.status == StatusHealthy && backend.inZone(router.zone)
+}
+
+// hasLocalBackend returns true if any backend in the group is local and routable.
+func (router *ScoreBasedRouter) hasLocalBackend(group string) bool {
+	return router.idlestBackend(group, nil, true) != nil
+}
+
+// localBackends returns the local ones in the candidates.
+func (router *ScoreBasedRouter) localBackends(candidates []*glist.Element[*backendWrapper]) []*glist.Element[*backendWrapper] {
+	if len(router.zone) == 0 {
+		return nil
+	}
+	var local []*glist.Element[*backendWrapper]
+	for _, be := range candidates {
+		if router.isLocal(be.Value) {
+			local = append(local, be)
+		}
+	}
+	return local
+}
+
+// updateBackendAttrs updates the group and weight of the backend after its labels change.
+func (router *ScoreBasedRouter) updateBackendAttrs(backend *backendWrapper) {
+	backend.group = ""
+	for i := range router.groups {
+		if router.groups[i].Match(backend.addr, backend.labels) {
+			backend.group = router.groups[i].Name
 			break
 		}
-		conn := ce.Value
-		router.logger.Info("begin redirect connection", zap.Uint64("connID", conn.ConnectionID()),
-			zap.String("from", busiestBackend.addr), zap.String("to", idlestBackend.addr),
-			zap.Int("from_score", busiestBackend.score()), zap.Int("to_score", idlestBackend.score()))
-		busiestBackend.connScore--
-		router.adjustBackendList(busiestEle)
-		idlestBackend.connScore++
-		router.adjustBackendList(idlestEle)
-		conn.phase = phaseRedirectNotify
-		conn.lastRedirect = curTime
-		conn.Redirect(idlestBackend.addr)
+	}
+	backend.weight = 1
+	if weight, ok := router.weights[backend.addr]; ok {
+		backend.weight = weight
+	} else if label, ok := backend.labels[router.weightLabel]; ok && router.weightLabel != "" {
+		if weight, err := strconv.Atoi(label); err == nil && weight > 0 {
+			backend.weight = weight
+		} else {
+			router.logger.Warn("invalid weight label", zap.String("backend_addr", backend.addr),
+				zap.String("label", router.weightLabel), zap.String("value", label))
+		}
 	}
 }
 
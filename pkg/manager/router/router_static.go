@@ -3,6 +3,8 @@
 
 package router
 
+import "github.com/pingcap/TiProxy/lib/config"
+
 var _ Router = &StaticRouter{}
 
 type StaticRouter struct {
@@ -16,7 +18,7 @@ func NewStaticRouter(addr []string) *StaticRouter {
 
 func (r *StaticRouter) GetBackendSelector() BackendSelector {
 	return BackendSelector{
-		routeOnce: func(excluded []string) (string, error) {
+		routeOnce: func(excluded []string, _ RouteHint) (string, error) {
 			for _, addr := range r.addrs {
 				found := false
 				for _, e := range excluded {
@@ -31,7 +33,7 @@ func (r *StaticRouter) GetBackendSelector() BackendSelector {
 			}
 			return "", nil
 		},
-		onCreate: func(addr string, conn RedirectableConn, succeed bool) {
+		onCreate: func(addr string, conn RedirectableConn, succeed bool, _ RouteHint) {
 			if succeed {
 				r.cnt++
 			}
@@ -41,10 +43,34 @@ func (r *StaticRouter) GetBackendSelector() BackendSelector {
 
 func (r *StaticRouter) RefreshBackend() {}
 
+func (r *StaticRouter) SetHealthCheckConfig(cfg *config.HealthCheck) {}
+
 func (r *StaticRouter) RedirectConnections() error {
 	return nil
 }
 
+func (r *StaticRouter) DrainBackend(addr string, drain bool) error {
+	return ErrDrainNotSupported
+}
+
+func (r *StaticRouter) DrainStatuses() []DrainStatus {
+	return nil
+}
+
+func (r *StaticRouter) RestoreDraining(addrs []string) {}
+
+func (r *StaticRouter) SetZone(zone string) {}
+
+func (r *StaticRouter) SetRebalanceConfig(cfg *config.Rebalance) {}
+
+func (r *StaticRouter) PauseRebalance(pause bool) error {
+	return ErrRebalanceNotSupported
+}
+
+func (r *StaticRouter) PreviewRebalance() ([]Migration, error) {
+	return nil, ErrRebalanceNotSupported
+}
+
 func (r *StaticRouter) ConnCount() int {
 	return r.cnt
 }
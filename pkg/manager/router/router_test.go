@@ -28,6 +28,8 @@ type mockRedirectableConn struct {
 	from, to string
 	status   BackendStatus
 	receiver ConnEventReceiver
+	// evacuating is notified when the backend is drained or removed.
+	evacuating bool
 }
 
 func newMockRedirectableConn(t *testing.T, id uint64) *mockRedirectableConn {
@@ -77,6 +79,12 @@ func (conn *mockRedirectableConn) NotifyBackendStatus(status BackendStatus) {
 	conn.Unlock()
 }
 
+func (conn *mockRedirectableConn) NotifyEvacuating(evacuating bool) {
+	conn.Lock()
+	conn.evacuating = evacuating
+	conn.Unlock()
+}
+
 func (conn *mockRedirectableConn) ConnectionID() uint64 {
 	return conn.connID
 }
@@ -887,3 +895,292 @@ func TestGetServerVersion(t *testing.T) {
 	version := rt.ServerVersion()
 	require.True(t, version == "1.0" || version == "2.0")
 }
+
+func TestDrainBackend(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.addBackends(3)
+	tester.addConnections(30)
+	require.True(t, errors.Is(tester.router.DrainBackend("unknown", true), ErrBackendNotFound))
+	require.Empty(t, tester.router.DrainStatuses())
+
+	// New connections are not routed to the draining backend.
+	require.NoError(t, tester.router.DrainBackend("1", true))
+	tester.checkBackendOrder()
+	for i := 0; i < 10; i++ {
+		require.NotEqual(t, "1", tester.simpleRoute(tester.createConn()))
+	}
+
+	// The connections on the draining backend are migrated first.
+	tester.rebalance(10)
+	tester.checkRedirectingNum(10)
+	for _, conn := range tester.conns {
+		if len(conn.GetRedirectingAddr()) > 0 {
+			require.Equal(t, "1", conn.from)
+			require.NotEqual(t, "1", conn.GetRedirectingAddr())
+		}
+	}
+	tester.redirectFinish(4, false)
+	tester.redirectFinish(6, true)
+	require.Equal(t, []DrainStatus{{Addr: "1", Remaining: 4, Failures: 4}}, tester.router.DrainStatuses())
+	// Only the connections that remain on the draining backend are evacuating.
+	for _, conn := range tester.conns {
+		require.Equal(t, conn.from == "1", conn.evacuating, conn.connID)
+	}
+
+	// Undraining makes it routable again.
+	require.NoError(t, tester.router.DrainBackend("1", false))
+	require.Empty(t, tester.router.DrainStatuses())
+	for _, conn := range tester.conns {
+		require.False(t, conn.evacuating, conn.connID)
+	}
+	tester.checkBackendOrder()
+	require.Equal(t, "1", tester.simpleRoute(tester.createConn()))
+}
+
+// Test that the draining state is restored for both the existing backends and the backends found later.
+func TestRestoreDraining(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.addBackends(2)
+	tester.router.RestoreDraining([]string{"1", "3", "4"})
+	// Undraining the backend that is not found yet cancels restoring.
+	require.True(t, errors.Is(tester.router.DrainBackend("4", false), ErrBackendNotFound))
+	tester.addBackends(2)
+	tester.checkBackendOrder()
+	statuses := tester.router.DrainStatuses()
+	addrs := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		addrs = append(addrs, status.Addr)
+	}
+	require.Equal(t, []string{"1", "3"}, addrs)
+	for i := 0; i < 10; i++ {
+		addr := tester.simpleRoute(tester.createConn())
+		require.True(t, addr == "2" || addr == "4", addr)
+	}
+}
+
+// Test that the connections pinned to a group are only routed and migrated within the group,
+// and the weights are considered in the scores.
+func TestBackendGroups(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.router.setBackendConfig(&config.BackendNamespace{
+		Weights:     map[string]int{"2": 2},
+		WeightLabel: "weight",
+		Groups: []config.BackendGroup{
+			{Name: "oltp", Instances: []string{"1", "2"}},
+			{Name: "olap", Labels: map[string]string{"pool": "olap"}},
+		},
+	})
+	tester.router.OnBackendChanged(map[string]*backendHealth{
+		"1": {status: StatusHealthy},
+		"2": {status: StatusHealthy, labels: map[string]string{"weight": "5"}},
+		"3": {status: StatusHealthy, labels: map[string]string{"pool": "olap", "weight": "abc"}},
+	}, nil)
+	tester.checkBackendOrder()
+	weights := make(map[string]int)
+	for be := tester.router.backends.Front(); be != nil; be = be.Next() {
+		weights[be.Value.addr] = be.Value.weight
+	}
+	// The weights in the config take precedence over the labels and the invalid labels are ignored.
+	require.Equal(t, map[string]int{"1": 1, "2": 2, "3": 1}, weights)
+
+	route := func(group string, num int) {
+		for i := 0; i < num; i++ {
+			conn := tester.createConn()
+			selector := tester.router.GetBackendSelector()
+			selector.SetRouteHint(RouteHint{Group: group})
+			addr, err := selector.Next()
+			require.NoError(t, err)
+			require.NotEmpty(t, addr)
+			selector.Finish(conn, true)
+			conn.from = addr
+			tester.conns[conn.connID] = conn
+		}
+		tester.checkBackendOrder()
+	}
+	route("olap", 10)
+	route("oltp", 30)
+	counts := tester.countConns()
+	require.Equal(t, 10, counts["3"])
+	require.Equal(t, 30, counts["1"]+counts["2"])
+	require.InDelta(t, 20, counts["2"], 1)
+
+	// The olap connections are only migrated to the new olap backend.
+	tester.router.OnBackendChanged(map[string]*backendHealth{
+		"4": {status: StatusHealthy, labels: map[string]string{"pool": "olap"}},
+	}, nil)
+	tester.rebalance(100)
+	tester.checkRedirectingNum(5)
+	for _, conn := range tester.conns {
+		if to := conn.GetRedirectingAddr(); len(to) > 0 {
+			require.Equal(t, "3", conn.from)
+			require.Equal(t, "4", to)
+		}
+	}
+	tester.redirectFinish(5, true)
+	tester.rebalance(100)
+	tester.checkRedirectingNum(0)
+}
+
+// Test that the backends in the local zone are preferred and the connections return when the local zone recovers.
+func TestZoneAwareRouting(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.router.SetZone("z1")
+	updateBackend := func(addr, zone string, status BackendStatus) {
+		tester.router.OnBackendChanged(map[string]*backendHealth{
+			addr: {status: status, labels: map[string]string{ZoneLabel: zone}},
+		}, nil)
+		tester.checkBackendOrder()
+	}
+	updateBackend("1", "z1", StatusHealthy)
+	updateBackend("2", "z2", StatusHealthy)
+	updateBackend("3", "z2", StatusHealthy)
+	tester.addConnections(10)
+	require.Equal(t, map[string]int{"1": 10, "2": 0, "3": 0}, tester.countConns())
+	// The connections are not balanced to other zones.
+	tester.rebalance(100)
+	tester.checkRedirectingNum(0)
+
+	// Fall back to other zones when the local zone is unhealthy.
+	updateBackend("1", "z1", StatusMemoryHigh)
+	tester.addConnections(10)
+	counts := tester.countConns()
+	require.Equal(t, 10, counts["1"])
+	require.Equal(t, 10, counts["2"]+counts["3"])
+
+	// The connections in other zones return when the local zone recovers.
+	updateBackend("1", "z1", StatusHealthy)
+	tester.rebalance(100)
+	tester.checkRedirectingNum(10)
+	for _, conn := range tester.conns {
+		if to := conn.GetRedirectingAddr(); len(to) > 0 {
+			require.Equal(t, "1", to)
+		}
+	}
+	tester.redirectFinish(10, true)
+	require.Equal(t, map[string]int{"1": 20, "2": 0, "3": 0}, tester.countConns())
+}
+
+func TestRebalanceControl(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.addBackends(1)
+	tester.addConnections(40)
+	tester.addBackends(1)
+
+	// Previewing doesn't change the router.
+	migrations, err := tester.router.PreviewRebalance()
+	require.NoError(t, err)
+	require.Len(t, migrations, rebalanceConnsPerLoop)
+	for _, migration := range migrations {
+		require.Equal(t, Migration{ConnectionID: migration.ConnectionID, From: "1", To: "2", Reason: MigrateReasonScore}, migration)
+	}
+	require.Equal(t, map[string]int{"1": 40, "2": 0}, tester.countConns())
+	require.Equal(t, 40, tester.getBackendByIndex(0).connScore)
+	tester.checkRedirectingNum(0)
+
+	require.NoError(t, tester.router.PauseRebalance(true))
+	tester.rebalance(100)
+	tester.checkRedirectingNum(0)
+	migrations, err = tester.router.PreviewRebalance()
+	require.NoError(t, err)
+	require.Empty(t, migrations)
+	require.NoError(t, tester.router.PauseRebalance(false))
+
+	// A larger ratio migrates fewer connections.
+	tester.router.SetRebalanceConfig(&config.Rebalance{ConnsPerLoop: 3, MaxScoreRatio: 3})
+	migrations, err = tester.router.PreviewRebalance()
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	tester.rebalance(100)
+	// 40:0 -> 30:10
+	tester.checkRedirectingNum(10)
+}
+
+func TestSlowStart(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.router.slowStart = 10 * time.Second
+	// The backends that exist when the router starts don't warm up.
+	tester.addBackends(1)
+	require.False(t, tester.getBackendByIndex(0).warmingUp())
+	tester.addConnections(100)
+	tester.addBackends(1)
+	backend := tester.router.lookupBackend("2", true).Value
+	require.True(t, backend.warmingUp())
+
+	// The capacity is 10% at first: 92:8.
+	tester.rebalance(100)
+	tester.checkRedirectingNum(8)
+	tester.redirectFinish(8, true)
+
+	// The capacity rises to 50%: 70:30.
+	backend.warmUpSince = time.Now().Add(-5 * time.Second)
+	tester.router.updateWarmUp(backend.warmUpSince.Add(5 * time.Second))
+	tester.checkBackendOrder()
+	// Preview all the migrations instead of the default number of each round.
+	tester.router.SetRebalanceConfig(&config.Rebalance{ConnsPerLoop: 100})
+	migrations, err := tester.router.PreviewRebalance()
+	require.NoError(t, err)
+	require.Len(t, migrations, 22)
+
+	// The backend is warmed up and balanced like others.
+	backend.warmUpSince = time.Now().Add(-time.Minute)
+	tester.rebalance(100)
+	require.False(t, backend.warmingUp())
+	tester.redirectFinish(100, true)
+	tester.checkBalanced()
+
+	// New connections are also limited by the capacity.
+	tester.addBackends(1)
+	tester.addConnections(30)
+	counts := tester.countConns()
+	require.Greater(t, counts["3"], 0)
+	require.LessOrEqual(t, counts["3"], 10)
+
+	// The backend warms up again after it recovers.
+	tester.updateBackendStatusByAddr("3", StatusCannotConnect)
+	tester.updateBackendStatusByAddr("3", StatusHealthy)
+	require.True(t, tester.router.lookupBackend("3", true).Value.warmingUp())
+}
+
+func TestMigrationHysteresis(t *testing.T) {
+	tester := newRouterTester(t)
+	tester.addBackends(1)
+	tester.addConnections(10)
+	tester.addBackends(1)
+	tester.router.SetRebalanceConfig(&config.Rebalance{MinDwellTime: time.Hour})
+	// 10:0 -> 5:5
+	tester.rebalance(100)
+	tester.checkRedirectingNum(5)
+	tester.redirectFinish(5, true)
+
+	// Close the connections on backend 1 so that the scores flip.
+	for id, conn := range tester.conns {
+		if conn.from == "1" {
+			require.NoError(t, tester.router.OnConnClosed(conn.from, conn))
+			delete(tester.conns, id)
+		}
+	}
+	// The migrated connections stay on backend 2 within the dwell time.
+	tester.rebalance(100)
+	tester.checkRedirectingNum(0)
+	// Each connection has been migrated once in the last hour.
+	tester.router.SetRebalanceConfig(&config.Rebalance{MaxMigrationsPerHour: 1})
+	tester.rebalance(100)
+	tester.checkRedirectingNum(0)
+
+	// Without limits, they are migrated back and counted as ping-pong: 5:0 -> 3:2
+	prevCount, err := readMigratePingPongCounter("2", "1")
+	require.NoError(t, err)
+	tester.router.SetRebalanceConfig(&config.Rebalance{})
+	tester.rebalance(100)
+	tester.checkRedirectingNum(2)
+	tester.redirectFinish(2, true)
+	count, err := readMigratePingPongCounter("2", "1")
+	require.NoError(t, err)
+	require.Equal(t, prevCount+2, count)
+
+	// The connections on an unhealthy backend are migrated regardless of the limits.
+	tester.router.SetRebalanceConfig(&config.Rebalance{MinDwellTime: time.Hour, MaxMigrationsPerHour: 1})
+	tester.updateBackendStatusByAddr("1", StatusCannotConnect)
+	tester.rebalance(100)
+	tester.checkRedirectingNum(2)
+}
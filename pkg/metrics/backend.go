@@ -43,4 +43,12 @@ var (
 			Name:      "ping_duration_seconds",
 			Help:      "Time (s) of pinging the SQL port of each backend.",
 		}, []string{LblBackend})
+
+	BackendPoolCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelBackend,
+			Name:      "pool_conn",
+			Help:      "Counter of getting pre-established connections from the backend pool.",
+		}, []string{LblBackend, LblRes})
 )
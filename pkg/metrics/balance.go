@@ -43,4 +43,12 @@ var (
 			Help:      "Bucketed histogram of migrating time (s) of sessions.",
 			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 26), // 0.1ms ~ 1h
 		}, []string{LblFrom, LblTo, LblMigrateResult})
+
+	MigratePingPongCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelBalance,
+			Name:      "migrate_ping_pong_total",
+			Help:      "Number of sessions that are migrated back to the backend they left within an hour.",
+		}, []string{LblFrom, LblTo})
 )
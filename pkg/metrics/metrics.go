@@ -30,11 +30,12 @@ const (
 
 // metrics labels.
 const (
-	LabelServer  = "server"
-	LabelBalance = "balance"
-	LabelSession = "session"
-	LabelMonitor = "monitor"
-	LabelBackend = "backend"
+	LabelServer    = "server"
+	LabelBalance   = "balance"
+	LabelSession   = "session"
+	LabelMonitor   = "monitor"
+	LabelBackend   = "backend"
+	LabelNamespace = "namespace"
 )
 
 // MetricsManager manages metrics.
@@ -109,17 +110,24 @@ func registerProxyMetrics() {
 	prometheus.MustRegister(MaxProcsGauge)
 	prometheus.MustRegister(ServerEventCounter)
 	prometheus.MustRegister(ServerErrCounter)
+	prometheus.MustRegister(AccessRejectCounter)
 	prometheus.MustRegister(TimeJumpBackCounter)
 	prometheus.MustRegister(KeepAliveCounter)
 	prometheus.MustRegister(QueryTotalCounter)
 	prometheus.MustRegister(QueryDurationHistogram)
+	prometheus.MustRegister(QueryTimeoutCounter)
+	prometheus.MustRegister(DetachedSessionGauge)
 	prometheus.MustRegister(BackendStatusGauge)
 	prometheus.MustRegister(GetBackendHistogram)
 	prometheus.MustRegister(GetBackendCounter)
 	prometheus.MustRegister(PingBackendGauge)
+	prometheus.MustRegister(BackendPoolCounter)
 	prometheus.MustRegister(BackendConnGauge)
 	prometheus.MustRegister(MigrateCounter)
 	prometheus.MustRegister(MigrateDurationHistogram)
+	prometheus.MustRegister(MigratePingPongCounter)
+	prometheus.MustRegister(BreakerStateGauge)
+	prometheus.MustRegister(BreakerRejectCounter)
 }
 
 // prometheusPushClient pushes metrics to Prometheus Pushgateway.
@@ -0,0 +1,24 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	BreakerStateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelNamespace,
+			Name:      "breaker_state",
+			Help:      "State of the circuit breaker of each namespace. 0: closed, 1: open, 2: half-open.",
+		}, []string{LabelNamespace})
+
+	BreakerRejectCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelNamespace,
+			Name:      "breaker_reject_total",
+			Help:      "Counter of connections rejected by the circuit breaker of each namespace.",
+		}, []string{LabelNamespace})
+)
@@ -12,6 +12,7 @@ import (
 
 const (
 	LblType = "type"
+	LblRule = "rule"
 
 	EventStart = "start"
 	EventClose = "close"
@@ -50,6 +51,14 @@ var (
 			Help:      "Counter of server error.",
 		}, []string{LblType})
 
+	AccessRejectCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelServer,
+			Name:      "access_reject_total",
+			Help:      "Counter of connections rejected by the access lists. The namespace is empty for the global list.",
+		}, []string{LabelNamespace, LblRule})
+
 	TimeJumpBackCounter = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Namespace: ModuleProxy,
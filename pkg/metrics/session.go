@@ -30,4 +30,20 @@ var (
 			Help:      "Bucketed histogram of processing time (s) of handled queries.",
 			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 29), // 0.5ms ~ 1.5days
 		}, []string{LblBackend, LblCmdType})
+
+	QueryTimeoutCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "query_timeout_total",
+			Help:      "Counter of statements that exceed the SQL timeout and the results of killing them.",
+		}, []string{LblBackend, LblRes})
+
+	DetachedSessionGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "detached_sessions",
+			Help:      "Number of idle sessions that are detached from backends.",
+		})
 )
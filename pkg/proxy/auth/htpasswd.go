@@ -0,0 +1,115 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GehirnInc/crypt"
+	"github.com/GehirnInc/crypt/sha256_crypt"
+	"github.com/GehirnInc/crypt/sha512_crypt"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ Provider = (*htpasswdProvider)(nil)
+
+// htpasswdProvider verifies the users with a static file of `user:hash` lines.
+// The file is reloaded once it's modified so that the users can be updated online.
+type htpasswdProvider struct {
+	sync.Mutex
+	file    string
+	modTime time.Time
+	users   map[string]string
+}
+
+func newHtpasswdProvider(file string) (*htpasswdProvider, error) {
+	hp := &htpasswdProvider{file: file}
+	if err := hp.reload(); err != nil {
+		return nil, err
+	}
+	return hp, nil
+}
+
+func (hp *htpasswdProvider) Authenticate(user, password string) error {
+	hp.Lock()
+	// Keep using the old users if the file is being rewritten.
+	_ = hp.reload()
+	hashed, ok := hp.users[user]
+	hp.Unlock()
+	if !ok {
+		return errors.Wrapf(ErrAuthFailed, "user %s not found", user)
+	}
+	return verifyHash(hashed, password)
+}
+
+// reload reads the file if it's modified.
+// NOTE: the lock should be held before calling this function.
+func (hp *htpasswdProvider) reload() error {
+	info, err := os.Stat(hp.file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if hp.users != nil && info.ModTime().Equal(hp.modTime) {
+		return nil
+	}
+	f, err := os.Open(hp.file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		user, hashed, ok := strings.Cut(line, ":")
+		if !ok {
+			return errors.Wrapf(ErrUnsupportedHash, "malformed line in %s", hp.file)
+		}
+		users[user] = hashed
+	}
+	if err = scanner.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	hp.users, hp.modTime = users, info.ModTime()
+	return nil
+}
+
+// verifyHash checks the password against a bcrypt, sha256-crypt or sha512-crypt hash.
+func verifyHash(hashed, password string) error {
+	switch {
+	case strings.HasPrefix(hashed, "$2a$"), strings.HasPrefix(hashed, "$2b$"), strings.HasPrefix(hashed, "$2y$"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrAuthFailed
+			}
+			return errors.Wrap(ErrUnsupportedHash, err)
+		}
+		return nil
+	case strings.HasPrefix(hashed, sha256_crypt.MagicPrefix):
+		return verifyCrypt(sha256_crypt.New(), hashed, password)
+	case strings.HasPrefix(hashed, sha512_crypt.MagicPrefix):
+		return verifyCrypt(sha512_crypt.New(), hashed, password)
+	default:
+		return errors.Wrapf(ErrUnsupportedHash, "%.3s", hashed)
+	}
+}
+
+// verifyCrypt checks the password against a hash in the format of glibc crypt(3).
+func verifyCrypt(crypter crypt.Crypter, hashed, password string) error {
+	if err := crypter.Verify(hashed, []byte(password)); err != nil {
+		if errors.Is(err, crypt.ErrKeyMismatch) {
+			return ErrAuthFailed
+		}
+		return errors.Wrap(ErrUnsupportedHash, err)
+	}
+	return nil
+}
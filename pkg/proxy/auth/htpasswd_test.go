@@ -0,0 +1,88 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyHash(t *testing.T) {
+	tests := []struct {
+		hashed   string
+		password string
+		err      error
+	}{
+		{
+			hashed:   "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+			password: "Hello world!",
+		},
+		{
+			hashed:   "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+			password: "Hello world!",
+		},
+		{
+			hashed:   "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+			password: "Hello world!",
+		},
+		{
+			hashed:   "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+			password: "Hello world",
+			err:      ErrAuthFailed,
+		},
+		{
+			hashed:   "$2b$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW",
+			password: "secret",
+		},
+		{
+			hashed:   "$2y$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW",
+			password: "secret",
+		},
+		{
+			hashed:   "$2b$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW",
+			password: "Secret",
+			err:      ErrAuthFailed,
+		},
+		{
+			hashed:   "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+			password: "secret",
+			err:      ErrUnsupportedHash,
+		},
+	}
+	for i, test := range tests {
+		err := verifyHash(test.hashed, test.password)
+		if test.err != nil {
+			require.ErrorIs(t, err, test.err, "case %d", i)
+		} else {
+			require.NoError(t, err, "case %d", i)
+		}
+	}
+}
+
+func TestHtpasswdReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# comment\n\nuser1:$2b$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW\n"
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	hp, err := newHtpasswdProvider(file)
+	require.NoError(t, err)
+	require.NoError(t, hp.Authenticate("user1", "secret"))
+	require.ErrorIs(t, hp.Authenticate("user2", "Hello world!"), ErrAuthFailed)
+
+	// The users are reloaded once the file is modified.
+	content += "user2:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5\n"
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	modTime := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+	require.NoError(t, hp.Authenticate("user2", "Hello world!"))
+
+	// The old users are kept if the file is malformed.
+	require.NoError(t, os.WriteFile(file, []byte("user3"), 0600))
+	modTime = modTime.Add(time.Second)
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+	require.NoError(t, hp.Authenticate("user2", "Hello world!"))
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
+const defaultUserClaim = "sub"
+
+var _ Provider = (*jwtProvider)(nil)
+
+// jwtProvider verifies the signed JWT tokens that the clients send as passwords.
+// The token must not be expired and the user claim must equal the user name.
+type jwtProvider struct {
+	secret    []byte
+	rsaKey    *rsa.PublicKey
+	ecKey     *ecdsa.PublicKey
+	issuer    string
+	audience  string
+	userClaim string
+}
+
+func newJWTProvider(cfg config.JWTAuth) (*jwtProvider, error) {
+	jp := &jwtProvider{
+		secret:    []byte(cfg.Secret),
+		issuer:    cfg.Issuer,
+		audience:  cfg.Audience,
+		userClaim: cfg.UserClaim,
+	}
+	if len(jp.userClaim) == 0 {
+		jp.userClaim = defaultUserClaim
+	}
+	if len(cfg.PublicKeyFile) > 0 {
+		pem, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if jp.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+			if jp.ecKey, err = jwt.ParseECPublicKeyFromPEM(pem); err != nil {
+				return nil, errors.Wrapf(config.ErrInvalidProxyAuth, "%s is neither an RSA nor an ECDSA public key", cfg.PublicKeyFile)
+			}
+		}
+	}
+	return jp, nil
+}
+
+func (jp *jwtProvider) Authenticate(user, password string) error {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(password, claims, jp.key)
+	if err != nil {
+		return errors.Wrap(ErrAuthFailed, err)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return errors.Wrapf(ErrAuthFailed, "the token has no expiration time")
+	}
+	if len(jp.issuer) > 0 && !claims.VerifyIssuer(jp.issuer, true) {
+		return errors.Wrapf(ErrAuthFailed, "the issuer of the token mismatches")
+	}
+	if len(jp.audience) > 0 && !claims.VerifyAudience(jp.audience, true) {
+		return errors.Wrapf(ErrAuthFailed, "the audience of the token mismatches")
+	}
+	if claimUser, ok := claims[jp.userClaim].(string); !ok || claimUser != user {
+		return errors.Wrapf(ErrAuthFailed, "the claim %s of the token mismatches the user %s", jp.userClaim, user)
+	}
+	return nil
+}
+
+// key returns the key to verify the signature. The signing method must match the configured key,
+// otherwise a public key may be used as an HMAC secret.
+func (jp *jwtProvider) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(jp.secret) > 0 {
+			return jp.secret, nil
+		}
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if jp.rsaKey != nil {
+			return jp.rsaKey, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if jp.ecKey != nil {
+			return jp.ecKey, nil
+		}
+	}
+	return nil, errors.Errorf("unexpected signing method %s", token.Method.Alg())
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTHMAC(t *testing.T) {
+	jp, err := newJWTProvider(config.JWTAuth{
+		Secret:   "secret",
+		Issuer:   "issuer",
+		Audience: "tiproxy",
+	})
+	require.NoError(t, err)
+	sign := func(claims jwt.MapClaims, secret string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return token
+	}
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "user1",
+			"iss": "issuer",
+			"aud": "tiproxy",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+	}
+	require.NoError(t, jp.Authenticate("user1", sign(validClaims(), "secret")))
+	// The user mismatches.
+	require.ErrorIs(t, jp.Authenticate("user2", sign(validClaims(), "secret")), ErrAuthFailed)
+	// The signature mismatches.
+	require.ErrorIs(t, jp.Authenticate("user1", sign(validClaims(), "secret2")), ErrAuthFailed)
+	// Not a token.
+	require.ErrorIs(t, jp.Authenticate("user1", "secret"), ErrAuthFailed)
+	// The claims are invalid.
+	for _, modify := range []func(jwt.MapClaims){
+		func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		func(claims jwt.MapClaims) { delete(claims, "exp") },
+		func(claims jwt.MapClaims) { claims["iss"] = "issuer2" },
+		func(claims jwt.MapClaims) { claims["aud"] = "tidb" },
+	} {
+		claims := validClaims()
+		modify(claims)
+		require.ErrorIs(t, jp.Authenticate("user1", sign(claims, "secret")), ErrAuthFailed)
+	}
+}
+
+func TestJWTRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pubKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKey}), 0600))
+	jp, err := newJWTProvider(config.JWTAuth{
+		PublicKeyFile: file,
+		UserClaim:     "user",
+	})
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{
+		"user": "user1",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	require.NoError(t, err)
+	require.NoError(t, jp.Authenticate("user1", token))
+
+	// The public key can not be used as an HMAC secret.
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKey}))
+	require.NoError(t, err)
+	require.ErrorIs(t, jp.Authenticate("user1", token), ErrAuthFailed)
+
+	// Not a public key.
+	require.NoError(t, os.WriteFile(file, []byte("key"), 0600))
+	_, err = newJWTProvider(config.JWTAuth{PublicKeyFile: file})
+	require.ErrorIs(t, err, config.ErrInvalidProxyAuth)
+}
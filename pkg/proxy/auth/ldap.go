@@ -0,0 +1,97 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
+const ldapTimeout = 10 * time.Second
+
+var _ Provider = (*ldapProvider)(nil)
+
+// ldapProvider verifies the users by binding to the LDAP server with their DNs and passwords.
+type ldapProvider struct {
+	addr      string
+	bindDN    string
+	tlsConfig *tls.Config
+}
+
+func newLDAPProvider(cfg config.LDAPAuth, tlsConfig *tls.Config) *ldapProvider {
+	return &ldapProvider{
+		addr:      cfg.Addr,
+		bindDN:    cfg.BindDN,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (lp *ldapProvider) Authenticate(user, password string) error {
+	// An empty password makes an unauthenticated bind, which always succeeds.
+	if len(password) == 0 {
+		return errors.Wrapf(ErrAuthFailed, "empty password")
+	}
+	conn, err := lp.dial()
+	if err != nil {
+		return err
+	}
+	lc := ldap.NewConn(conn, lp.tlsConfig != nil)
+	lc.Start()
+	lc.SetTimeout(ldapTimeout)
+	defer lc.Close()
+
+	dn := fmt.Sprintf(lp.bindDN, escapeDN(user))
+	if err = lc.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return errors.Wrapf(ErrAuthFailed, "ldap bind %s: invalid credentials", dn)
+		}
+		return errors.Wrapf(err, "ldap bind %s failed", dn)
+	}
+	return nil
+}
+
+func (lp *ldapProvider) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	var conn net.Conn
+	var err error
+	if lp.tlsConfig != nil {
+		tcfg := lp.tlsConfig.Clone()
+		if host, _, splitErr := net.SplitHostPort(lp.addr); splitErr == nil && tcfg.ServerName == "" {
+			tcfg.ServerName = host
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", lp.addr, tcfg)
+	} else {
+		conn, err = dialer.Dial("tcp", lp.addr)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial ldap server %s error", lp.addr)
+	}
+	return conn, nil
+}
+
+// escapeDN escapes the special characters in an attribute value of a DN, see RFC 4514.
+func escapeDN(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=',
+			(c == ' ' || c == '#') && i == 0, c == ' ' && i == len(value)-1:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == 0:
+			sb.WriteString("\\00")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"net"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/waitgroup"
+	"github.com/stretchr/testify/require"
+)
+
+// mockLDAPServer is a stand-in LDAP server that only supports simple binds.
+type mockLDAPServer struct {
+	listener net.Listener
+	// passwords maps DNs to passwords.
+	passwords map[string]string
+	wg        waitgroup.WaitGroup
+}
+
+func newMockLDAPServer(t *testing.T, passwords map[string]string) *mockLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &mockLDAPServer{
+		listener:  listener,
+		passwords: passwords,
+	}
+	server.wg.Run(func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.wg.Run(func() {
+				server.serve(t, conn)
+			})
+		}
+	})
+	t.Cleanup(func() {
+		require.NoError(t, listener.Close())
+		server.wg.Wait()
+	})
+	return server
+}
+
+func (server *mockLDAPServer) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	packet, err := ber.ReadPacket(conn)
+	require.NoError(t, err)
+	require.Len(t, packet.Children, 2)
+	msgID := packet.Children[0].Value
+	bindReq := packet.Children[1]
+	require.Equal(t, ber.Tag(ldap.ApplicationBindRequest), bindReq.Tag)
+	require.Len(t, bindReq.Children, 3)
+	require.Equal(t, int64(3), bindReq.Children[0].Value)
+	dn := bindReq.Children[1].Value.(string)
+	password := bindReq.Children[2].Data.String()
+
+	code, msg := ldap.LDAPResultSuccess, ""
+	if expected, ok := server.passwords[dn]; !ok || expected != password {
+		code, msg = ldap.LDAPResultInvalidCredentials, "invalid credentials"
+	}
+	resp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "Message ID"))
+	bindResp := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindResponse, nil, "Bind Response")
+	bindResp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	bindResp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	bindResp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, msg, "Diagnostic Message"))
+	resp.AppendChild(bindResp)
+	_, err = conn.Write(resp.Bytes())
+	require.NoError(t, err)
+	// Wait for the client to close the connection.
+	for err == nil {
+		_, err = ber.ReadPacket(conn)
+	}
+}
+
+func TestLDAPProvider(t *testing.T) {
+	server := newMockLDAPServer(t, map[string]string{
+		"uid=user1,dc=example,dc=com":   "pwd1",
+		`uid=a\,b\=c,dc=example,dc=com`: "pwd2",
+	})
+	lp := newLDAPProvider(config.LDAPAuth{
+		Addr:   server.listener.Addr().String(),
+		BindDN: "uid=%s,dc=example,dc=com",
+	}, nil)
+	require.NoError(t, lp.Authenticate("user1", "pwd1"))
+	require.ErrorIs(t, lp.Authenticate("user1", "pwd2"), ErrAuthFailed)
+	require.ErrorIs(t, lp.Authenticate("user2", "pwd1"), ErrAuthFailed)
+	// The special characters in the user name are escaped.
+	require.NoError(t, lp.Authenticate("a,b=c", "pwd2"))
+	// An empty password is rejected before binding.
+	require.ErrorIs(t, lp.Authenticate("user1", ""), ErrAuthFailed)
+
+	lp.addr = "127.0.0.1:0"
+	require.Error(t, lp.Authenticate("user1", "pwd1"))
+}
+
+func TestEscapeDN(t *testing.T) {
+	require.Equal(t, `\ a\+b#\\\<\>\;\"\ `, escapeDN(` a+b#\<>;" `))
+	require.Equal(t, `\#a`, escapeDN("#a"))
+}
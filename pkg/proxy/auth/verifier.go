@@ -0,0 +1,104 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"crypto/tls"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrAuthFailed      = errors.New("authentication failed")
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+	ErrNoIdentity      = errors.New("no service identity is mapped")
+)
+
+// Provider verifies the password of a user.
+type Provider interface {
+	Authenticate(user, password string) error
+}
+
+// Verifier verifies the clients at TiProxy and maps the verified users to the service identities on TiDB.
+// It implements backend.CredentialVerifier.
+type Verifier struct {
+	logger     *zap.Logger
+	provider   Provider
+	identities []config.ServiceIdentity
+}
+
+// NewVerifier creates a Verifier. It returns nil if proxy auth is disabled.
+// ldapTLS is used to connect to the LDAP server if TLS is enabled.
+func NewVerifier(logger *zap.Logger, cfg config.ProxyAuth, ldapTLS *tls.Config) (*Verifier, error) {
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+	var provider Provider
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case config.ProxyAuthHtpasswd:
+		hp, err := newHtpasswdProvider(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		provider = hp
+	case config.ProxyAuthLDAP:
+		if !cfg.LDAP.TLS {
+			ldapTLS = nil
+		} else if ldapTLS == nil {
+			return nil, errors.Wrapf(config.ErrInvalidProxyAuth, "ldap requires cluster-tls to enable TLS")
+		}
+		provider = newLDAPProvider(cfg.LDAP, ldapTLS)
+	case config.ProxyAuthJWT:
+		jp, err := newJWTProvider(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		provider = jp
+	}
+	return &Verifier{
+		logger:     logger,
+		provider:   provider,
+		identities: cfg.Identities,
+	}, nil
+}
+
+// Verify checks the password of the client and returns the service identity to log into TiDB.
+func (v *Verifier) Verify(user, password string) (backendUser, backendPassword string, err error) {
+	identity, ok := v.mapIdentity(user)
+	if !ok {
+		return "", "", errors.Wrapf(ErrNoIdentity, "user %s", user)
+	}
+	if err = v.provider.Authenticate(user, password); err != nil {
+		v.logger.Info("proxy auth failed", zap.String("user", user), zap.Error(err))
+		return "", "", err
+	}
+	return identity.User, identity.Password, nil
+}
+
+// mapIdentity returns the identity that lists the user, or the first one that lists no users.
+func (v *Verifier) mapIdentity(user string) (config.ServiceIdentity, bool) {
+	var defaultIdentity *config.ServiceIdentity
+	for i := range v.identities {
+		identity := &v.identities[i]
+		if len(identity.Users) == 0 {
+			if defaultIdentity == nil {
+				defaultIdentity = identity
+			}
+			continue
+		}
+		for _, u := range identity.Users {
+			if u == user {
+				return *identity, true
+			}
+		}
+	}
+	if defaultIdentity != nil {
+		return *defaultIdentity, true
+	}
+	return config.ServiceIdentity{}, false
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap/TiProxy/lib/config"
+	"github.com/pingcap/TiProxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	v, err := NewVerifier(lg, config.ProxyAuth{}, nil)
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	content := "user1:$2b$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW\n" +
+		"user2:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5\n"
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	cfg := config.ProxyAuth{
+		Provider:     config.ProxyAuthHtpasswd,
+		HtpasswdFile: file,
+		Identities: []config.ServiceIdentity{
+			{Users: []string{"user1"}, User: "svc1", Password: "pwd1"},
+		},
+	}
+	v, err = NewVerifier(lg, cfg, nil)
+	require.NoError(t, err)
+	user, password, err := v.Verify("user1", "secret")
+	require.NoError(t, err)
+	require.Equal(t, "svc1", user)
+	require.Equal(t, "pwd1", password)
+	_, _, err = v.Verify("user1", "Hello world!")
+	require.ErrorIs(t, err, ErrAuthFailed)
+	// No identity is mapped to user2.
+	_, _, err = v.Verify("user2", "Hello world!")
+	require.ErrorIs(t, err, ErrNoIdentity)
+
+	// The identity without users is the default one.
+	cfg.Identities = append(cfg.Identities, config.ServiceIdentity{User: "svc", Password: "pwd"})
+	v, err = NewVerifier(lg, cfg, nil)
+	require.NoError(t, err)
+	user, password, err = v.Verify("user2", "Hello world!")
+	require.NoError(t, err)
+	require.Equal(t, "svc", user)
+	require.Equal(t, "pwd", password)
+
+	// LDAP requires the TLS config if TLS is enabled.
+	cfg = config.ProxyAuth{
+		Provider:   config.ProxyAuthLDAP,
+		LDAP:       config.LDAPAuth{Addr: "127.0.0.1:389", BindDN: "uid=%s", TLS: true},
+		Identities: cfg.Identities,
+	}
+	_, err = NewVerifier(lg, cfg, nil)
+	require.ErrorIs(t, err, config.ErrInvalidProxyAuth)
+	cfg.Provider = "unknown"
+	_, err = NewVerifier(lg, cfg, nil)
+	require.ErrorIs(t, err, config.ErrInvalidProxyAuth)
+}
@@ -4,14 +4,16 @@
 package backend
 
 import (
-	"bytes"
+	"crypto/rsa"
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"net"
 	"time"
 
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/pkg/manager/namespace"
 	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
 	"github.com/pingcap/TiProxy/pkg/proxy/proxyprotocol"
 	"github.com/pingcap/tidb/parser/mysql"
@@ -22,6 +24,7 @@ import (
 var (
 	ErrCapabilityNegotiation = errors.New("capability negotiation failed")
 	ErrTLSConfigRequired     = errors.New("require TLS config on TiProxy when require-backend-tls=true")
+	ErrNoRSAKey              = errors.New("no RSA key to exchange the password without TLS")
 )
 
 const unknownAuthPlugin = "auth_unknown_plugin"
@@ -38,14 +41,26 @@ const SupportedServerCapabilities = pnet.ClientLongPassword | pnet.ClientFoundRo
 
 // Authenticator handshakes with the client and the backend.
 type Authenticator struct {
-	dbname            string // default database name
-	user              string
+	dbname string // default database name
+	user   string
+	// backendConnID is the connection ID of the current backend connection, which is used to kill statements.
+	backendConnID     uint64
 	attrs             map[string]string
 	salt              []byte
 	capability        pnet.Capability
 	collation         uint8
 	proxyProtocol     bool
 	requireBackendTLS bool
+	// pooled is the pre-established connection that the next handshake uses. It's consumed by the handshake.
+	pooled *pooledConn
+	// proxyAuth is true if the client is verified by TiProxy and user is the service identity.
+	proxyAuth bool
+	// authPlugin is the auth plugin advertised to the client.
+	authPlugin string
+	// rsaKey is used by the client without TLS to encrypt the password of caching_sha2_password.
+	rsaKey *rsa.PrivateKey
+	// accessFilter is the global access list that is checked after reading the proxy header. It may be nil.
+	accessFilter *namespace.AccessFilter
 }
 
 func (auth *Authenticator) String() string {
@@ -99,13 +114,22 @@ func (auth *Authenticator) handshakeFirstTime(logger *zap.Logger, cctx ConnConte
 		proxyCapability ^= pnet.ClientSSL
 	}
 
-	if err := clientIO.WriteInitialHandshake(proxyCapability, auth.salt, mysql.AuthNativePassword, handshakeHandler.GetServerVersion()); err != nil {
+	authPlugin := auth.authPlugin
+	if len(authPlugin) == 0 {
+		authPlugin = mysql.AuthNativePassword
+	}
+	if err := clientIO.WriteInitialHandshake(proxyCapability, auth.salt, authPlugin, handshakeHandler.GetServerVersion()); err != nil {
 		return err
 	}
 	pkt, isSSL, err := clientIO.ReadSSLRequestOrHandshakeResp()
 	if err != nil {
 		return err
 	}
+	// The client address is known after reading the proxy header. Reject the client before the TLS handshake.
+	if err = auth.accessFilter.Check(clientIO.RemoteAddr().String()); err != nil {
+		logger.Info("reject the client address", zap.Error(err))
+		return writeHostNotPrivileged(clientIO)
+	}
 	frontendCapability := pnet.Capability(binary.LittleEndian.Uint32(pkt))
 	if isSSL {
 		if _, err = clientIO.ServerTLSHandshake(frontendTLSConfig); err != nil {
@@ -159,21 +183,41 @@ func (auth *Authenticator) handshakeFirstTime(logger *zap.Logger, cctx ConnConte
 	auth.dbname = clientResp.DB
 	auth.collation = clientResp.Collation
 	auth.attrs = clientResp.Attrs
+	// Verify the client before connecting to the backend. The client user is still used for routing.
+	var backendPassword string
+	if verifier := handshakeHandler.GetCredentialVerifier(); verifier != nil {
+		if auth.user, backendPassword, err = auth.verifyClient(clientIO, clientResp, verifier, isSSL); err != nil {
+			return err
+		}
+		auth.proxyAuth = true
+	}
 
 	// In case of testing, backendIO is passed manually that we don't want to bother with the routing logic.
 	backendIO, err := getBackendIO(cctx, auth, clientResp, 15*time.Second)
 	if err != nil {
+		switch {
+		case errors.Is(err, ErrClientCertRejected):
+			logger.Info("reject the client certificate", zap.Error(err))
+			return writeAccessDenied(clientIO, clientResp.User)
+		case errors.Is(err, ErrClientAddrRejected):
+			logger.Info("reject the client address", zap.Error(err))
+			return writeHostNotPrivileged(clientIO)
+		}
 		return pnet.WrapUserError(err, connectErrMsg)
 	}
-	backendIO.ResetSequence()
-
-	// write proxy header
-	if err := auth.writeProxyProtocol(clientIO, backendIO); err != nil {
-		return pnet.WrapUserError(err, handshakeErrMsg)
+	// The namespace may use different certs to connect to its backends.
+	backendTLSConfig = getBackendTLS(cctx, backendTLSConfig)
+	pooled := auth.takePooled(backendIO)
+	if pooled == nil {
+		backendIO.ResetSequence()
+		// write proxy header
+		if err := auth.writeProxyProtocol(clientIO, backendIO); err != nil {
+			return pnet.WrapUserError(err, handshakeErrMsg)
+		}
 	}
 
 	// read backend initial handshake
-	serverPkt, backendCapability, err := auth.readInitialHandshake(backendIO)
+	serverPkt, backendCapability, err := auth.readInitialHandshake(backendIO, pooled)
 	if err != nil {
 		if IsMySQLError(err) {
 			if writeErr := clientIO.WritePacket(serverPkt, true); writeErr != nil {
@@ -200,9 +244,13 @@ func (auth *Authenticator) handshakeFirstTime(logger *zap.Logger, cctx ConnConte
 		logger.Debug("backend does not support capabilities from proxy", zap.Stringer("common", common), zap.Stringer("proxy", proxyCapability^common), zap.Stringer("backend", backendCapability^common))
 	}
 
+	if auth.proxyAuth {
+		return auth.loginBackend(clientIO, backendIO, backendTLSConfig, backendCapability, pooled != nil && pooled.tls, serverPkt, backendPassword)
+	}
+
 	// forward client handshake resp
 	if err := auth.writeAuthHandshake(
-		backendIO, backendTLSConfig, backendCapability,
+		backendIO, backendTLSConfig, backendCapability, pooled != nil && pooled.tls,
 		// Send an unknown auth plugin so that the backend will request the auth data again.
 		// Copy the auth data so that the backend can set correct `using password` in the error message.
 		unknownAuthPlugin, clientResp.AuthData, 0,
@@ -211,8 +259,7 @@ func (auth *Authenticator) handshakeFirstTime(logger *zap.Logger, cctx ConnConte
 	}
 
 	// forward other packets
-	pluginName := ""
-loop:
+	pluginName, salt := "", auth.salt
 	for {
 		serverPkt, err := forwardMsg(backendIO, clientIO)
 		if err != nil {
@@ -223,17 +270,25 @@ loop:
 			return nil
 		case mysql.ErrHeader:
 			return pnet.ParseErrorPacket(serverPkt)
-		default: // mysql.AuthSwitchRequest, ShaCommand
-			if serverPkt[0] == mysql.AuthSwitchRequest {
-				pluginName = string(serverPkt[1 : bytes.IndexByte(serverPkt[1:], 0)+1])
-			} else if serverPkt[0] == 1 && pluginName == mysql.AuthCachingSha2Password && len(serverPkt) == 2 && serverPkt[1] == 3 {
-				// caching_sha2_password fast path
-				continue loop
-			}
-			if _, err = forwardMsg(clientIO, backendIO); err != nil {
-				return err
+		case mysql.AuthSwitchRequest:
+			pluginName, salt = pnet.ParseAuthSwitchRequest(serverPkt)
+		case pnet.ShaCommand:
+			if pluginName == mysql.AuthCachingSha2Password && len(serverPkt) == 2 {
+				switch serverPkt[1] {
+				case pnet.FastAuthSuccess:
+					// An OK packet follows.
+					continue
+				case pnet.FastAuthFail:
+					if err = auth.forwardSHA2Password(clientIO, backendIO, salt); err != nil {
+						return err
+					}
+					continue
+				}
 			}
 		}
+		if _, err = forwardMsg(clientIO, backendIO); err != nil {
+			return err
+		}
 	}
 }
 
@@ -246,17 +301,93 @@ func forwardMsg(srcIO, destIO *pnet.PacketIO) (data []byte, err error) {
 	return
 }
 
+// forwardSHA2Password forwards the password during caching_sha2_password full authentication.
+// The client sends the cleartext password over TLS, or otherwise encrypts it with the RSA public key of the server.
+// TiProxy may use TLS on only one side, so it reads the password from the client and then sends it to the backend
+// in the way that the backend connection requires.
+func (auth *Authenticator) forwardSHA2Password(clientIO, backendIO *pnet.PacketIO, salt []byte) error {
+	password, err := auth.readSHA2Password(clientIO, salt)
+	if err != nil {
+		return err
+	}
+	return writeSHA2Password(clientIO, backendIO, salt, password)
+}
+
+// readSHA2Password reads the cleartext password from the client. The client without TLS may request the public key first.
+func (auth *Authenticator) readSHA2Password(clientIO *pnet.PacketIO, salt []byte) ([]byte, error) {
+	pkt, err := clientIO.ReadPacket()
+	if err != nil || clientIO.TLSConnectionState().HandshakeComplete {
+		return pkt, err
+	}
+	if auth.rsaKey == nil {
+		return nil, pnet.WrapUserError(ErrNoRSAKey, ErrNoRSAKey.Error())
+	}
+	if len(pkt) == 1 && pkt[0] == pnet.RequestPublicKey {
+		pubKey, err := pnet.MarshalPublicKey(&auth.rsaKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if err = clientIO.WritePacket(append([]byte{pnet.ShaCommand}, pubKey...), true); err != nil {
+			return nil, err
+		}
+		if pkt, err = clientIO.ReadPacket(); err != nil {
+			return nil, err
+		}
+	}
+	password, err := pnet.DecryptPassword(pkt, salt, auth.rsaKey)
+	if err != nil {
+		return nil, pnet.WrapUserError(err, handshakeErrMsg)
+	}
+	return password, nil
+}
+
+// writeSHA2Password sends the cleartext password to the backend over TLS, or otherwise encrypts it with the public key
+// of the backend.
+func writeSHA2Password(clientIO, backendIO *pnet.PacketIO, salt, password []byte) error {
+	if backendIO.TLSConnectionState().HandshakeComplete {
+		return backendIO.WritePacket(password, true)
+	}
+	if err := backendIO.WritePacket([]byte{pnet.RequestPublicKey}, true); err != nil {
+		return err
+	}
+	pkt, err := backendIO.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if pkt[0] == mysql.ErrHeader {
+		if err = clientIO.WritePacket(pkt, true); err != nil {
+			return err
+		}
+		return pnet.ParseErrorPacket(pkt)
+	}
+	if pkt[0] != pnet.ShaCommand {
+		return errors.Errorf("read unexpected command: %#x", pkt[0])
+	}
+	pubKey, err := pnet.ParsePublicKey(pkt[1:])
+	if err != nil {
+		return pnet.WrapUserError(err, handshakeErrMsg)
+	}
+	data, err := pnet.EncryptPassword(password, salt, pubKey)
+	if err != nil {
+		return pnet.WrapUserError(err, handshakeErrMsg)
+	}
+	return backendIO.WritePacket(data, true)
+}
+
 func (auth *Authenticator) handshakeSecondTime(logger *zap.Logger, clientIO, backendIO *pnet.PacketIO, backendTLSConfig *tls.Config, sessionToken string) error {
 	if len(sessionToken) == 0 {
 		return errors.New("session token is empty")
 	}
 
-	// write proxy header
-	if err := auth.writeProxyProtocol(clientIO, backendIO); err != nil {
-		return err
+	pooled := auth.takePooled(backendIO)
+	if pooled == nil {
+		// write proxy header
+		if err := auth.writeProxyProtocol(clientIO, backendIO); err != nil {
+			return err
+		}
 	}
 
-	_, backendCapability, err := auth.readInitialHandshake(backendIO)
+	_, backendCapability, err := auth.readInitialHandshake(backendIO, pooled)
 	if err != nil {
 		return err
 	}
@@ -266,7 +397,7 @@ func (auth *Authenticator) handshakeSecondTime(logger *zap.Logger, clientIO, bac
 	}
 
 	if err = auth.writeAuthHandshake(
-		backendIO, backendTLSConfig, backendCapability,
+		backendIO, backendTLSConfig, backendCapability, pooled != nil && pooled.tls,
 		pnet.AuthTiDBSessionToken, hack.Slice(sessionToken), pnet.ClientPluginAuth,
 	); err != nil {
 		return err
@@ -275,8 +406,55 @@ func (auth *Authenticator) handshakeSecondTime(logger *zap.Logger, clientIO, bac
 	return auth.handleSecondAuthResult(backendIO)
 }
 
-func (auth *Authenticator) readInitialHandshake(backendIO *pnet.PacketIO) (serverPkt []byte, capability pnet.Capability, err error) {
-	if serverPkt, err = backendIO.ReadPacket(); err != nil {
+// handshakeChangeUser logs into a backend of another namespace as the new user of COM_CHANGE_USER.
+// No auth data is sent so that the backend requests it, and the caller forwards the auth switch to the client.
+func (auth *Authenticator) handshakeChangeUser(logger *zap.Logger, clientIO, backendIO *pnet.PacketIO, backendTLSConfig *tls.Config) error {
+	pooled := auth.takePooled(backendIO)
+	if pooled == nil {
+		if err := auth.writeProxyProtocol(clientIO, backendIO); err != nil {
+			return pnet.WrapUserError(err, handshakeErrMsg)
+		}
+	}
+
+	serverPkt, backendCapability, err := auth.readInitialHandshake(backendIO, pooled)
+	if err != nil {
+		if IsMySQLError(err) {
+			if writeErr := clientIO.WritePacket(serverPkt, true); writeErr != nil {
+				err = writeErr
+			}
+			return err
+		}
+		return pnet.WrapUserError(err, handshakeErrMsg)
+	}
+
+	if err = auth.verifyBackendCaps(logger, backendCapability); err != nil {
+		return pnet.WrapUserError(err, capabilityErrMsg)
+	}
+
+	if err = auth.writeAuthHandshake(
+		backendIO, backendTLSConfig, backendCapability, pooled != nil && pooled.tls,
+		unknownAuthPlugin, nil, 0,
+	); err != nil {
+		return pnet.WrapUserError(err, handshakeErrMsg)
+	}
+	return nil
+}
+
+// takePooled returns the pooled connection if the backendIO is from the pool.
+func (auth *Authenticator) takePooled(backendIO *pnet.PacketIO) *pooledConn {
+	pooled := auth.pooled
+	auth.pooled = nil
+	if pooled == nil || pooled.backendIO != backendIO {
+		return nil
+	}
+	return pooled
+}
+
+// readInitialHandshake reads the initial handshake of the backend. The pooled connection has already read it.
+func (auth *Authenticator) readInitialHandshake(backendIO *pnet.PacketIO, pooled *pooledConn) (serverPkt []byte, capability pnet.Capability, err error) {
+	if pooled != nil {
+		serverPkt = pooled.serverPkt
+	} else if serverPkt, err = backendIO.ReadPacket(); err != nil {
 		return
 	}
 	if pnet.IsErrorPacket(serverPkt) {
@@ -284,6 +462,7 @@ func (auth *Authenticator) readInitialHandshake(backendIO *pnet.PacketIO) (serve
 		return
 	}
 	capability, _ = pnet.ParseInitialHandshake(serverPkt)
+	auth.backendConnID = pnet.ParseConnectionID(serverPkt)
 	return
 }
 
@@ -291,6 +470,7 @@ func (auth *Authenticator) writeAuthHandshake(
 	backendIO *pnet.PacketIO,
 	backendTLSConfig *tls.Config,
 	backendCapability pnet.Capability,
+	tlsUpgraded bool,
 	authPlugin string,
 	authData []byte,
 	authCap pnet.Capability,
@@ -324,21 +504,16 @@ func (auth *Authenticator) writeAuthHandshake(
 	if enableTLS {
 		resp.Capability |= pnet.ClientSSL
 		pkt = pnet.MakeHandshakeResponse(resp)
-		// write SSL Packet
-		if err := backendIO.WritePacket(pkt[:32], true); err != nil {
-			return err
-		}
-		// Send TLS / SSL request packet. The server must have supported TLS.
-		tcfg := backendTLSConfig.Clone()
-		addr := backendIO.RemoteAddr().String()
-		host, _, err := net.SplitHostPort(addr)
-		if err == nil {
-			tcfg.ServerName = host
-		}
-		if err := backendIO.ClientTLSHandshake(tcfg); err != nil {
-			return err
+		// The pooled connection has sent the SSL request and upgraded to TLS.
+		if !tlsUpgraded {
+			if err := upgradeBackendTLS(backendIO, pkt[:32], backendTLSConfig); err != nil {
+				return err
+			}
 		}
 	} else {
+		if tlsUpgraded {
+			return errors.New("the pooled backend connection has upgraded to TLS but the session disables TLS")
+		}
 		resp.Capability &= ^pnet.ClientSSL
 		pkt = pnet.MakeHandshakeResponse(resp)
 	}
@@ -347,6 +522,22 @@ func (auth *Authenticator) writeAuthHandshake(
 	return backendIO.WritePacket(pkt, true)
 }
 
+// upgradeBackendTLS writes the SSL request packet and upgrades the backend connection to TLS.
+func upgradeBackendTLS(backendIO *pnet.PacketIO, sslRequest []byte, backendTLSConfig *tls.Config) error {
+	// write SSL Packet
+	if err := backendIO.WritePacket(sslRequest, true); err != nil {
+		return err
+	}
+	// Send TLS / SSL request packet. The server must have supported TLS.
+	tcfg := backendTLSConfig.Clone()
+	addr := backendIO.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil {
+		tcfg.ServerName = host
+	}
+	return backendIO.ClientTLSHandshake(tcfg)
+}
+
 func (auth *Authenticator) handleSecondAuthResult(backendIO *pnet.PacketIO) error {
 	data, err := backendIO.ReadPacket()
 	if err != nil {
@@ -364,10 +555,13 @@ func (auth *Authenticator) handleSecondAuthResult(backendIO *pnet.PacketIO) erro
 }
 
 // changeUser is called once the client sends COM_CHANGE_USER.
-func (auth *Authenticator) changeUser(username, db string) {
+// The attributes are kept if the client doesn't send new ones.
+func (auth *Authenticator) changeUser(username, db string, attrs map[string]string) {
 	auth.user = username
 	auth.dbname = db
-	// TODO: attrs
+	if attrs != nil {
+		auth.attrs = attrs
+	}
 }
 
 // updateCurrentDB is called once the client sends COM_INIT_DB or `use db`.
@@ -375,3 +569,29 @@ func (auth *Authenticator) changeUser(username, db string) {
 func (auth *Authenticator) updateCurrentDB(db string) {
 	auth.dbname = db
 }
+
+// writeAccessDenied sends the access denied error to the client and returns it.
+func writeAccessDenied(clientIO *pnet.PacketIO, user string) error {
+	host := clientHost(clientIO)
+	if err := clientIO.WriteErrPacket(mysql.ErrAccessDenied, user, host, "YES"); err != nil {
+		return err
+	}
+	return gomysql.NewDefaultError(mysql.ErrAccessDenied, user, host, "YES")
+}
+
+// writeHostNotPrivileged sends the host not privileged error to the client and returns it.
+func writeHostNotPrivileged(clientIO *pnet.PacketIO) error {
+	host := clientHost(clientIO)
+	if err := clientIO.WriteErrPacket(mysql.ErrHostNotPrivileged, host); err != nil {
+		return err
+	}
+	return gomysql.NewDefaultError(mysql.ErrHostNotPrivileged, host)
+}
+
+func clientHost(clientIO *pnet.PacketIO) string {
+	host, _, err := net.SplitHostPort(clientIO.RemoteAddr().String())
+	if err != nil {
+		return clientIO.RemoteAddr().String()
+	}
+	return host
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/pingcap/TiProxy/lib/util/errors"
 	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
 	"github.com/pingcap/tidb/parser/mysql"
 	"github.com/stretchr/testify/require"
@@ -290,3 +291,181 @@ func TestAuthFail(t *testing.T) {
 		clean()
 	}
 }
+
+// mockVerifier accepts mockUsername with the password mockAuthData.
+type mockVerifier struct{}
+
+func (v *mockVerifier) Verify(user, password string) (string, string, error) {
+	if user != mockUsername || password != string(mockAuthData) {
+		return "", "", errors.New("wrong password")
+	}
+	return "svc_user", "svc_password", nil
+}
+
+func TestProxyAuth(t *testing.T) {
+	nativeData, err := pnet.ScramblePassword(pnet.AuthNativePassword, mockSalt, "svc_password")
+	require.NoError(t, err)
+	tests := []struct {
+		cfg   cfgOverrider
+		check checker
+	}{
+		{
+			// caching_sha2_password full authentication over TLS
+			cfg: func(cfg *testConfig) {},
+			check: func(t *testing.T, ts *testSuite) {
+				require.NoError(t, ts.mp.err)
+				require.True(t, ts.mc.authSucceed)
+				require.True(t, ts.mp.authenticator.proxyAuth)
+				require.Equal(t, "svc_user", ts.mp.authenticator.user)
+				require.Equal(t, "svc_user", ts.mb.username)
+				require.Equal(t, []byte("svc_password\x00"), ts.mb.authData)
+			},
+		},
+		{
+			cfg: func(cfg *testConfig) {
+				cfg.backendConfig.authPlugin = pnet.AuthNativePassword
+			},
+			check: func(t *testing.T, ts *testSuite) {
+				require.NoError(t, ts.mp.err)
+				require.True(t, ts.mc.authSucceed)
+				require.Equal(t, "svc_user", ts.mb.username)
+				require.Equal(t, nativeData, ts.mb.authData)
+			},
+		},
+		{
+			// the client sends the cleartext password in the handshake response
+			cfg: func(cfg *testConfig) {
+				cfg.clientConfig.authPlugin = pnet.AuthMySQLClearPassword
+				cfg.clientConfig.authData = append(append([]byte{}, mockAuthData...), 0)
+			},
+			check: func(t *testing.T, ts *testSuite) {
+				require.NoError(t, ts.mp.err)
+				require.True(t, ts.mc.authSucceed)
+				require.Equal(t, "svc_user", ts.mb.username)
+			},
+		},
+		{
+			// the backend is not connected if the password is wrong
+			cfg: func(cfg *testConfig) {
+				cfg.clientConfig.authData = []byte("wrong")
+			},
+			check: func(t *testing.T, ts *testSuite) {
+				require.True(t, IsMySQLError(ts.mp.err))
+				require.False(t, ts.mc.authSucceed)
+				require.ErrorContains(t, ts.mc.mysqlErr, "Access denied")
+				require.Empty(t, ts.mb.username)
+			},
+		},
+		{
+			// the cleartext password is not sent without TLS
+			cfg: func(cfg *testConfig) {
+				cfg.clientConfig.capability &^= pnet.ClientSSL
+			},
+			check: func(t *testing.T, ts *testSuite) {
+				require.ErrorIs(t, ts.mp.err, ErrProxyAuthTLS)
+				require.Empty(t, ts.mb.username)
+			},
+		},
+		{
+			cfg: func(cfg *testConfig) {
+				cfg.backendConfig.capability &^= pnet.ClientSSL
+			},
+			check: func(t *testing.T, ts *testSuite) {
+				require.ErrorIs(t, ts.mp.err, ErrProxyAuthTLS)
+			},
+		},
+		{
+			// the service identity fails to log in
+			cfg: func(cfg *testConfig) {
+				cfg.backendConfig.authPlugin = pnet.AuthNativePassword
+				cfg.backendConfig.authSucceed = false
+			},
+			check: func(t *testing.T, ts *testSuite) {
+				require.True(t, IsMySQLError(ts.mp.err))
+				require.False(t, ts.mc.authSucceed)
+				require.NotNil(t, ts.mc.mysqlErr)
+			},
+		},
+	}
+	tc := newTCPConnSuite(t)
+	for _, test := range tests {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.proxyConfig.handler.verifier = &mockVerifier{}
+		}, test.cfg)
+		ts.authenticateFirstTime(t, test.check)
+		clean()
+	}
+}
+
+func TestCachingSha2Password(t *testing.T) {
+	tests := []struct {
+		cfg               cfgOverrider
+		requireBackendTLS bool
+		clientTLS         bool
+		backendTLS        bool
+	}{
+		{
+			cfg:        func(cfg *testConfig) {},
+			clientTLS:  true,
+			backendTLS: true,
+		},
+		{
+			// the client sends the cleartext password and the proxy encrypts it for the backend
+			cfg: func(cfg *testConfig) {
+				cfg.backendConfig.capability &^= pnet.ClientSSL
+			},
+			clientTLS: true,
+		},
+		{
+			// the client requests the public key of the proxy and the proxy sends the cleartext password to the backend
+			cfg: func(cfg *testConfig) {
+				cfg.clientConfig.capability &^= pnet.ClientSSL
+			},
+			requireBackendTLS: true,
+			backendTLS:        true,
+		},
+		{
+			// both the proxy and the backend exchange the public keys
+			cfg: func(cfg *testConfig) {
+				cfg.clientConfig.capability &^= pnet.ClientSSL
+			},
+		},
+		{
+			cfg: func(cfg *testConfig) {
+				cfg.clientConfig.capability &^= pnet.ClientSSL
+				cfg.backendConfig.fastAuth = true
+			},
+		},
+	}
+	tc := newTCPConnSuite(t)
+	for i, test := range tests {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.proxyConfig.authPlugin = pnet.AuthCachingSha2Password
+		}, test.cfg)
+		ts.mp.authenticator.requireBackendTLS = test.requireBackendTLS
+		ts.authenticateFirstTime(t, func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mc.err, i)
+			require.NoError(t, ts.mp.err, i)
+			require.NoError(t, ts.mb.err, i)
+			require.True(t, ts.mc.authSucceed, i)
+			require.Equal(t, pnet.AuthCachingSha2Password, ts.mc.serverAuthPlugin, i)
+			require.Equal(t, test.clientTLS, ts.tc.clientIO.TLSConnectionState().HandshakeComplete, i)
+			require.Equal(t, test.backendTLS, ts.tc.backendIO.TLSConnectionState().HandshakeComplete, i)
+			if !ts.mb.fastAuth {
+				require.Equal(t, ts.mc.authData, ts.mb.authData, i)
+			}
+		})
+		clean()
+	}
+
+	// The client without TLS can't log in if the proxy has no RSA key.
+	ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+		cfg.clientConfig.capability &^= pnet.ClientSSL
+		cfg.proxyConfig.rsaKey = nil
+	})
+	ts.authenticateFirstTime(t, func(t *testing.T, ts *testSuite) {
+		require.ErrorIs(t, ts.mp.err, ErrNoRSAKey)
+		require.Equal(t, pnet.AuthNativePassword, ts.mc.serverAuthPlugin)
+	})
+	clean()
+}
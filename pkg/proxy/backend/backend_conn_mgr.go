@@ -5,6 +5,7 @@ package backend
 
 import (
 	"context"
+	"crypto/rsa"
 	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
@@ -22,7 +23,9 @@ import (
 	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/errors"
 	"github.com/pingcap/TiProxy/lib/util/waitgroup"
+	"github.com/pingcap/TiProxy/pkg/manager/namespace"
 	"github.com/pingcap/TiProxy/pkg/manager/router"
+	"github.com/pingcap/TiProxy/pkg/metrics"
 	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
 	"github.com/pingcap/tidb/parser/mysql"
 	"github.com/siddontang/go/hack"
@@ -51,6 +54,7 @@ type signalType int
 const (
 	signalTypeRedirect signalType = iota
 	signalTypeGracefulClose
+	signalTypeEvacuateTimeout
 	signalTypeNums
 )
 
@@ -62,6 +66,11 @@ type redirectResult struct {
 	err  error
 	from string
 	to   string
+	// receiver is the router that the connection belongs to when the result is produced.
+	receiver router.ConnEventReceiver
+	// leave means the connection leaves the router because it's routed to another namespace.
+	// If to is also set, the pending redirection to it fails.
+	leave bool
 }
 
 const (
@@ -71,12 +80,31 @@ const (
 	statusClosed
 )
 
+const (
+	adminCloseNone int32 = iota
+	adminCloseGraceful
+	adminCloseForce
+)
+
 type BCConfig struct {
 	ProxyProtocol        bool
 	RequireBackendTLS    bool
 	CheckBackendInterval time.Duration
 	HealthyKeepAlive     config.KeepAlive
 	UnhealthyKeepAlive   config.KeepAlive
+	// EvacuateTxnTimeout is the time to wait for the transaction after the backend is drained or removed.
+	EvacuateTxnTimeout time.Duration
+	// IdleDetachTimeout is the idle time before the backend connection is released. 0 means never detaching.
+	IdleDetachTimeout time.Duration
+	// BackendPool provides pre-established backend connections. It's shared by all the sessions and may be nil.
+	BackendPool *BackendPool
+	// DefaultAuthPlugin is the auth plugin advertised to the clients. Empty means mysql_native_password.
+	DefaultAuthPlugin string
+	// AuthRSAKey is used by the clients without TLS to encrypt the passwords of caching_sha2_password. It may be nil.
+	AuthRSAKey *rsa.PrivateKey
+	// AccessFilter is the global access list, which is checked in the handshake when the client address is only
+	// known from the proxy header. It may be nil.
+	AccessFilter *namespace.AccessFilter
 }
 
 func (cfg *BCConfig) check() {
@@ -107,8 +135,13 @@ type BackendConnManager struct {
 	logger         *zap.Logger
 	// It will be set to nil after migration.
 	redirectInfo atomic.Pointer[signalRedirect]
-	// redirectResCh is used to notify the event receiver asynchronously.
-	redirectResCh      chan *redirectResult
+	// redirectResCh wakes up the signal processing goroutine to notify the event receivers asynchronously.
+	redirectResCh chan struct{}
+	// redirectResults are the redirection results that are not notified yet, which are protected by resultLock.
+	// notifyLock keeps the results notified in order, no matter whether they're notified asynchronously or not.
+	resultLock         sync.Mutex
+	redirectResults    []*redirectResult
+	notifyLock         sync.Mutex
 	closeStatus        atomic.Int32
 	checkBackendTicker *time.Ticker
 	// cancelFunc is used to cancel the signal processing goroutine.
@@ -121,6 +154,30 @@ type BackendConnManager struct {
 	ctxmap           sync.Map
 	connectionID     uint64
 	quitSource       ErrorSource
+	// adminClose is set when the connection is closed through the admin API.
+	adminClose atomic.Int32
+	// killToken is the session token to connect to the backend to kill the statement that exceeds the SQL timeout.
+	// It's also used to restore the detached session.
+	killToken       string
+	killTokenTicker *time.Ticker
+	// The fields below are used to show the connection in the API.
+	connectTime time.Time
+	// lastCmdTime is the unix nanoseconds when the last command is received.
+	lastCmdTime atomic.Int64
+	// sessionInfo is updated by the goroutine that holds processLock and read by others.
+	sessionInfo   atomic.Pointer[sessionInfo]
+	redirectPhase string
+	// evacuateLock protects the evacuation deadline, which is set by the router.
+	evacuateLock     sync.Mutex
+	evacuateDeadline time.Time
+	evacuateTimer    *time.Timer
+	// detachedAddr is the backend address of the detached session, and it's nil if the session holds a backend connection.
+	// detachedStates are the session states that will be restored on the next backend connection.
+	detachedAddr   atomic.Pointer[string]
+	detachedStates string
+	detachTicker   *time.Ticker
+	// proxyBackendTLS is the TLS config of TiProxy to connect to the backends, which the namespace may override.
+	proxyBackendTLS *tls.Config
 }
 
 // NewBackendConnManager creates a BackendConnManager.
@@ -136,11 +193,16 @@ func NewBackendConnManager(logger *zap.Logger, handshakeHandler HandshakeHandler
 			proxyProtocol:     config.ProxyProtocol,
 			requireBackendTLS: config.RequireBackendTLS,
 			salt:              GenerateSalt(20),
+			authPlugin:        config.DefaultAuthPlugin,
+			rsaKey:            config.AuthRSAKey,
+			accessFilter:      config.AccessFilter,
 		},
-		// There are 2 types of signals, which may be sent concurrently.
+		// There are 3 types of signals, which may be sent concurrently.
 		signalReceived: make(chan signalType, signalTypeNums),
-		redirectResCh:  make(chan *redirectResult, 1),
+		redirectResCh:  make(chan struct{}, 1),
 		quitSource:     SrcClientQuit,
+		connectTime:    time.Now(),
+		redirectPhase:  RedirectPhaseNone,
 	}
 	return mgr
 }
@@ -156,7 +218,7 @@ func (mgr *BackendConnManager) Connect(ctx context.Context, clientIO *pnet.Packe
 	mgr.processLock.Lock()
 	defer mgr.processLock.Unlock()
 
-	mgr.backendTLS = backendTLSConfig
+	mgr.backendTLS, mgr.proxyBackendTLS = backendTLSConfig, backendTLSConfig
 
 	mgr.clientIO = clientIO
 	err := mgr.authenticator.handshakeFirstTime(mgr.logger.Named("authenticator"), mgr, clientIO, mgr.handshakeHandler, mgr.getBackendIO, frontendTLSConfig, backendTLSConfig)
@@ -170,9 +232,15 @@ func (mgr *BackendConnManager) Connect(ctx context.Context, clientIO *pnet.Packe
 	mgr.handshakeHandler.OnHandshake(mgr, mgr.ServerAddr(), nil)
 
 	mgr.cmdProcessor.capability = mgr.authenticator.capability
+	mgr.initKillToken(mgr.backendIO.Load())
+	mgr.updateSessionInfo()
 	childCtx, cancelFunc := context.WithCancel(ctx)
 	mgr.cancelFunc = cancelFunc
 	mgr.resetCheckBackendTicker()
+	mgr.killTokenTicker = time.NewTicker(killTokenRefreshInterval)
+	if mgr.config.IdleDetachTimeout > 0 {
+		mgr.detachTicker = time.NewTicker(mgr.config.IdleDetachTimeout)
+	}
 	mgr.wg.Run(func() {
 		mgr.processSignals(childCtx)
 	})
@@ -184,23 +252,45 @@ func (mgr *BackendConnManager) getBackendIO(cctx ConnContext, auth *Authenticato
 	if err != nil {
 		return nil, pnet.WrapUserError(err, err.Error())
 	}
+	return mgr.connectRouter(cctx, r, auth, timeout)
+}
+
+// connectRouter connects to a backend that the router chooses and adds the connection to the router.
+func (mgr *BackendConnManager) connectRouter(cctx ConnContext, r router.Router, auth *Authenticator, timeout time.Duration) (*pnet.PacketIO, error) {
+	// Redirecting also uses the TLS config of the namespace.
+	mgr.backendTLS = getBackendTLS(cctx, mgr.backendTLS)
 	// Reasons to wait:
 	// - The TiDB instances may not be initialized yet
 	// - One TiDB may be just shut down and another is just started but not ready yet
 	bctx, cancel := context.WithTimeout(context.Background(), timeout)
 	selector := r.GetBackendSelector()
+	selector.SetRouteHint(getRouteHint(cctx))
+	breaker := getBreaker(cctx)
 	startTime := time.Now()
 	var addr string
 	var origErr error
 	io, err := backoff.RetryNotifyWithData(
 		func() (*pnet.PacketIO, error) {
+			// Fail fast instead of waiting until timeout if the breaker is open.
+			if breaker != nil {
+				if err := breaker.Allow(); err != nil {
+					return nil, backoff.Permanent(pnet.WrapUserError(err, breakerErrMsg))
+				}
+			}
 			// Try to connect to all backup backends one by one.
+			var err error
 			addr, err = selector.Next()
 			// If all addrs are enumerated, reset and try again.
 			if err == nil && addr == "" {
 				selector.Reset()
 				addr, err = selector.Next()
 			}
+			if err != nil || addr == "" {
+				// No backend is dialed, so the result doesn't count.
+				if breaker != nil {
+					breaker.Release()
+				}
+			}
 			if err != nil {
 				return nil, backoff.Permanent(pnet.WrapUserError(err, err.Error()))
 			}
@@ -208,18 +298,17 @@ func (mgr *BackendConnManager) getBackendIO(cctx ConnContext, auth *Authenticato
 				return nil, router.ErrNoInstanceToSelect
 			}
 
-			var cn net.Conn
-			cn, err = net.DialTimeout("tcp", addr, DialTimeout)
+			var backendIO *pnet.PacketIO
+			backendIO, err = mgr.connectBackend(auth, addr)
 			selector.Finish(mgr, err == nil)
+			if breaker != nil {
+				breaker.OnResult(err == nil)
+			}
 			if err != nil {
-				return nil, errors.Wrapf(err, "dial backend %s error", addr)
+				return nil, err
 			}
 
 			mgr.logger.Info("connected to backend", zap.String("backend_addr", addr))
-			// NOTE: should use DNS name as much as possible
-			// Usually certs are signed with domain instead of IP addrs
-			// And `RemoteAddr()` will return IP addr
-			backendIO := pnet.NewPacketIO(cn, mgr.logger, pnet.WithRemoteAddr(addr, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn))
 			mgr.backendIO.Store(backendIO)
 			mgr.setKeepAlive(mgr.config.HealthyKeepAlive)
 			return backendIO, nil
@@ -249,6 +338,32 @@ func (mgr *BackendConnManager) getBackendIO(cctx ConnContext, auth *Authenticato
 	return io, err
 }
 
+// connectBackend gets a connection from the backend pool or dials a new one.
+// The pooled connection is saved in the authenticator, which skips the steps that the pool has done.
+func (mgr *BackendConnManager) connectBackend(auth *Authenticator, addr string) (*pnet.PacketIO, error) {
+	// The proxy header must be sent before the initial handshake, so the pooled connections can not be used.
+	if !mgr.config.ProxyProtocol {
+		// The pooled connection must use the same TLS config as the session.
+		tlsConfig := mgr.backendTLS
+		if !auth.requireBackendTLS && auth.capability&pnet.ClientSSL == 0 {
+			tlsConfig = nil
+		}
+		namespace, _ := mgr.Value(ConnContextKeyNamespace).(string)
+		if pooled := mgr.config.BackendPool.get(addr, namespace, tlsConfig); pooled != nil {
+			auth.pooled = pooled
+			return pooled.backendIO, nil
+		}
+	}
+	cn, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial backend %s error", addr)
+	}
+	// NOTE: should use DNS name as much as possible
+	// Usually certs are signed with domain instead of IP addrs
+	// And `RemoteAddr()` will return IP addr
+	return pnet.NewPacketIO(cn, mgr.logger, pnet.WithRemoteAddr(addr, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn)), nil
+}
+
 // ExecuteCmd forwards messages between the client and the backend.
 // If it finds that the session is ready for redirection, it migrates the session.
 func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (err error) {
@@ -262,6 +377,7 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 	}
 	cmd := pnet.Command(request[0])
 	startTime := time.Now()
+	mgr.lastCmdTime.Store(startTime.UnixNano())
 	mgr.processLock.Lock()
 	defer mgr.processLock.Unlock()
 
@@ -269,10 +385,42 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 	case statusClosing, statusClosed:
 		return
 	}
+	if mgr.evacuateTimeout() {
+		mgr.writeEvacuateErr()
+		err = ErrEvacuateTimeout
+		return
+	}
+	// The client can not change to another user because it's verified by TiProxy.
+	if cmd == pnet.ComChangeUser && mgr.authenticator.proxyAuth {
+		mgr.clientIO.WriteUserError(pnet.WrapUserError(ErrProxyAuthChangeUser, proxyAuthChangeErrMsg))
+		return
+	}
+	if err = mgr.attach(); err != nil {
+		mgr.logger.Info("restore the detached session failed", zap.Error(err))
+		err = pnet.WrapUserError(err, attachErrMsg)
+		mgr.clientIO.WriteUserError(err)
+		return
+	}
+	defer mgr.resetDetachTicker()
 	defer mgr.resetCheckBackendTicker()
+	defer mgr.updateSessionInfo()
+	if cmd == pnet.ComChangeUser {
+		var rerouted bool
+		rerouted, err = mgr.rerouteChangeUser(request)
+		if rerouted {
+			addCmdMetrics(cmd, mgr.ServerAddr(), startTime)
+		}
+		if IsMySQLError(err) {
+			mgr.logger.Debug("got a mysql error", zap.Error(err), zap.Stringer("cmd", cmd))
+			err = nil
+		}
+		if rerouted || err != nil {
+			return
+		}
+	}
 	waitingRedirect := mgr.redirectInfo.Load() != nil
 	var holdRequest bool
-	holdRequest, err = mgr.cmdProcessor.executeCmd(request, mgr.clientIO, mgr.backendIO.Load(), waitingRedirect)
+	holdRequest, err = mgr.executeCmd(request, waitingRedirect)
 	if !holdRequest {
 		addCmdMetrics(cmd, mgr.ServerAddr(), startTime)
 	}
@@ -301,9 +449,13 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 				return
 			}
 		case pnet.ComChangeUser:
-			username, db := pnet.ParseChangeUser(request)
-			mgr.authenticator.changeUser(username, db)
+			username, db, attrs := pnet.ParseChangeUser(request, mgr.authenticator.capability)
+			mgr.authenticator.changeUser(username, db, attrs)
+			// The previous token belongs to the previous user.
+			mgr.initKillToken(mgr.backendIO.Load())
 			return
+		case pnet.ComInitDB:
+			mgr.authenticator.updateCurrentDB(string(request[1:]))
 		}
 	}
 	// Even if it meets an MySQL error, it may have changed the status, such as when executing multi-statements.
@@ -311,7 +463,7 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 		if waitingRedirect && holdRequest {
 			mgr.tryRedirect(ctx)
 			// Execute the held request no matter redirection succeeds or not.
-			_, err = mgr.cmdProcessor.executeCmd(request, mgr.clientIO, mgr.backendIO.Load(), false)
+			_, err = mgr.executeCmd(request, false)
 			addCmdMetrics(cmd, mgr.ServerAddr(), startTime)
 			if err != nil && !IsMySQLError(err) {
 				return
@@ -367,7 +519,10 @@ func (mgr *BackendConnManager) querySessionStates(backendIO *pnet.PacketIO) (ses
 // - Receive redirection signals and then try to migrate the session.
 // - Send redirection results to the event receiver.
 // - Check if the backend is still alive.
+// - Detach the session from the backend if it idles for long enough.
+// - Refresh the session token before it expires.
 func (mgr *BackendConnManager) processSignals(ctx context.Context) {
+	detachCh := mgr.detachTickerC()
 	for {
 		select {
 		case s := <-mgr.signalReceived:
@@ -378,12 +533,20 @@ func (mgr *BackendConnManager) processSignals(ctx context.Context) {
 				mgr.tryGracefulClose(ctx)
 			case signalTypeRedirect:
 				mgr.tryRedirect(ctx)
+			case signalTypeEvacuateTimeout:
+				mgr.tryEvacuateClose()
 			}
 			mgr.processLock.Unlock()
-		case rs := <-mgr.redirectResCh:
-			mgr.notifyRedirectResult(ctx, rs)
+		case <-mgr.redirectResCh:
+			mgr.notifyRedirectResults(ctx)
 		case <-mgr.checkBackendTicker.C:
 			mgr.checkBackendActive()
+		case <-mgr.killTokenTicker.C:
+			mgr.refreshKillToken()
+		case <-detachCh:
+			mgr.processLock.Lock()
+			mgr.tryDetach()
+			mgr.processLock.Unlock()
 		case <-ctx.Done():
 			return
 		}
@@ -406,17 +569,26 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 	}
 
 	rs := &redirectResult{
-		from: mgr.ServerAddr(),
-		to:   signal.newAddr,
+		from:     mgr.ServerAddr(),
+		to:       signal.newAddr,
+		receiver: mgr.getEventReceiver(),
 	}
 	defer func() {
 		// The `mgr` won't be notified again before it calls `OnRedirectSucceed`, so simply `StorePointer` is also fine.
 		mgr.redirectInfo.Store(nil)
-		// Notifying may block. Notify the receiver asynchronously to:
-		// - Reduce the latency of session migration
-		// - Avoid the risk of deadlock
-		mgr.redirectResCh <- rs
+		if rs.err != nil {
+			mgr.redirectPhase = RedirectPhaseFailed
+		} else {
+			mgr.redirectPhase = RedirectPhaseSucceeded
+		}
+		mgr.updateSessionInfo()
+		mgr.addRedirectResult(rs)
 	}()
+	// The detached session will be restored on the new backend when the next command arrives.
+	if mgr.isDetached() {
+		mgr.detachedAddr.Store(&signal.newAddr)
+		return
+	}
 	backendIO := mgr.backendIO.Load()
 	var sessionStates, sessionToken string
 	if sessionStates, sessionToken, rs.err = mgr.querySessionStates(backendIO); rs.err != nil {
@@ -435,15 +607,16 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 	}
 
 	defer mgr.resetQuitSource()
-	var cn net.Conn
-	cn, rs.err = net.DialTimeout("tcp", rs.to, DialTimeout)
+	var newBackendIO *pnet.PacketIO
+	newBackendIO, rs.err = mgr.connectBackend(mgr.authenticator, rs.to)
 	if rs.err != nil {
 		mgr.quitSource = SrcBackendQuit
 		mgr.handshakeHandler.OnHandshake(mgr, rs.to, rs.err)
 		return
 	}
-	newBackendIO := pnet.NewPacketIO(cn, mgr.logger, pnet.WithRemoteAddr(rs.to, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn))
 
+	// The handshake overwrites the backend connection ID, so restore it if the redirection fails.
+	backendConnID := mgr.authenticator.backendConnID
 	if rs.err = mgr.authenticator.handshakeSecondTime(mgr.logger, mgr.clientIO, newBackendIO, mgr.backendTLS, sessionToken); rs.err == nil {
 		rs.err = mgr.initSessionStates(newBackendIO, sessionStates)
 	} else {
@@ -451,6 +624,7 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 		mgr.handshakeHandler.OnHandshake(mgr, newBackendIO.RemoteAddr().String(), rs.err)
 	}
 	if rs.err != nil {
+		mgr.authenticator.backendConnID = backendConnID
 		if ignoredErr := newBackendIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
 			mgr.logger.Error("close new backend connection failed", zap.Error(ignoredErr))
 		}
@@ -460,6 +634,7 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 		mgr.logger.Error("close previous backend connection failed", zap.Error(ignoredErr))
 	}
 	mgr.backendIO.Store(newBackendIO)
+	mgr.killToken = sessionToken
 	mgr.setKeepAlive(mgr.config.HealthyKeepAlive)
 	mgr.handshakeHandler.OnHandshake(mgr, mgr.ServerAddr(), nil)
 }
@@ -493,14 +668,56 @@ func (mgr *BackendConnManager) Redirect(newAddr string) bool {
 	return true
 }
 
+// addRedirectResult adds the redirection result to be notified.
+// Notifying may block. Notify the receiver asynchronously to:
+// - Reduce the latency of session migration
+// - Avoid the risk of deadlock
+func (mgr *BackendConnManager) addRedirectResult(rs *redirectResult) {
+	mgr.resultLock.Lock()
+	mgr.redirectResults = append(mgr.redirectResults, rs)
+	mgr.resultLock.Unlock()
+	select {
+	case mgr.redirectResCh <- struct{}{}:
+	default:
+	}
+}
+
+// notifyRedirectResults notifies the event receivers of the pending redirection results in order.
+func (mgr *BackendConnManager) notifyRedirectResults(ctx context.Context) {
+	mgr.notifyLock.Lock()
+	defer mgr.notifyLock.Unlock()
+	for {
+		mgr.resultLock.Lock()
+		if len(mgr.redirectResults) == 0 {
+			mgr.resultLock.Unlock()
+			return
+		}
+		rs := mgr.redirectResults[0]
+		mgr.redirectResults = mgr.redirectResults[1:]
+		mgr.resultLock.Unlock()
+		mgr.notifyRedirectResult(ctx, rs)
+	}
+}
+
 func (mgr *BackendConnManager) notifyRedirectResult(ctx context.Context, rs *redirectResult) {
 	if rs == nil {
 		return
 	}
-	eventReceiver := mgr.getEventReceiver()
+	// The connection may have moved to another router, so notify the router that produces the result.
+	eventReceiver := rs.receiver
 	if eventReceiver == nil {
 		return
 	}
+	if rs.leave {
+		if len(rs.to) > 0 {
+			if err := eventReceiver.OnRedirectFail(rs.from, rs.to, mgr); err != nil {
+				mgr.logger.Warn("notify redirect fail error", zap.String("from", rs.from), zap.String("to", rs.to), zap.Error(err))
+			}
+		}
+		err := eventReceiver.OnConnClosed(rs.from, mgr)
+		mgr.logger.Info("connection leaves the router", zap.String("from", rs.from), zap.NamedError("notify_err", err))
+		return
+	}
 	if rs.err != nil {
 		err := eventReceiver.OnRedirectFail(rs.from, rs.to, mgr)
 		mgr.logger.Warn("redirect connection failed", zap.String("from", rs.from),
@@ -518,6 +735,24 @@ func (mgr *BackendConnManager) GracefulClose() {
 	mgr.signalReceived <- signalTypeGracefulClose
 }
 
+// AdminClose closes the session on behalf of the admin. If graceful is true, it waits for the end of the transaction.
+// Otherwise, it interrupts the running command. The caller should also close the client connection if it's not graceful.
+func (mgr *BackendConnManager) AdminClose(graceful bool) {
+	if graceful {
+		// Sending the signal repeatedly may block.
+		if mgr.adminClose.CompareAndSwap(adminCloseNone, adminCloseGraceful) && mgr.closeStatus.Load() == statusActive {
+			mgr.GracefulClose()
+		}
+		return
+	}
+	mgr.adminClose.Store(adminCloseForce)
+	if backendIO := mgr.backendIO.Load(); backendIO != nil {
+		if err := backendIO.GracefulClose(); err != nil {
+			mgr.logger.Warn("graceful close backend IO error", zap.Stringer("backend_addr", backendIO.RemoteAddr()), zap.Error(err))
+		}
+	}
+}
+
 func (mgr *BackendConnManager) tryGracefulClose(ctx context.Context) {
 	if mgr.closeStatus.Load() != statusNotifyClose {
 		return
@@ -526,6 +761,9 @@ func (mgr *BackendConnManager) tryGracefulClose(ctx context.Context) {
 		return
 	}
 	mgr.quitSource = SrcProxyQuit
+	if mgr.adminClose.Load() != adminCloseNone {
+		mgr.quitSource = SrcAdminClose
+	}
 	// Closing clientIO will cause the whole connection to be closed.
 	if err := mgr.clientIO.GracefulClose(); err != nil {
 		mgr.logger.Warn("graceful close client IO error", zap.Stringer("client_addr", mgr.clientIO.RemoteAddr()), zap.Error(err))
@@ -542,6 +780,10 @@ func (mgr *BackendConnManager) checkBackendActive() {
 	mgr.processLock.Lock()
 	defer mgr.processLock.Unlock()
 	backendIO := mgr.backendIO.Load()
+	// The detached session has no backend connection to check.
+	if backendIO == nil {
+		return
+	}
 	if !backendIO.IsPeerActive() {
 		mgr.logger.Info("backend connection is closed, close client connection",
 			zap.Stringer("client_addr", mgr.clientIO.RemoteAddr()), zap.Stringer("backend_addr", backendIO.RemoteAddr()))
@@ -574,6 +816,9 @@ func (mgr *BackendConnManager) ServerAddr() string {
 	if backendIO := mgr.backendIO.Load(); backendIO != nil {
 		return backendIO.RemoteAddr().String()
 	}
+	if addr := mgr.detachedAddr.Load(); addr != nil {
+		return *addr
+	}
 	return ""
 }
 
@@ -592,6 +837,10 @@ func (mgr *BackendConnManager) ClientOutBytes() uint64 {
 }
 
 func (mgr *BackendConnManager) QuitSource() ErrorSource {
+	// Killing the connection causes read or write errors, which should not override the source.
+	if mgr.adminClose.Load() == adminCloseForce {
+		return SrcAdminClose
+	}
 	return mgr.quitSource
 }
 
@@ -613,6 +862,13 @@ func (mgr *BackendConnManager) Close() error {
 	if mgr.checkBackendTicker != nil {
 		mgr.checkBackendTicker.Stop()
 	}
+	mgr.stopEvacuateTimer()
+	if mgr.detachTicker != nil {
+		mgr.detachTicker.Stop()
+	}
+	if mgr.killTokenTicker != nil {
+		mgr.killTokenTicker.Stop()
+	}
 	if mgr.cancelFunc != nil {
 		mgr.cancelFunc()
 		mgr.cancelFunc = nil
@@ -627,15 +883,16 @@ func (mgr *BackendConnManager) Close() error {
 	if backendIO := mgr.backendIO.Swap(nil); backendIO != nil {
 		addr = backendIO.RemoteAddr().String()
 		connErr = backendIO.Close()
+	} else if detachedAddr := mgr.detachedAddr.Swap(nil); detachedAddr != nil {
+		addr = *detachedAddr
+		metrics.DetachedSessionGauge.Dec()
 	}
 	mgr.processLock.Unlock()
 
 	eventReceiver := mgr.getEventReceiver()
 	if eventReceiver != nil {
 		// Notify the receiver if there's any event.
-		if len(mgr.redirectResCh) > 0 {
-			mgr.notifyRedirectResult(context.Background(), <-mgr.redirectResCh)
-		}
+		mgr.notifyRedirectResults(context.Background())
 		// Just notify it with the current address.
 		if len(addr) > 0 {
 			if err := eventReceiver.OnConnClosed(addr, mgr); err != nil {
@@ -675,7 +932,9 @@ func (mgr *BackendConnManager) setQuitSourceByErr(err error) {
 	if err == nil {
 		return
 	}
-	if errors.Is(err, ErrBackendConn) {
+	if errors.Is(err, ErrEvacuateTimeout) {
+		mgr.quitSource = SrcEvacuateTimeout
+	} else if errors.Is(err, ErrBackendConn) {
 		mgr.quitSource = SrcBackendQuit
 	} else if IsMySQLError(err) {
 		mgr.quitSource = SrcClientErr
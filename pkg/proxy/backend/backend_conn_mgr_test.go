@@ -10,9 +10,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/errors"
 	"github.com/pingcap/TiProxy/lib/util/logger"
 	"github.com/pingcap/TiProxy/lib/util/waitgroup"
+	"github.com/pingcap/TiProxy/pkg/manager/namespace"
 	"github.com/pingcap/TiProxy/pkg/manager/router"
 	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
 	"github.com/pingcap/tidb/parser/mysql"
@@ -690,6 +692,112 @@ func TestGracefulCloseWhenActive(t *testing.T) {
 	ts.runTests(runners)
 }
 
+// Test that the session stuck in a transaction is closed after the backend is evacuated for a while.
+func TestEvacuateTimeout(t *testing.T) {
+	ts := newBackendMgrTester(t)
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// start a transaction
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.startTxn4Backend,
+		},
+		// the deadline is cleared once the session is not evacuated
+		{
+			proxy: func(_, _ *pnet.PacketIO) error {
+				ts.mp.config.EvacuateTxnTimeout = 100 * time.Millisecond
+				ts.mp.NotifyEvacuating(true)
+				ts.mp.NotifyEvacuating(false)
+				time.Sleep(300 * time.Millisecond)
+				require.Equal(t, statusActive, ts.mp.closeStatus.Load())
+				return nil
+			},
+		},
+		// the client receives an error and the session is closed
+		{
+			client: func(packetIO *pnet.PacketIO) error {
+				packetIO.ResetSequence()
+				pkt, err := packetIO.ReadPacket()
+				require.NoError(t, err)
+				require.Equal(t, mysql.ErrHeader, pkt[0])
+				require.Contains(t, pnet.ParseErrorPacket(pkt).Error(), "rolls back the transaction")
+				return nil
+			},
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				ts.mp.NotifyEvacuating(true)
+				return ts.checkConnClosed4Proxy(clientIO, backendIO)
+			},
+		},
+		{
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				require.Equal(t, SrcEvacuateTimeout, ts.mp.QuitSource())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
+// Test that the idle session is detached from the backend and restored when the next command arrives.
+func TestIdleDetach(t *testing.T) {
+	ts := newBackendMgrTester(t)
+	ts.mp.config.IdleDetachTimeout = 100 * time.Millisecond
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// the session is detached after idling
+		{
+			proxy: func(_, _ *pnet.PacketIO) error {
+				require.Eventually(t, ts.mp.isDetached, 3*time.Second, 10*time.Millisecond)
+				require.Nil(t, ts.mp.backendIO.Load())
+				require.Equal(t, ts.tc.backendListener.Addr().String(), ts.mp.ServerAddr())
+				return nil
+			},
+			backend: func(packetIO *pnet.PacketIO) error {
+				// respond to `SHOW SESSION STATES`
+				ts.mb.respondType = responseTypeResultSet
+				require.NoError(t, ts.mb.respond(packetIO))
+				// the backend connection is closed
+				_, err := packetIO.ReadPacket()
+				require.True(t, pnet.IsDisconnectError(err))
+				return nil
+			},
+		},
+		// the session is restored on a new backend connection
+		{
+			client: ts.mc.request,
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				ts.mp.config.IdleDetachTimeout = time.Minute
+				return ts.forwardCmd4Proxy(clientIO, backendIO)
+			},
+			backend: func(packetIO *pnet.PacketIO) error {
+				require.NoError(t, ts.handshake4Backend(packetIO))
+				// respond to `SET SESSION STATES`
+				require.NoError(t, ts.respondWithNoTxn4Backend(ts.tc.backendIO))
+				return ts.respondWithNoTxn4Backend(ts.tc.backendIO)
+			},
+		},
+		{
+			proxy: func(_, _ *pnet.PacketIO) error {
+				require.False(t, ts.mp.isDetached())
+				require.NotNil(t, ts.mp.backendIO.Load())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
 func TestGracefulCloseBeforeHandshake(t *testing.T) {
 	ts := newBackendMgrTester(t)
 	runners := []runner{
@@ -721,10 +829,14 @@ func TestGracefulCloseBeforeHandshake(t *testing.T) {
 }
 
 func TestHandlerReturnError(t *testing.T) {
+	denyLocal, err := namespace.NewAccessFilter("", config.AccessList{Deny: []string{"127.0.0.0/8", "::1"}})
+	require.NoError(t, err)
 	tests := []struct {
 		cfg        cfgOverrider
 		errMsg     string
 		quitSource ErrorSource
+		// tlsRejected means the client is rejected before the TLS handshake, so it can't read the error.
+		tlsRejected bool
 	}{
 		{
 			cfg: func(config *testConfig) {
@@ -754,12 +866,54 @@ func TestHandlerReturnError(t *testing.T) {
 			errMsg:     connectErrMsg,
 			quitSource: SrcProxyErr,
 		},
+		{
+			// the client receives an access denied error if the client certificate is rejected
+			cfg: func(config *testConfig) {
+				config.proxyConfig.handler.getRouter = func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
+					return nil, errors.Wrap(ErrClientCertRejected, errors.New("mocked error"))
+				}
+			},
+			errMsg:     "Access denied",
+			quitSource: SrcClientErr,
+		},
+		{
+			// the client receives a host not privileged error if the namespace denies the client address
+			cfg: func(config *testConfig) {
+				config.proxyConfig.handler.getRouter = func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
+					return nil, errors.Wrap(ErrClientAddrRejected, errors.New("mocked error"))
+				}
+			},
+			errMsg:     "is not allowed to connect",
+			quitSource: SrcClientErr,
+		},
+		{
+			// the global access list is checked in the handshake
+			cfg: func(config *testConfig) {
+				config.clientConfig.capability &= ^pnet.ClientSSL
+				config.proxyConfig.accessFilter = denyLocal
+			},
+			errMsg:     "is not allowed to connect",
+			quitSource: SrcClientErr,
+		},
+		{
+			// the global access list is checked before the TLS handshake
+			cfg: func(config *testConfig) {
+				config.clientConfig.capability |= pnet.ClientSSL
+				config.proxyConfig.accessFilter = denyLocal
+			},
+			quitSource:  SrcClientErr,
+			tlsRejected: true,
+		},
 	}
 	for _, test := range tests {
 		ts := newBackendMgrTester(t, test.cfg)
 		rn := runner{
 			client: func(packetIO *pnet.PacketIO) error {
 				err := ts.mc.authenticate(packetIO)
+				if test.tlsRejected {
+					require.Error(t, err)
+					return nil
+				}
 				require.NoError(t, err)
 				require.ErrorContains(t, ts.mc.mysqlErr, test.errMsg)
 				return nil
@@ -983,3 +1137,354 @@ func TestKeepAlive(t *testing.T) {
 	}
 	ts.runTests(runners)
 }
+
+// Test that ConnInfo reflects the status of the session.
+func TestConnInfo(t *testing.T) {
+	ts := newBackendMgrTester(t)
+	backendAddr := ts.tc.backendListener.Addr().String()
+	runners := []runner{
+		// 1st handshake
+		{
+			client: ts.mc.authenticate,
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				err := ts.firstHandshake4Proxy(clientIO, backendIO)
+				require.NoError(t, err)
+				info := ts.mp.ConnInfo()
+				require.Equal(t, ts.mc.username, info.User)
+				require.Equal(t, ts.mc.dbName, info.DB)
+				require.Equal(t, ts.mp.ClientAddr(), info.ClientAddr)
+				require.Equal(t, backendAddr, info.BackendAddr)
+				require.False(t, info.InTxn)
+				require.True(t, info.LastCmdTime.IsZero())
+				require.Equal(t, RedirectPhaseNone, info.RedirectPhase)
+				return nil
+			},
+			backend: ts.handshake4Backend,
+		},
+		// start a transaction
+		{
+			client: ts.mc.request,
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				err := ts.forwardCmd4Proxy(clientIO, backendIO)
+				require.NoError(t, err)
+				info := ts.mp.ConnInfo()
+				require.True(t, info.InTxn)
+				require.False(t, info.LastCmdTime.IsZero())
+				require.Equal(t, ts.mp.ClientInBytes(), info.InBytes)
+				require.Equal(t, ts.mp.ClientOutBytes(), info.OutBytes)
+				return nil
+			},
+			backend: ts.startTxn4Backend,
+		},
+		// the redirection waits for the end of the transaction
+		{
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				err := ts.checkNotRedirected4Proxy(clientIO, backendIO)
+				require.NoError(t, err)
+				info := ts.mp.ConnInfo()
+				require.Equal(t, RedirectPhasePending, info.RedirectPhase)
+				require.Equal(t, backendAddr, info.RedirectTo)
+				return nil
+			},
+		},
+		// finish the transaction and redirect
+		{
+			client: ts.mc.request,
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				err := ts.redirectAfterCmd4Proxy(clientIO, backendIO)
+				require.NoError(t, err)
+				info := ts.mp.ConnInfo()
+				require.False(t, info.InTxn)
+				require.Equal(t, RedirectPhaseSucceeded, info.RedirectPhase)
+				require.Empty(t, info.RedirectTo)
+				return nil
+			},
+			backend: func(packetIO *pnet.PacketIO) error {
+				err := ts.respondWithNoTxn4Backend(packetIO)
+				require.NoError(t, err)
+				return ts.redirectSucceed4Backend(packetIO)
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
+func TestAdminClose(t *testing.T) {
+	ts := newBackendMgrTester(t)
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// start a transaction to make it active
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.startTxn4Backend,
+		},
+		// gracefully close but it waits for the transaction
+		{
+			proxy: func(_, _ *pnet.PacketIO) error {
+				ts.mp.AdminClose(true)
+				// Calling it again doesn't block.
+				ts.mp.AdminClose(true)
+				require.Eventually(t, func() bool {
+					return ts.mp.adminClose.Load() == adminCloseGraceful && ts.mp.closeStatus.Load() == statusNotifyClose
+				}, 3*time.Second, 10*time.Millisecond)
+				// The buffer size of channel signalReceived is 0, so the first signal is processed after sending another one.
+				ts.mp.signalReceived <- signalTypeGracefulClose
+				require.Equal(t, statusNotifyClose, ts.mp.closeStatus.Load())
+				return nil
+			},
+		},
+		// finish the transaction and it closes
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.respondWithNoTxn4Backend,
+		},
+		{
+			proxy: ts.checkConnClosed4Proxy,
+		},
+		{
+			proxy: func(_, _ *pnet.PacketIO) error {
+				require.Equal(t, SrcAdminClose, ts.mp.QuitSource())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
+func TestAdminKill(t *testing.T) {
+	ts := newBackendMgrTester(t)
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// the backend connection is interrupted immediately
+		{
+			proxy: func(_, _ *pnet.PacketIO) error {
+				ts.mp.AdminClose(false)
+				_, err := ts.mp.backendIO.Load().ReadPacket()
+				require.Error(t, err)
+				// The IO error doesn't override the quit source.
+				ts.mp.setQuitSourceByErr(err)
+				require.Equal(t, SrcAdminClose, ts.mp.QuitSource())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
+// Test that the client verified by TiProxy can not change the user.
+func TestProxyAuthChangeUser(t *testing.T) {
+	ts := newBackendMgrTester(t, func(cfg *testConfig) {
+		cfg.proxyConfig.handler.verifier = &mockVerifier{}
+		// The sequences of the client and the backend are the same with mysql_native_password.
+		cfg.backendConfig.authPlugin = pnet.AuthNativePassword
+	})
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// COM_CHANGE_USER is rejected
+		{
+			client: func(packetIO *pnet.PacketIO) error {
+				ts.mc.cmd = pnet.ComChangeUser
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				clientIO.ResetSequence()
+				request, err := clientIO.ReadPacket()
+				require.NoError(t, err)
+				return ts.mp.ExecuteCmd(context.Background(), request)
+			},
+		},
+		{
+			client: func(packetIO *pnet.PacketIO) error {
+				require.ErrorContains(t, ts.mc.mysqlErr, "changing the user is not supported")
+				return nil
+			},
+			proxy: func(clientIO, backendIO *pnet.PacketIO) error {
+				require.Equal(t, "svc_user", ts.mp.authenticator.user)
+				require.Equal(t, statusActive, ts.mp.closeStatus.Load())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
+// Test that COM_CHANGE_USER moves the session to the namespace of the new user.
+func TestChangeUserReroute(t *testing.T) {
+	var ts *backendMgrTester
+	ts = newBackendMgrTester(t, func(cfg *testConfig) {
+		cfg.backendConfig.authPlugin = pnet.AuthNativePassword
+		cfg.proxyConfig.handler.getRouter = func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
+			// Each user belongs to a namespace with the same name.
+			ctx.SetValue(ConnContextKeyNamespace, resp.User)
+			return router.NewStaticRouter([]string{ts.tc.backendListener.Addr().String()}), nil
+		}
+	})
+	ts.runTests([]runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+	})
+
+	// Change to a user of another namespace. The proxy logs into a new backend, so the sequences of the client
+	// and the backend differ.
+	prevIO := ts.mp.backendIO.Load()
+	prevReceiver := ts.mp.getEventReceiver().(*mockEventReceiver)
+	ts.mc.cmd = pnet.ComChangeUser
+	ts.mc.username = "user2"
+	ts.mc.attrs = map[string]string{"program_name": "reroute"}
+	ts.runAndCheck(t, func(t *testing.T, _ *testSuite) {
+		require.NoError(t, ts.mc.err)
+		require.NoError(t, ts.mp.err)
+		require.NoError(t, ts.mb.err)
+		require.NoError(t, ts.mc.mysqlErr)
+		require.NotEqual(t, prevIO, ts.mp.backendIO.Load())
+		require.Equal(t, "user2", ts.mp.authenticator.user)
+		require.Equal(t, "user2", ts.mp.Value(ConnContextKeyNamespace))
+		// The new attributes are sent to the backend.
+		require.Equal(t, ts.mc.attrs, ts.mb.attrs)
+		require.Equal(t, ts.mc.attrs, ts.mp.authenticator.attrs)
+		// The connection leaves the previous router before the command returns.
+		prevReceiver.checkEvent(t, eventClose)
+	}, ts.mc.request, ts.handshake4Backend, ts.forwardCmd4Proxy)
+	ts.mp.SetEventReceiver(newMockEventReceiver())
+
+	// The session stays if the new user fails to log in.
+	prevIO = ts.mp.backendIO.Load()
+	ts.mc.username = "user3"
+	ts.runAndCheck(t, func(t *testing.T, _ *testSuite) {
+		require.Error(t, ts.mc.mysqlErr)
+		require.Equal(t, prevIO, ts.mp.backendIO.Load())
+		require.Equal(t, "user2", ts.mp.authenticator.user)
+		require.Equal(t, "user2", ts.mp.Value(ConnContextKeyNamespace))
+		require.Equal(t, statusActive, ts.mp.closeStatus.Load())
+	}, ts.mc.request, func(packetIO *pnet.PacketIO) error {
+		ts.mb.authSucceed = false
+		return ts.handshake4Backend(packetIO)
+	}, ts.forwardCmd4Proxy)
+}
+
+// Test that the statement is killed through another connection after it exceeds the SQL timeout.
+func TestSQLTimeout(t *testing.T) {
+	var ts *backendMgrTester
+	ts = newBackendMgrTester(t, func(cfg *testConfig) {
+		cfg.proxyConfig.handler.getRouter = func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
+			ctx.SetValue(ConnContextKeySQLTimeout, 100*time.Millisecond)
+			return router.NewStaticRouter([]string{ts.tc.backendListener.Addr().String()}), nil
+		}
+	})
+	// The session token is queried right after the handshake, so the sequences of the client and the backend differ.
+	ts.runAndCheck(t, func(t *testing.T, _ *testSuite) {
+		require.NoError(t, ts.mc.err)
+		require.NoError(t, ts.mp.err)
+		require.NoError(t, ts.mb.err)
+		require.Equal(t, mockCmdStr, ts.mp.killToken)
+	}, ts.mc.authenticate, func(packetIO *pnet.PacketIO) error {
+		if err := ts.handshake4Backend(packetIO); err != nil {
+			return err
+		}
+		// respond to `SHOW SESSION STATES`
+		ts.mb.respondType = responseTypeResultSet
+		return ts.mb.respond(ts.tc.backendIO)
+	}, ts.firstHandshake4Proxy)
+
+	runners := []runner{
+		{
+			client: func(packetIO *pnet.PacketIO) error {
+				ts.mc.cmd = pnet.ComQuery
+				return ts.mc.request(packetIO)
+			},
+			proxy: ts.forwardCmd4Proxy,
+			backend: func(packetIO *pnet.PacketIO) error {
+				// the statement keeps running until it's killed
+				packetIO.ResetSequence()
+				_, err := packetIO.ReadPacket()
+				require.NoError(t, err)
+				conn, err := ts.tc.backendListener.Accept()
+				require.NoError(t, err)
+				killIO := pnet.NewPacketIO(conn, ts.lg)
+				defer func() {
+					require.NoError(t, killIO.Close())
+				}()
+				require.NoError(t, ts.mb.authenticate(killIO))
+				require.Equal(t, mockCmdStr, string(ts.mb.authData))
+				killIO.ResetSequence()
+				request, err := killIO.ReadPacket()
+				require.NoError(t, err)
+				require.Equal(t, pnet.ComQuery.Byte(), request[0])
+				require.Equal(t, fmt.Sprintf(sqlKillQuery, pnet.ConnID), string(request[1:]))
+				require.NoError(t, killIO.WriteOKPacket(0, pnet.OKHeader))
+				return packetIO.WriteErrPacket(mysql.ErrQueryInterrupted)
+			},
+		},
+		{
+			client: func(packetIO *pnet.PacketIO) error {
+				require.Error(t, ts.mc.mysqlErr)
+				return nil
+			},
+			proxy: func(_, _ *pnet.PacketIO) error {
+				require.Equal(t, statusActive, ts.mp.closeStatus.Load())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
+// Test that the backend connection is closed if the statement exceeds the SQL timeout but there's no token to kill it.
+func TestSQLTimeoutWithoutToken(t *testing.T) {
+	var ts *backendMgrTester
+	ts = newBackendMgrTester(t, func(cfg *testConfig) {
+		cfg.proxyConfig.handler.getRouter = func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
+			ctx.SetValue(ConnContextKeySQLTimeout, 100*time.Millisecond)
+			return router.NewStaticRouter([]string{ts.tc.backendListener.Addr().String()}), nil
+		}
+	})
+	ts.runAndCheck(t, func(t *testing.T, _ *testSuite) {
+		require.NoError(t, ts.mc.err)
+		require.NoError(t, ts.mp.err)
+		require.NoError(t, ts.mb.err)
+		require.Empty(t, ts.mp.killToken)
+	}, ts.mc.authenticate, func(packetIO *pnet.PacketIO) error {
+		if err := ts.handshake4Backend(packetIO); err != nil {
+			return err
+		}
+		// fail to respond to `SHOW SESSION STATES`
+		ts.mb.respondType = responseTypeErr
+		return ts.mb.respond(ts.tc.backendIO)
+	}, ts.firstHandshake4Proxy)
+
+	ts.mc.cmd = pnet.ComQuery
+	ts.runAndCheck(t, func(t *testing.T, _ *testSuite) {
+		require.NoError(t, ts.mc.err)
+		require.ErrorContains(t, ts.mc.mysqlErr, "exceeds the SQL timeout")
+		require.ErrorIs(t, ts.mp.err, ErrSQLTimeout)
+		require.NoError(t, ts.mb.err)
+	}, ts.mc.request, func(packetIO *pnet.PacketIO) error {
+		// the statement keeps running until the connection is closed
+		packetIO.ResetSequence()
+		_, err := packetIO.ReadPacket()
+		require.NoError(t, err)
+		_, err = packetIO.ReadPacket()
+		require.True(t, pnet.IsDisconnectError(err))
+		return nil
+	}, ts.forwardCmd4Proxy)
+}
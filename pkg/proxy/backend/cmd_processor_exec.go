@@ -43,8 +43,8 @@ func (cp *CmdProcessor) forwardCommand(clientIO, backendIO *pnet.PacketIO, reque
 			return err
 		}
 	} else {
-		user, db := pnet.ParseChangeUser(request)
-		if err := backendIO.WritePacket(pnet.MakeChangeUser(user, db, unknownAuthPlugin, nil), true); err != nil {
+		user, db, attrs := pnet.ParseChangeUser(request, cp.capability)
+		if err := backendIO.WritePacket(pnet.MakeChangeUser(user, db, unknownAuthPlugin, nil, attrs), true); err != nil {
 			return err
 		}
 	}
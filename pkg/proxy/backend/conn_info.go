@@ -0,0 +1,110 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"time"
+)
+
+// The redirect phases of a connection.
+const (
+	// RedirectPhaseNone means the connection has never been redirected.
+	RedirectPhaseNone = "none"
+	// RedirectPhasePending means the connection is waiting for the end of the transaction to be redirected.
+	RedirectPhasePending = "pending"
+	// RedirectPhaseSucceeded means the last redirection succeeded.
+	RedirectPhaseSucceeded = "succeeded"
+	// RedirectPhaseFailed means the last redirection failed.
+	RedirectPhaseFailed = "failed"
+)
+
+// ConnInfo is the snapshot of a connection, which is shown in the API.
+type ConnInfo struct {
+	ConnectionID uint64 `json:"id"`
+	// ClientAddr is the source address in the proxy protocol if it's enabled.
+	ClientAddr string `json:"client-addr"`
+	// ProxyAddr is the address of the proxy in front of TiProxy, which is only set when the proxy protocol is used.
+	ProxyAddr     string `json:"proxy-addr,omitempty"`
+	BackendAddr   string `json:"backend-addr"`
+	BackendConnID uint64 `json:"backend-conn-id"`
+	User          string `json:"user"`
+	Namespace     string `json:"namespace"`
+	// DB is updated after COM_INIT_DB and redirection, so it may be outdated after executing `USE db`.
+	DB                   string    `json:"db"`
+	InTxn                bool      `json:"in-txn"`
+	PendingPreparedStmts int       `json:"pending-prepared-stmts"`
+	InBytes              uint64    `json:"in-bytes"`
+	OutBytes             uint64    `json:"out-bytes"`
+	ConnectTime          time.Time `json:"connect-time"`
+	LastCmdTime          time.Time `json:"last-cmd-time,omitempty"`
+	RedirectPhase        string    `json:"redirect-phase"`
+	// RedirectTo is the target backend when the redirect phase is pending.
+	RedirectTo string `json:"redirect-to,omitempty"`
+	// Detached means the idle session has released its backend connection.
+	Detached bool `json:"detached,omitempty"`
+}
+
+// sessionInfo is the part of ConnInfo that can only be read when processLock is held.
+type sessionInfo struct {
+	user                 string
+	db                   string
+	backendConnID        uint64
+	inTxn                bool
+	pendingPreparedStmts int
+	redirectPhase        string
+}
+
+// updateSessionInfo saves a snapshot of the session so that ConnInfo() doesn't need to wait for the running command.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) updateSessionInfo() {
+	info := &sessionInfo{
+		user:          mgr.authenticator.user,
+		db:            mgr.authenticator.dbname,
+		backendConnID: mgr.authenticator.backendConnID,
+		inTxn:         mgr.cmdProcessor.serverStatus&StatusInTrans > 0,
+		redirectPhase: mgr.redirectPhase,
+	}
+	for _, status := range mgr.cmdProcessor.preparedStmtStatus {
+		if status > 0 {
+			info.pendingPreparedStmts++
+		}
+	}
+	mgr.sessionInfo.Store(info)
+}
+
+// ConnInfo returns the snapshot of the connection. It doesn't block even if a command is running.
+func (mgr *BackendConnManager) ConnInfo() *ConnInfo {
+	info := &ConnInfo{
+		ConnectionID:  mgr.connectionID,
+		ClientAddr:    mgr.ClientAddr(),
+		BackendAddr:   mgr.ServerAddr(),
+		InBytes:       mgr.ClientInBytes(),
+		OutBytes:      mgr.ClientOutBytes(),
+		ConnectTime:   mgr.connectTime,
+		RedirectPhase: RedirectPhaseNone,
+		Detached:      mgr.isDetached(),
+	}
+	if mgr.clientIO != nil && mgr.clientIO.Proxy() != nil {
+		info.ProxyAddr = mgr.clientIO.PeerAddr().String()
+	}
+	if ns, ok := mgr.Value(ConnContextKeyNamespace).(string); ok {
+		info.Namespace = ns
+	}
+	if lastCmdTime := mgr.lastCmdTime.Load(); lastCmdTime > 0 {
+		info.LastCmdTime = time.Unix(0, lastCmdTime)
+	}
+	if si := mgr.sessionInfo.Load(); si != nil {
+		info.User = si.user
+		info.DB = si.db
+		info.BackendConnID = si.backendConnID
+		info.InTxn = si.inTxn
+		info.PendingPreparedStmts = si.pendingPreparedStmts
+		info.RedirectPhase = si.redirectPhase
+	}
+	if signal := mgr.redirectInfo.Load(); signal != nil {
+		info.RedirectPhase = RedirectPhasePending
+		info.RedirectTo = signal.newAddr
+	}
+	return info
+}
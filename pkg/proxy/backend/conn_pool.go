@@ -0,0 +1,311 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/lib/util/waitgroup"
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"go.uber.org/zap"
+)
+
+const (
+	// poolConnMaxIdle is the time that a connection stays in the pool before it's replaced by a new one.
+	poolConnMaxIdle = time.Minute
+	// poolIdleTimeout is the time that the pool of a backend is kept after it's last used.
+	poolIdleTimeout   = 5 * time.Minute
+	poolCheckInterval = 10 * time.Second
+)
+
+// pooledConn is a pre-established backend connection. It has read the initial handshake packet and has upgraded
+// to TLS if tls is true, so the session only needs to send the handshake response.
+type pooledConn struct {
+	backendIO  *pnet.PacketIO
+	serverPkt  []byte
+	tls        bool
+	createTime time.Time
+}
+
+func (pc *pooledConn) close() {
+	_ = pc.backendIO.Close()
+}
+
+// The connections without TLS are shared by all the sessions. The TLS connections are only shared by the sessions
+// of the same namespace because the namespaces may use different backend certs.
+type poolKey struct {
+	addr      string
+	namespace string
+	tls       bool
+}
+
+type connPool struct {
+	conns []*pooledConn
+	// tlsConfig is the config that the connections upgrade to TLS with. The certs are reloaded periodically and
+	// each reload generates a new config, so the pool is stale once the config is not the latest one.
+	tlsConfig *tls.Config
+	lastUsed  time.Time
+	filling   bool
+}
+
+// BackendPool keeps pre-established connections to the backends to cut the latency of connecting and TLS handshakes.
+// The pool of a backend is created and filled once a session connects to the backend, and it's dropped after
+// it's unused for a while. So only the backends that are routed to, which are healthy, keep the connections.
+// The pool doesn't work with the proxy protocol because the proxy header must be sent before the initial handshake.
+type BackendPool struct {
+	sync.Mutex
+	logger *zap.Logger
+	pools  map[poolKey]*connPool
+	size   int
+	// backendTLS returns the latest TLS config to connect to the backends of a namespace. It may be nil.
+	backendTLS func(namespace string) *tls.Config
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         waitgroup.WaitGroup
+}
+
+// NewBackendPool creates a BackendPool. It keeps at most size connections for each backend.
+// backendTLS returns the latest TLS config of a namespace, which tells whether the pooled TLS connections are stale.
+func NewBackendPool(logger *zap.Logger, size int, backendTLS func(namespace string) *tls.Config) *BackendPool {
+	bp := &BackendPool{
+		logger:     logger,
+		pools:      make(map[poolKey]*connPool),
+		size:       size,
+		backendTLS: backendTLS,
+	}
+	bp.ctx, bp.cancel = context.WithCancel(context.Background())
+	bp.wg.Run(bp.maintainLoop)
+	return bp
+}
+
+// SetSize updates the size of the pool of each backend. 0 disables the pool.
+func (bp *BackendPool) SetSize(size int) {
+	bp.Lock()
+	bp.size = size
+	bp.Unlock()
+}
+
+// get returns a pooled connection to the backend, or nil if there's none available.
+// The pool is refilled asynchronously.
+func (bp *BackendPool) get(addr, namespace string, tlsConfig *tls.Config) *pooledConn {
+	if bp == nil {
+		return nil
+	}
+	key := poolKey{addr: addr}
+	if tlsConfig != nil {
+		// The sessions that connected before the certs are reloaded still use the previous config.
+		// They dial the backends by themselves so that the pool doesn't switch between the configs.
+		if !bp.isLatestTLS(namespace, tlsConfig) {
+			return nil
+		}
+		key.namespace, key.tls = namespace, true
+	}
+	for {
+		pc, enabled := bp.pop(key, tlsConfig)
+		if !enabled {
+			return nil
+		}
+		if pc == nil {
+			addBackendPoolMetrics(addr, false)
+			return nil
+		}
+		// The backend may close the connection, e.g. when it restarts.
+		if pc.backendIO.IsPeerActive() {
+			addBackendPoolMetrics(addr, true)
+			return pc
+		}
+		pc.close()
+	}
+}
+
+// isLatestTLS returns true if the TLS config is the latest one of the namespace.
+func (bp *BackendPool) isLatestTLS(namespace string, tlsConfig *tls.Config) bool {
+	return bp.backendTLS == nil || bp.backendTLS(namespace) == tlsConfig
+}
+
+func (bp *BackendPool) pop(key poolKey, tlsConfig *tls.Config) (pc *pooledConn, enabled bool) {
+	var stale []*pooledConn
+	defer func() {
+		for _, pc := range stale {
+			pc.close()
+		}
+	}()
+	bp.Lock()
+	defer bp.Unlock()
+	if bp.size <= 0 {
+		return nil, false
+	}
+	pool, ok := bp.pools[key]
+	if !ok {
+		pool = &connPool{tlsConfig: tlsConfig}
+		bp.pools[key] = pool
+	} else if pool.tlsConfig != tlsConfig {
+		// The certs are reloaded, so the connections are replaced by the ones with the new config.
+		stale, pool.conns = pool.conns, nil
+		pool.tlsConfig = tlsConfig
+	}
+	pool.lastUsed = time.Now()
+	// The newest connection is the least likely to be stale.
+	if n := len(pool.conns); n > 0 {
+		pc = pool.conns[n-1]
+		pool.conns = pool.conns[:n-1]
+	}
+	bp.fillLocked(key, pool)
+	return pc, true
+}
+
+// fillLocked starts filling the pool if it's not full.
+// NOTE: the lock should be held before calling this function.
+func (bp *BackendPool) fillLocked(key poolKey, pool *connPool) {
+	if pool.filling || len(pool.conns) >= bp.size || bp.ctx.Err() != nil {
+		return
+	}
+	pool.filling = true
+	bp.wg.Run(func() {
+		bp.fill(key)
+	})
+}
+
+// fill dials the backend until the pool is full. It stops once it fails because the backend may be unhealthy,
+// and it will retry when the pool is used next time.
+func (bp *BackendPool) fill(key poolKey) {
+	for {
+		bp.Lock()
+		pool, ok := bp.pools[key]
+		if !ok || len(pool.conns) >= bp.size || bp.ctx.Err() != nil {
+			if ok {
+				pool.filling = false
+			}
+			bp.Unlock()
+			return
+		}
+		tlsConfig := pool.tlsConfig
+		bp.Unlock()
+
+		pc, err := bp.dial(key.addr, tlsConfig)
+		bp.Lock()
+		pool, ok = bp.pools[key]
+		// The config may change during dialing, then dial again with the new config.
+		if err == nil && ok && pool.tlsConfig != tlsConfig {
+			bp.Unlock()
+			pc.close()
+			continue
+		}
+		if err != nil || !ok {
+			if ok {
+				pool.filling = false
+			}
+			bp.Unlock()
+			if pc != nil {
+				pc.close()
+			}
+			if err != nil {
+				bp.logger.Debug("fill backend pool failed", zap.String("backend_addr", key.addr), zap.Error(err))
+			}
+			return
+		}
+		pool.conns = append(pool.conns, pc)
+		bp.Unlock()
+	}
+}
+
+// dial connects to the backend, reads the initial handshake and upgrades to TLS if possible.
+func (bp *BackendPool) dial(addr string, tlsConfig *tls.Config) (*pooledConn, error) {
+	cn, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial backend %s error", addr)
+	}
+	// The handshake should not block the pool for long.
+	if err = cn.SetDeadline(time.Now().Add(DialTimeout)); err != nil {
+		_ = cn.Close()
+		return nil, errors.WithStack(err)
+	}
+	backendIO := pnet.NewPacketIO(cn, bp.logger, pnet.WithRemoteAddr(addr, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn))
+	pc := &pooledConn{
+		backendIO:  backendIO,
+		createTime: time.Now(),
+	}
+	if pc.serverPkt, err = backendIO.ReadPacket(); err != nil {
+		return pc, err
+	}
+	if pnet.IsErrorPacket(pc.serverPkt) {
+		return pc, pnet.ParseErrorPacket(pc.serverPkt)
+	}
+	capability, _ := pnet.ParseInitialHandshake(pc.serverPkt)
+	if tlsConfig != nil && capability&pnet.ClientSSL != 0 {
+		// The backend parses the capability again from the handshake response after the TLS handshake,
+		// so the capability of the session doesn't need to be known now.
+		pkt := pnet.MakeHandshakeResponse(&pnet.HandshakeResp{
+			Capability: SupportedServerCapabilities | pnet.ClientSSL,
+		})
+		if err = upgradeBackendTLS(backendIO, pkt[:32], tlsConfig); err != nil {
+			return pc, err
+		}
+		pc.tls = true
+	}
+	if err = cn.SetDeadline(time.Time{}); err != nil {
+		return pc, errors.WithStack(err)
+	}
+	return pc, nil
+}
+
+func (bp *BackendPool) maintainLoop() {
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bp.ctx.Done():
+			return
+		case <-ticker.C:
+			bp.maintain(time.Now())
+		}
+	}
+}
+
+// maintain replaces the stale connections and drops the pools that are unused for a while or whose TLS configs are
+// not the latest.
+func (bp *BackendPool) maintain(now time.Time) {
+	var stale []*pooledConn
+	bp.Lock()
+	for key, pool := range bp.pools {
+		if bp.size <= 0 || now.Sub(pool.lastUsed) >= poolIdleTimeout || (key.tls && !bp.isLatestTLS(key.namespace, pool.tlsConfig)) {
+			stale = append(stale, pool.conns...)
+			delete(bp.pools, key)
+			continue
+		}
+		fresh := pool.conns[:0]
+		for _, pc := range pool.conns {
+			if now.Sub(pc.createTime) >= poolConnMaxIdle || len(fresh) >= bp.size {
+				stale = append(stale, pc)
+			} else {
+				fresh = append(fresh, pc)
+			}
+		}
+		pool.conns = fresh
+		bp.fillLocked(key, pool)
+	}
+	bp.Unlock()
+	for _, pc := range stale {
+		pc.close()
+	}
+}
+
+// Close closes all the pooled connections.
+func (bp *BackendPool) Close() {
+	bp.cancel()
+	bp.wg.Wait()
+	bp.Lock()
+	for key, pool := range bp.pools {
+		for _, pc := range pool.conns {
+			pc.close()
+		}
+		delete(bp.pools, key)
+	}
+	bp.Unlock()
+}
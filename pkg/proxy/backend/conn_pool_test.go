@@ -0,0 +1,196 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/util/logger"
+	"github.com/pingcap/TiProxy/lib/util/security"
+	"github.com/pingcap/TiProxy/lib/util/waitgroup"
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"github.com/stretchr/testify/require"
+)
+
+// mockPoolBackend sends the initial handshake on each connection and keeps the connections open.
+// If tlsConfig is not nil, it supports TLS and authenticates the connections like a real backend.
+type mockPoolBackend struct {
+	sync.Mutex
+	listener net.Listener
+	conns    []net.Conn
+	wg       waitgroup.WaitGroup
+}
+
+func newMockPoolBackend(t *testing.T, tlsConfig *tls.Config) *mockPoolBackend {
+	lg, _ := logger.CreateLoggerForTest(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	mb := &mockPoolBackend{listener: listener}
+	mb.wg.Run(func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mb.Lock()
+			mb.conns = append(mb.conns, conn)
+			mb.Unlock()
+			packetIO := pnet.NewPacketIO(conn, lg)
+			if tlsConfig == nil {
+				_ = packetIO.WriteInitialHandshake(defaultTestBackendCapability&^pnet.ClientSSL, mockSalt, pnet.AuthNativePassword, pnet.ServerVersion)
+				continue
+			}
+			mb.wg.Run(func() {
+				cfg := newBackendConfig()
+				cfg.tlsConfig = tlsConfig
+				_ = newMockBackend(cfg).authenticate(packetIO)
+			})
+		}
+	})
+	t.Cleanup(func() {
+		require.NoError(t, listener.Close())
+		mb.wg.Wait()
+		mb.Lock()
+		for _, conn := range mb.conns {
+			require.NoError(t, conn.Close())
+		}
+		mb.Unlock()
+	})
+	return mb
+}
+
+func (bp *BackendPool) connNum(key poolKey) int {
+	bp.Lock()
+	defer bp.Unlock()
+	if pool, ok := bp.pools[key]; ok {
+		return len(pool.conns)
+	}
+	return 0
+}
+
+func TestBackendPool(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	mb := newMockPoolBackend(t, nil)
+	addr := mb.listener.Addr().String()
+	key := poolKey{addr: addr}
+	bp := NewBackendPool(lg, 2, nil)
+	t.Cleanup(bp.Close)
+
+	// The first connection misses and the pool is filled.
+	misses, err := readBackendPoolCounter(addr, false)
+	require.NoError(t, err)
+	require.Nil(t, bp.get(addr, "ns", nil))
+	newMisses, err := readBackendPoolCounter(addr, false)
+	require.NoError(t, err)
+	require.Equal(t, misses+1, newMisses)
+	require.Eventually(t, func() bool {
+		return bp.connNum(key) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// The pooled connection has read the initial handshake.
+	hits, err := readBackendPoolCounter(addr, true)
+	require.NoError(t, err)
+	pc := bp.get(addr, "ns", nil)
+	require.NotNil(t, pc)
+	require.False(t, pc.tls)
+	auth := &Authenticator{}
+	_, capability, err := auth.readInitialHandshake(pc.backendIO, pc)
+	require.NoError(t, err)
+	require.Equal(t, pnet.Capability(0), capability&pnet.ClientSSL)
+	pc.close()
+	newHits, err := readBackendPoolCounter(addr, true)
+	require.NoError(t, err)
+	require.Equal(t, hits+1, newHits)
+	require.Eventually(t, func() bool {
+		return bp.connNum(key) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// The stale connections are replaced.
+	bp.Lock()
+	oldConns := append([]*pooledConn{}, bp.pools[key].conns...)
+	bp.Unlock()
+	bp.maintain(time.Now().Add(poolConnMaxIdle))
+	require.Eventually(t, func() bool {
+		return bp.connNum(key) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	bp.Lock()
+	for _, pc := range bp.pools[key].conns {
+		require.NotContains(t, oldConns, pc)
+	}
+	bp.Unlock()
+
+	// The pool is dropped if it's unused for a while.
+	bp.maintain(time.Now().Add(poolIdleTimeout))
+	require.Equal(t, 0, bp.connNum(key))
+
+	// The pool is disabled.
+	bp.SetSize(0)
+	require.Nil(t, bp.get(addr, "ns", nil))
+	newMisses2, err := readBackendPoolCounter(addr, false)
+	require.NoError(t, err)
+	require.Equal(t, newMisses, newMisses2)
+}
+
+// Test that the session completes the handshake over a pooled TLS connection and the stale pools are replaced
+// after the certs are reloaded.
+func TestBackendPoolTLS(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	serverTLS, clientTLS, err := security.CreateTLSConfigForTest()
+	require.NoError(t, err)
+	mb := newMockPoolBackend(t, serverTLS)
+	addr := mb.listener.Addr().String()
+	key := poolKey{addr: addr, namespace: "ns", tls: true}
+	var latestTLS atomic.Pointer[tls.Config]
+	latestTLS.Store(clientTLS)
+	bp := NewBackendPool(lg, 1, func(string) *tls.Config {
+		return latestTLS.Load()
+	})
+	t.Cleanup(bp.Close)
+
+	require.Nil(t, bp.get(addr, "ns", clientTLS))
+	require.Eventually(t, func() bool {
+		return bp.connNum(key) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	// The connections without TLS are not shared with the TLS ones.
+	require.Equal(t, 0, bp.connNum(poolKey{addr: addr}))
+
+	pc := bp.get(addr, "ns", clientTLS)
+	require.NotNil(t, pc)
+	require.True(t, pc.tls)
+	auth := &Authenticator{
+		user:       "root",
+		capability: defaultTestClientCapability,
+		pooled:     pc,
+	}
+	require.NoError(t, auth.handshakeSecondTime(lg, nil, pc.backendIO, clientTLS, mockCmdStr))
+	require.True(t, pc.backendIO.TLSConnectionState().HandshakeComplete)
+	require.Nil(t, auth.pooled)
+	pc.close()
+	require.Eventually(t, func() bool {
+		return bp.connNum(key) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// After the certs are reloaded, the sessions with the previous config don't use the pool,
+	// and the stale pool is closed.
+	newTLS := clientTLS.Clone()
+	latestTLS.Store(newTLS)
+	require.Nil(t, bp.get(addr, "ns", clientTLS))
+	bp.maintain(time.Now())
+	require.Equal(t, 0, bp.connNum(key))
+
+	// The pool is refilled with the new config.
+	require.Nil(t, bp.get(addr, "ns", newTLS))
+	require.Eventually(t, func() bool {
+		return bp.connNum(key) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	pc = bp.get(addr, "ns", newTLS)
+	require.NotNil(t, pc)
+	require.True(t, pc.tls)
+	pc.close()
+}
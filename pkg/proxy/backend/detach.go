@@ -0,0 +1,118 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/pkg/metrics"
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"github.com/siddontang/go/hack"
+	"go.uber.org/zap"
+)
+
+// Idle sessions can be detached from the backends to reduce the connections on TiDB.
+// When a session idles outside transactions for a while, its session states are saved and the backend connection
+// is released. When the next command arrives, the session is restored on a new backend connection in the same way
+// as session migration.
+// The session token expires after a while in TiDB, so it's refreshed periodically on a separate connection by
+// refreshKillToken while the session is detached.
+
+// resetDetachTicker restarts counting the idle time.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) resetDetachTicker() {
+	if mgr.detachTicker != nil {
+		mgr.detachTicker.Reset(mgr.config.IdleDetachTimeout)
+	}
+}
+
+func (mgr *BackendConnManager) detachTickerC() <-chan time.Time {
+	if mgr.detachTicker == nil {
+		return nil
+	}
+	return mgr.detachTicker.C
+}
+
+// isDetached returns true if the backend connection is released.
+func (mgr *BackendConnManager) isDetached() bool {
+	return mgr.detachedAddr.Load() != nil
+}
+
+// tryDetach releases the backend connection if the session idles outside transactions for long enough.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) tryDetach() {
+	if mgr.closeStatus.Load() != statusActive || mgr.redirectInfo.Load() != nil || mgr.isDetached() {
+		return
+	}
+	// The ticker may fire right after a command because it's reset concurrently.
+	idleTime := time.Since(time.Unix(0, mgr.lastCmdTime.Load()))
+	if idleTime < mgr.config.IdleDetachTimeout || !mgr.cmdProcessor.finishedTxn() {
+		return
+	}
+	mgr.detach()
+}
+
+// detach saves the session states and releases the backend connection.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) detach() {
+	backendIO := mgr.backendIO.Load()
+	// Some session states can not be saved, such as temporary tables. Those sessions keep the backend connections.
+	sessionStates, sessionToken, err := mgr.querySessionStates(backendIO)
+	if err != nil {
+		mgr.logger.Debug("query session states for detaching failed", zap.Error(err))
+		return
+	}
+	if err = mgr.updateAuthInfoFromSessionStates(hack.Slice(sessionStates)); err != nil {
+		mgr.logger.Debug("update auth info for detaching failed", zap.Error(err))
+		return
+	}
+	addr := mgr.ServerAddr()
+	mgr.detachedStates = sessionStates
+	mgr.killToken = sessionToken
+	// Store the address before releasing the connection so that ServerAddr() always returns the address.
+	mgr.detachedAddr.Store(&addr)
+	mgr.backendIO.Store(nil)
+	if ignoredErr := backendIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
+		mgr.logger.Warn("close detached backend connection failed", zap.Error(ignoredErr))
+	}
+	metrics.DetachedSessionGauge.Inc()
+	mgr.logger.Debug("detach the idle session", zap.String("backend_addr", addr))
+	// The ticker is reset when the next command arrives.
+	if mgr.detachTicker != nil {
+		mgr.detachTicker.Stop()
+	}
+}
+
+// attach restores the detached session on a new backend connection.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) attach() error {
+	addrPtr := mgr.detachedAddr.Load()
+	if addrPtr == nil {
+		return nil
+	}
+	addr := *addrPtr
+	backendIO, err := mgr.connectBackend(mgr.authenticator, addr)
+	if err != nil {
+		mgr.handshakeHandler.OnHandshake(mgr, addr, err)
+		// Even if the backend returns a MySQL error, the session can not continue.
+		return errors.Wrap(ErrBackendConn, err)
+	}
+	if err = mgr.authenticator.handshakeSecondTime(mgr.logger, mgr.clientIO, backendIO, mgr.backendTLS, mgr.killToken); err == nil {
+		err = mgr.initSessionStates(backendIO, mgr.detachedStates)
+	}
+	if err != nil {
+		mgr.handshakeHandler.OnHandshake(mgr, addr, err)
+		if ignoredErr := backendIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
+			mgr.logger.Error("close new backend connection failed", zap.Error(ignoredErr))
+		}
+		return errors.Wrap(ErrBackendConn, err)
+	}
+	mgr.backendIO.Store(backendIO)
+	mgr.detachedAddr.Store(nil)
+	mgr.detachedStates = ""
+	metrics.DetachedSessionGauge.Dec()
+	mgr.setKeepAlive(mgr.config.HealthyKeepAlive)
+	return nil
+}
@@ -8,13 +8,33 @@ import (
 )
 
 const (
-	connectErrMsg    = "No available TiDB instances, please check TiDB cluster"
-	parsePktErrMsg   = "TiProxy fails to parse the packet, please contact PingCAP"
-	handshakeErrMsg  = "TiProxy fails to connect to TiDB, please check network"
-	capabilityErrMsg = "Verify TiDB capability failed, please upgrade TiDB"
+	connectErrMsg         = "No available TiDB instances, please check TiDB cluster"
+	parsePktErrMsg        = "TiProxy fails to parse the packet, please contact PingCAP"
+	handshakeErrMsg       = "TiProxy fails to connect to TiDB, please check network"
+	capabilityErrMsg      = "Verify TiDB capability failed, please upgrade TiDB"
+	breakerErrMsg         = "TiProxy rejects new connections because connecting to TiDB keeps failing, please retry later"
+	evacuateErrMsg        = "TiProxy closes the session and rolls back the transaction because TiDB %s is drained or removed and the transaction doesn't finish within %s"
+	proxyAuthTLSErrMsg    = "TiProxy authenticates the user with the cleartext password, please connect with TLS"
+	proxyAuthChangeErrMsg = "TiProxy authenticates the user, changing the user is not supported"
+	attachErrMsg          = "TiProxy fails to restore the idle session on TiDB, please reconnect"
+	sqlTimeoutErrMsg      = "TiProxy closes the session because the statement exceeds the SQL timeout %s and can not be killed"
 )
 
 var (
 	ErrClientConn  = errors.New("this is an error from client")
 	ErrBackendConn = errors.New("this is an error from backend")
+	// ErrEvacuateTimeout is returned when the session is still in a transaction after the backend is drained or removed.
+	ErrEvacuateTimeout = errors.New("the transaction exceeds the evacuation deadline")
+	// ErrProxyAuthTLS is returned when the cleartext password of proxy auth would be sent without TLS.
+	ErrProxyAuthTLS = errors.New("proxy auth requires TLS")
+	// ErrProxyAuthChangeUser is returned when the client changes the user but it's authenticated by TiProxy.
+	ErrProxyAuthChangeUser = errors.New("proxy auth doesn't support changing the user")
+	// ErrSQLTimeout is returned when the statement exceeds the SQL timeout but can not be killed.
+	ErrSQLTimeout = errors.New("the statement exceeds the SQL timeout")
+	// ErrClientCertRejected is returned when the client certificate doesn't satisfy the namespace or the matched rule.
+	// The client receives an access denied error.
+	ErrClientCertRejected = errors.New("the client certificate is rejected")
+	// ErrClientAddrRejected is returned when the client address is denied by an access list.
+	// The client receives a host not privileged error.
+	ErrClientAddrRejected = errors.New("the client address is rejected")
 )
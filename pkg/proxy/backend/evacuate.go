@@ -0,0 +1,92 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"fmt"
+	"time"
+
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"go.uber.org/zap"
+)
+
+// NotifyEvacuating implements RedirectableConn.NotifyEvacuating interface.
+// The deadline starts when the backend starts being drained or removed and it doesn't restart when notified repeatedly.
+// If the session is still in a transaction after the deadline, it's closed so that it doesn't block scaling in.
+func (mgr *BackendConnManager) NotifyEvacuating(evacuating bool) {
+	timeout := mgr.config.EvacuateTxnTimeout
+	if timeout <= 0 {
+		return
+	}
+	mgr.evacuateLock.Lock()
+	defer mgr.evacuateLock.Unlock()
+	if !evacuating {
+		if mgr.evacuateTimer != nil {
+			mgr.evacuateTimer.Stop()
+			mgr.evacuateTimer = nil
+		}
+		mgr.evacuateDeadline = time.Time{}
+		return
+	}
+	if mgr.evacuateTimer != nil {
+		return
+	}
+	mgr.evacuateDeadline = time.Now().Add(timeout)
+	mgr.evacuateTimer = time.AfterFunc(timeout, func() {
+		// The session will be checked before executing the next command anyway, so it never blocks.
+		select {
+		case mgr.signalReceived <- signalTypeEvacuateTimeout:
+		default:
+		}
+	})
+}
+
+func (mgr *BackendConnManager) stopEvacuateTimer() {
+	mgr.evacuateLock.Lock()
+	defer mgr.evacuateLock.Unlock()
+	if mgr.evacuateTimer != nil {
+		mgr.evacuateTimer.Stop()
+		mgr.evacuateTimer = nil
+	}
+}
+
+// evacuateTimeout returns true if the session is still in a transaction after the evacuation deadline.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) evacuateTimeout() bool {
+	if mgr.cmdProcessor.finishedTxn() {
+		return false
+	}
+	mgr.evacuateLock.Lock()
+	defer mgr.evacuateLock.Unlock()
+	return !mgr.evacuateDeadline.IsZero() && !time.Now().Before(mgr.evacuateDeadline)
+}
+
+// writeEvacuateErr tells the client why the session is closed. Closing the backend connection
+// rolls back the transaction.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) writeEvacuateErr() {
+	addr := mgr.ServerAddr()
+	mgr.logger.Warn("the transaction exceeds the evacuation deadline, close the session", zap.String("backend_addr", addr),
+		zap.Duration("evacuate_txn_timeout", mgr.config.EvacuateTxnTimeout))
+	mgr.quitSource = SrcEvacuateTimeout
+	msg := fmt.Sprintf(evacuateErrMsg, addr, mgr.config.EvacuateTxnTimeout)
+	mgr.clientIO.WriteUserError(pnet.WrapUserError(ErrEvacuateTimeout, msg))
+}
+
+// tryEvacuateClose closes the idle session if it's still in a transaction after the evacuation deadline.
+// If a command is running, the session is closed before executing the next command.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) tryEvacuateClose() {
+	if mgr.closeStatus.Load() != statusActive || !mgr.evacuateTimeout() {
+		return
+	}
+	// The error packet is not a response to any command, so it starts a new sequence.
+	mgr.clientIO.ResetSequence()
+	mgr.writeEvacuateErr()
+	// Closing clientIO will cause the whole connection to be closed.
+	if err := mgr.clientIO.GracefulClose(); err != nil {
+		mgr.logger.Warn("graceful close client IO error", zap.Stringer("client_addr", mgr.clientIO.RemoteAddr()), zap.Error(err))
+	}
+	mgr.closeStatus.Store(statusClosing)
+}
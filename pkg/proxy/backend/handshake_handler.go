@@ -4,6 +4,8 @@
 package backend
 
 import (
+	"crypto/tls"
+
 	"github.com/pingcap/TiProxy/lib/util/errors"
 	"github.com/pingcap/TiProxy/pkg/manager/namespace"
 	"github.com/pingcap/TiProxy/pkg/manager/router"
@@ -16,8 +18,65 @@ type ConnContextKey string
 
 const (
 	ConnContextKeyTLSState ConnContextKey = "tls-state"
+	// ConnContextKeyBackendTLS is set by GetRouter to override the TLS config to connect to the backend.
+	ConnContextKeyBackendTLS ConnContextKey = "backend-tls"
+	// ConnContextKeyNamespace is set by GetRouter to the name of the chosen namespace.
+	ConnContextKeyNamespace ConnContextKey = "namespace"
+	// ConnContextKeyNamespaceMatch is set by GetRouter to explain why the namespace is chosen.
+	ConnContextKeyNamespaceMatch ConnContextKey = "namespace-match"
+	// ConnContextKeyBreaker is set by GetRouter if the namespace has a CircuitBreaker.
+	ConnContextKeyBreaker ConnContextKey = "breaker"
+	// ConnContextKeySQLTimeout is set by GetRouter to limit the duration of each statement.
+	ConnContextKeySQLTimeout ConnContextKey = "sql-timeout"
+	// ConnContextKeyBackendGroup is set by GetRouter if the matched rule pins the connection to a backend group.
+	ConnContextKeyBackendGroup ConnContextKey = "backend-group"
+	// ConnContextKeyHashKey is set by GetRouter if the namespace routes connections by consistent hashing.
+	ConnContextKeyHashKey ConnContextKey = "hash-key"
 )
 
+// CircuitBreaker rejects connecting to the backends when they keep failing.
+type CircuitBreaker interface {
+	// Allow is called before dialing a backend and returns an error if the breaker is open.
+	Allow() error
+	// Release is called if Allow returns nil but no backend is dialed.
+	Release()
+	// OnResult reports the result of dialing a backend.
+	OnResult(success bool)
+}
+
+// CredentialVerifier verifies the clients at TiProxy instead of the backends.
+type CredentialVerifier interface {
+	// Verify checks the password of the client and returns the service identity to log into the backends.
+	Verify(user, password string) (backendUser, backendPassword string, err error)
+}
+
+func getBreaker(cctx ConnContext) CircuitBreaker {
+	if breaker, ok := cctx.Value(ConnContextKeyBreaker).(CircuitBreaker); ok && breaker != nil {
+		return breaker
+	}
+	return nil
+}
+
+// getBackendTLS returns the TLS config set by GetRouter, or the default one if it's not set.
+func getBackendTLS(cctx ConnContext, defaultTLS *tls.Config) *tls.Config {
+	if backendTLS, ok := cctx.Value(ConnContextKeyBackendTLS).(*tls.Config); ok && backendTLS != nil {
+		return backendTLS
+	}
+	return defaultTLS
+}
+
+// getRouteHint returns the backend group and the hash key set by GetRouter.
+func getRouteHint(cctx ConnContext) router.RouteHint {
+	var hint router.RouteHint
+	if group, ok := cctx.Value(ConnContextKeyBackendGroup).(string); ok {
+		hint.Group = group
+	}
+	if key, ok := cctx.Value(ConnContextKeyHashKey).(string); ok {
+		hint.Key = key
+	}
+	return hint
+}
+
 type ErrorSource int
 
 const (
@@ -33,6 +92,10 @@ const (
 	SrcBackendQuit
 	// SrcBackendErr is reserved
 	SrcBackendErr
+	// SrcAdminClose includes: closed through the admin API
+	SrcAdminClose
+	// SrcEvacuateTimeout includes: in a transaction for too long after the backend is drained or removed
+	SrcEvacuateTimeout
 )
 
 func (es ErrorSource) String() string {
@@ -49,6 +112,10 @@ func (es ErrorSource) String() string {
 		return "backend quit"
 	case SrcBackendErr:
 		return "backend error"
+	case SrcAdminClose:
+		return "admin close"
+	case SrcEvacuateTimeout:
+		return "evacuate timeout"
 	}
 	return "unknown"
 }
@@ -75,17 +142,21 @@ type HandshakeHandler interface {
 	OnTraffic(ctx ConnContext)
 	GetCapability() pnet.Capability
 	GetServerVersion() string
+	// GetCredentialVerifier returns nil if the clients are verified by the backends.
+	GetCredentialVerifier() CredentialVerifier
 }
 
 type DefaultHandshakeHandler struct {
 	nsManager     *namespace.NamespaceManager
 	serverVersion string
+	verifier      CredentialVerifier
 }
 
-func NewDefaultHandshakeHandler(nsManager *namespace.NamespaceManager, serverVersion string) *DefaultHandshakeHandler {
+func NewDefaultHandshakeHandler(nsManager *namespace.NamespaceManager, serverVersion string, verifier CredentialVerifier) *DefaultHandshakeHandler {
 	return &DefaultHandshakeHandler{
 		nsManager:     nsManager,
 		serverVersion: serverVersion,
+		verifier:      verifier,
 	}
 }
 
@@ -94,14 +165,46 @@ func (handler *DefaultHandshakeHandler) HandleHandshakeResp(ConnContext, *pnet.H
 }
 
 func (handler *DefaultHandshakeHandler) GetRouter(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
-	ns, ok := handler.nsManager.GetNamespaceByUser(resp.User)
-	if !ok {
-		ns, ok = handler.nsManager.GetNamespace("default")
+	info := &namespace.MatchInfo{
+		User:       resp.User,
+		DB:         resp.DB,
+		ClientAddr: ctx.ClientAddr(),
+		Attrs:      resp.Attrs,
+	}
+	if state, ok := ctx.Value(ConnContextKeyTLSState).(tls.ConnectionState); ok {
+		info.SNI = state.ServerName
+		if len(state.PeerCertificates) > 0 {
+			info.SetClientCert(state.PeerCertificates[0])
+		}
 	}
+	ns, result, ok := handler.nsManager.MatchNamespace(info)
 	if !ok {
 		return nil, errors.New("failed to find a namespace")
 	}
-	ctx.UpdateLogger(zap.String("ns", ns.Name()))
+	ctx.SetValue(ConnContextKeyNamespace, ns.Name())
+	ctx.SetValue(ConnContextKeyNamespaceMatch, result)
+	ctx.UpdateLogger(zap.String("ns", ns.Name()), zap.Stringer("ns_match", result))
+	if err := ns.CheckClientAddr(info.ClientAddr); err != nil {
+		return nil, errors.Wrap(ErrClientAddrRejected, err)
+	}
+	if err := ns.CheckClientCert(info, result); err != nil {
+		return nil, errors.Wrap(ErrClientCertRejected, err)
+	}
+	if backendTLS := ns.BackendTLS(); backendTLS != nil {
+		ctx.SetValue(ConnContextKeyBackendTLS, backendTLS)
+	}
+	if breaker := ns.Breaker(); breaker != nil {
+		ctx.SetValue(ConnContextKeyBreaker, breaker)
+	}
+	if timeout := ns.SQLTimeout(resp.User); timeout > 0 {
+		ctx.SetValue(ConnContextKeySQLTimeout, timeout)
+	}
+	if result.Rule != nil && len(result.Rule.Group) > 0 {
+		ctx.SetValue(ConnContextKeyBackendGroup, result.Rule.Group)
+	}
+	if key := ns.HashKey(info); len(key) > 0 {
+		ctx.SetValue(ConnContextKeyHashKey, key)
+	}
 	return ns.GetRouter(), nil
 }
 
@@ -135,6 +238,10 @@ func (handler *DefaultHandshakeHandler) GetServerVersion() string {
 	return pnet.ServerVersion
 }
 
+func (handler *DefaultHandshakeHandler) GetCredentialVerifier() CredentialVerifier {
+	return handler.verifier
+}
+
 type CustomHandshakeHandler struct {
 	getRouter           func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error)
 	onHandshake         func(ConnContext, string, error)
@@ -143,6 +250,7 @@ type CustomHandshakeHandler struct {
 	handleHandshakeResp func(ctx ConnContext, resp *pnet.HandshakeResp) error
 	getCapability       func() pnet.Capability
 	getServerVersion    func() string
+	verifier            CredentialVerifier
 }
 
 func (h *CustomHandshakeHandler) GetRouter(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
@@ -191,3 +299,7 @@ func (h *CustomHandshakeHandler) GetServerVersion() string {
 	}
 	return pnet.ServerVersion
 }
+
+func (h *CustomHandshakeHandler) GetCredentialVerifier() CredentialVerifier {
+	return h.verifier
+}
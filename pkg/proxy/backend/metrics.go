@@ -33,3 +33,27 @@ func addGetBackendMetrics(duration time.Duration, succeed bool) {
 	}
 	metrics.GetBackendCounter.WithLabelValues(lbl).Inc()
 }
+
+func addSQLTimeoutMetrics(addr string, killed bool) {
+	lbl := "succeed"
+	if !killed {
+		lbl = "fail"
+	}
+	metrics.QueryTimeoutCounter.WithLabelValues(addr, lbl).Inc()
+}
+
+func addBackendPoolMetrics(addr string, hit bool) {
+	lbl := "hit"
+	if !hit {
+		lbl = "miss"
+	}
+	metrics.BackendPoolCounter.WithLabelValues(addr, lbl).Inc()
+}
+
+func readBackendPoolCounter(addr string, hit bool) (int, error) {
+	lbl := "hit"
+	if !hit {
+		lbl = "miss"
+	}
+	return metrics.ReadCounter(metrics.BackendPoolCounter.WithLabelValues(addr, lbl))
+}
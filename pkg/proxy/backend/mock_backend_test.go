@@ -4,6 +4,7 @@
 package backend
 
 import (
+	"crypto/rsa"
 	"crypto/tls"
 	"encoding/binary"
 
@@ -14,6 +15,7 @@ import (
 
 type backendConfig struct {
 	tlsConfig     *tls.Config
+	rsaKey        *rsa.PrivateKey
 	authPlugin    string
 	sessionStates string
 	salt          []byte
@@ -26,13 +28,16 @@ type backendConfig struct {
 	capability    pnet.Capability
 	status        uint16
 	authSucceed   bool
-	abnormalExit  bool
+	// fastAuth makes caching_sha2_password skip full authentication.
+	fastAuth     bool
+	abnormalExit bool
 }
 
 func newBackendConfig() *backendConfig {
 	return &backendConfig{
 		capability:    defaultTestBackendCapability,
 		salt:          mockSalt,
+		rsaKey:        mockBackendRSAKey,
 		authPlugin:    pnet.AuthCachingSha2Password,
 		authSucceed:   true,
 		loops:         1,
@@ -108,12 +113,24 @@ func (mb *mockBackend) verifyPassword(packetIO *pnet.PacketIO, resp *pnet.Handsh
 		}
 		switch mb.authPlugin {
 		case mysql.AuthCachingSha2Password:
+			if mb.fastAuth {
+				if err = packetIO.WritePacket([]byte{pnet.ShaCommand, pnet.FastAuthSuccess}, true); err != nil {
+					return err
+				}
+				break
+			}
 			if err = packetIO.WriteShaCommand(); err != nil {
 				return err
 			}
 			if mb.authData, err = packetIO.ReadPacket(); err != nil {
 				return err
 			}
+			// Without TLS, the password is encrypted with the public key.
+			if !packetIO.TLSConnectionState().HandshakeComplete {
+				if mb.authData, err = mb.readEncryptedPassword(packetIO, mb.authData); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	if mb.authSucceed {
@@ -128,6 +145,22 @@ func (mb *mockBackend) verifyPassword(packetIO *pnet.PacketIO, resp *pnet.Handsh
 	return nil
 }
 
+func (mb *mockBackend) readEncryptedPassword(packetIO *pnet.PacketIO, pkt []byte) ([]byte, error) {
+	if len(pkt) == 1 && pkt[0] == pnet.RequestPublicKey {
+		pubKey, err := pnet.MarshalPublicKey(&mb.rsaKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if err = packetIO.WritePacket(append([]byte{pnet.ShaCommand}, pubKey...), true); err != nil {
+			return nil, err
+		}
+		if pkt, err = packetIO.ReadPacket(); err != nil {
+			return nil, err
+		}
+	}
+	return pnet.DecryptPassword(pkt, mb.salt, mb.rsaKey)
+}
+
 func (mb *mockBackend) respond(packetIO *pnet.PacketIO) error {
 	if mb.abnormalExit {
 		return packetIO.Close()
@@ -49,9 +49,10 @@ type mockClient struct {
 	// Inputs that assigned by the test and will be sent to the server.
 	*clientConfig
 	// Outputs that received from the server and will be checked by the test.
-	authSucceed   bool
-	mysqlErr      error
-	serverVersion string
+	authSucceed      bool
+	mysqlErr         error
+	serverVersion    string
+	serverAuthPlugin string
 }
 
 func newMockClient(cfg *clientConfig) *mockClient {
@@ -71,6 +72,8 @@ func (mc *mockClient) authenticate(packetIO *pnet.PacketIO) error {
 	serverCap, serverVersion := pnet.ParseInitialHandshake(pkt)
 	mc.capability = mc.capability & serverCap
 	mc.serverVersion = serverVersion
+	salt, authPlugin := pnet.ParseInitialHandshakeAuth(pkt)
+	mc.serverAuthPlugin = authPlugin
 
 	resp := &pnet.HandshakeResp{
 		User:       mc.username,
@@ -93,10 +96,10 @@ func (mc *mockClient) authenticate(packetIO *pnet.PacketIO) error {
 	if err := packetIO.WritePacket(pkt, true); err != nil {
 		return err
 	}
-	return mc.writePassword(packetIO)
+	return mc.writePassword(packetIO, salt)
 }
 
-func (mc *mockClient) writePassword(packetIO *pnet.PacketIO) error {
+func (mc *mockClient) writePassword(packetIO *pnet.PacketIO, salt []byte) error {
 	for {
 		serverPkt, err := packetIO.ReadPacket()
 		if err != nil {
@@ -110,14 +113,45 @@ func (mc *mockClient) writePassword(packetIO *pnet.PacketIO) error {
 			mc.authSucceed = false
 			mc.mysqlErr = pnet.ParseErrorPacket(serverPkt)
 			return nil
-		case mysql.AuthSwitchRequest, pnet.ShaCommand:
+		case mysql.AuthSwitchRequest:
+			_, salt = pnet.ParseAuthSwitchRequest(serverPkt)
 			if err := packetIO.WritePacket(mc.authData, true); err != nil {
 				return err
 			}
+		case pnet.ShaCommand:
+			if len(serverPkt) > 1 && serverPkt[1] == pnet.FastAuthSuccess {
+				continue
+			}
+			if err := mc.writeSHA2Password(packetIO, salt); err != nil {
+				return err
+			}
 		}
 	}
 }
 
+// writeSHA2Password sends the password in cleartext over TLS, or otherwise encrypts it with the public key of the server.
+func (mc *mockClient) writeSHA2Password(packetIO *pnet.PacketIO, salt []byte) error {
+	if packetIO.TLSConnectionState().HandshakeComplete {
+		return packetIO.WritePacket(mc.authData, true)
+	}
+	if err := packetIO.WritePacket([]byte{pnet.RequestPublicKey}, true); err != nil {
+		return err
+	}
+	pkt, err := packetIO.ReadPacket()
+	if err != nil {
+		return err
+	}
+	pubKey, err := pnet.ParsePublicKey(pkt[1:])
+	if err != nil {
+		return err
+	}
+	data, err := pnet.EncryptPassword(mc.authData, salt, pubKey)
+	if err != nil {
+		return err
+	}
+	return packetIO.WritePacket(data, true)
+}
+
 // request sends commands except prepared statements commands.
 func (mc *mockClient) request(packetIO *pnet.PacketIO) error {
 	if mc.abnormalExit {
@@ -164,7 +198,7 @@ func (mc *mockClient) request(packetIO *pnet.PacketIO) error {
 }
 
 func (mc *mockClient) requestChangeUser(packetIO *pnet.PacketIO) error {
-	data := pnet.MakeChangeUser(mc.username, mc.dbName, mysql.AuthNativePassword, mc.authData)
+	data := pnet.MakeChangeUser(mc.username, mc.dbName, mysql.AuthNativePassword, mc.authData, mc.attrs)
 	if err := packetIO.WritePacket(data, true); err != nil {
 		return err
 	}
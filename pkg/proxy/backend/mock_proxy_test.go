@@ -4,12 +4,14 @@
 package backend
 
 import (
+	"crypto/rsa"
 	"crypto/tls"
 	"testing"
 	"time"
 
 	gomysql "github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/pingcap/TiProxy/lib/util/logger"
+	"github.com/pingcap/TiProxy/pkg/manager/namespace"
 	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
 	"go.uber.org/zap"
 )
@@ -18,15 +20,19 @@ type proxyConfig struct {
 	frontendTLSConfig    *tls.Config
 	backendTLSConfig     *tls.Config
 	handler              *CustomHandshakeHandler
+	rsaKey               *rsa.PrivateKey
+	authPlugin           string
 	checkBackendInterval time.Duration
 	sessionToken         string
 	capability           pnet.Capability
 	waitRedirect         bool
+	accessFilter         *namespace.AccessFilter
 }
 
 func newProxyConfig() *proxyConfig {
 	return &proxyConfig{
 		handler:              &CustomHandshakeHandler{},
+		rsaKey:               mockProxyRSAKey,
 		capability:           defaultTestBackendCapability,
 		sessionToken:         mockToken,
 		checkBackendInterval: CheckBackendInterval,
@@ -52,6 +58,9 @@ func newMockProxy(t *testing.T, cfg *proxyConfig) *mockProxy {
 		logger:      lg.Named("mockProxy"),
 		BackendConnManager: NewBackendConnManager(lg, cfg.handler, 0, &BCConfig{
 			CheckBackendInterval: cfg.checkBackendInterval,
+			DefaultAuthPlugin:    cfg.authPlugin,
+			AuthRSAKey:           cfg.rsaKey,
+			AccessFilter:         cfg.accessFilter,
 		}),
 	}
 	mp.cmdProcessor.capability = cfg.capability
@@ -0,0 +1,104 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"crypto/tls"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"github.com/pingcap/tidb/parser/mysql"
+)
+
+// Proxy auth verifies the clients at TiProxy before connecting to the backends.
+// The verifiers need the cleartext password, so the client must connect with TLS and send the password with
+// mysql_clear_password. After the client is verified, TiProxy logs into the backend as the mapped service identity
+// instead of forwarding the auth data. Session migration uses session tokens as usual.
+
+// verifyClient reads the cleartext password from the client and verifies it.
+// It returns the service identity to log into the backend.
+func (auth *Authenticator) verifyClient(clientIO *pnet.PacketIO, resp *pnet.HandshakeResp, verifier CredentialVerifier,
+	isSSL bool) (backendUser, backendPassword string, err error) {
+	if !isSSL {
+		return "", "", pnet.WrapUserError(ErrProxyAuthTLS, proxyAuthTLSErrMsg)
+	}
+	password := resp.AuthData
+	if resp.AuthPlugin != pnet.AuthMySQLClearPassword {
+		if err = clientIO.WriteSwitchRequest(pnet.AuthMySQLClearPassword, nil); err != nil {
+			return "", "", err
+		}
+		if password, err = clientIO.ReadPacket(); err != nil {
+			return "", "", err
+		}
+	}
+	password = bytes.TrimSuffix(password, []byte{0})
+	if backendUser, backendPassword, err = verifier.Verify(resp.User, string(password)); err != nil {
+		return "", "", writeAccessDenied(clientIO, resp.User)
+	}
+	return backendUser, backendPassword, nil
+}
+
+// loginBackend logs into the backend with the password of the service identity and forwards the result to the client.
+func (auth *Authenticator) loginBackend(clientIO, backendIO *pnet.PacketIO, backendTLSConfig *tls.Config,
+	backendCapability pnet.Capability, tlsUpgraded bool, serverPkt []byte, password string) error {
+	salt, authPlugin := pnet.ParseInitialHandshakeAuth(serverPkt)
+	authData, err := pnet.ScramblePassword(authPlugin, salt, password)
+	if err != nil {
+		// The backend switches to the auth plugin of the user anyway.
+		authPlugin = pnet.AuthNativePassword
+		if authData, err = pnet.ScramblePassword(authPlugin, salt, password); err != nil {
+			return err
+		}
+	}
+	if err = auth.writeAuthHandshake(backendIO, backendTLSConfig, backendCapability, tlsUpgraded,
+		authPlugin, authData, pnet.ClientPluginAuth); err != nil {
+		return pnet.WrapUserError(err, handshakeErrMsg)
+	}
+
+	for {
+		pkt, err := backendIO.ReadPacket()
+		if err != nil {
+			return err
+		}
+		switch pkt[0] {
+		case mysql.OKHeader:
+			return clientIO.WritePacket(pkt, true)
+		case mysql.ErrHeader:
+			// The service identity is misconfigured. The client sees the error as if it fails to log in.
+			if err = clientIO.WritePacket(pkt, true); err != nil {
+				return err
+			}
+			return pnet.ParseErrorPacket(pkt)
+		case mysql.AuthSwitchRequest:
+			authPlugin, salt = pnet.ParseAuthSwitchRequest(pkt)
+			if authData, err = pnet.ScramblePassword(authPlugin, salt, password); err != nil {
+				return pnet.WrapUserError(err, err.Error())
+			}
+			if err = backendIO.WritePacket(authData, true); err != nil {
+				return err
+			}
+		case pnet.ShaCommand:
+			if len(pkt) < 2 {
+				return errors.WithStack(mysql.ErrMalformPacket)
+			}
+			switch pkt[1] {
+			case pnet.FastAuthSuccess:
+				// An OK packet follows.
+			case pnet.FastAuthFail:
+				// Full authentication sends the cleartext password, which is only allowed over TLS.
+				if !backendIO.TLSConnectionState().HandshakeComplete {
+					return pnet.WrapUserError(ErrProxyAuthTLS, proxyAuthTLSErrMsg)
+				}
+				if err = backendIO.WritePacket(append([]byte(password), 0), true); err != nil {
+					return err
+				}
+			default:
+				return errors.Errorf("read unexpected caching_sha2_password status: %#x", pkt[1])
+			}
+		default:
+			return errors.Errorf("read unexpected command: %#x", pkt[0])
+		}
+	}
+}
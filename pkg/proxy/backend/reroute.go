@@ -0,0 +1,165 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"go.uber.org/zap"
+)
+
+// COM_CHANGE_USER may switch to a user of another namespace, e.g. when a connection pool is shared by tenants.
+// The namespace is chosen again for the new user. If it changes, the session can not stay on the current backend,
+// so TiProxy logs into a backend of the new namespace as the new user and forwards the auth switch requests of the
+// backend to the client, in the same way as forwarding COM_CHANGE_USER. Then the connection moves from the old router
+// to the new one. If logging in fails, the session stays on the current backend as the previous user, and the
+// client receives the error.
+
+// routeCtxKeys are the context values that GetRouter sets.
+var routeCtxKeys = []ConnContextKey{
+	ConnContextKeyBackendTLS,
+	ConnContextKeyNamespace,
+	ConnContextKeyNamespaceMatch,
+	ConnContextKeyBreaker,
+	ConnContextKeySQLTimeout,
+	ConnContextKeyBackendGroup,
+	ConnContextKeyHashKey,
+}
+
+// clearRouteValues removes the context values of the current namespace and returns them.
+func (mgr *BackendConnManager) clearRouteValues() map[ConnContextKey]any {
+	values := make(map[ConnContextKey]any, len(routeCtxKeys))
+	for _, key := range routeCtxKeys {
+		if val, ok := mgr.ctxmap.LoadAndDelete(key); ok {
+			values[key] = val
+		}
+	}
+	return values
+}
+
+// restoreRouteValues restores the context values returned by clearRouteValues.
+func (mgr *BackendConnManager) restoreRouteValues(values map[ConnContextKey]any) {
+	for _, key := range routeCtxKeys {
+		if val, ok := values[key]; ok {
+			mgr.ctxmap.Store(key, val)
+		} else {
+			mgr.ctxmap.Delete(key)
+		}
+	}
+}
+
+// rerouteChangeUser moves the session to another namespace if the new user of COM_CHANGE_USER belongs to it.
+// It returns false if the namespace doesn't change, and then the command is forwarded to the current backend.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) rerouteChangeUser(request []byte) (bool, error) {
+	auth := mgr.authenticator
+	username, db, attrs := pnet.ParseChangeUser(request, auth.capability)
+	if attrs == nil {
+		attrs = auth.attrs
+	}
+	resp := &pnet.HandshakeResp{
+		User:       username,
+		DB:         db,
+		Attrs:      attrs,
+		Capability: auth.capability,
+		Collation:  auth.collation,
+	}
+	prevNamespace, prevLogger := mgr.Value(ConnContextKeyNamespace), mgr.logger
+	prevValues := mgr.clearRouteValues()
+	r, err := mgr.handshakeHandler.GetRouter(mgr, resp)
+	if err != nil || mgr.Value(ConnContextKeyNamespace) == prevNamespace {
+		mgr.restoreRouteValues(prevValues)
+		mgr.logger = prevLogger
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrClientCertRejected):
+			mgr.logger.Info("reject the client certificate", zap.Error(err))
+			return true, writeAccessDenied(mgr.clientIO, username)
+		case errors.Is(err, ErrClientAddrRejected):
+			mgr.logger.Info("reject the client address", zap.Error(err))
+			return true, writeHostNotPrivileged(mgr.clientIO)
+		}
+		mgr.logger.Warn("route the new user failed", zap.String("user", username), zap.Error(err))
+		mgr.clientIO.WriteUserError(pnet.WrapUserError(err, err.Error()))
+		return true, nil
+	}
+	if mgr.Value(ConnContextKeyNamespace) == prevNamespace {
+		return false, nil
+	}
+
+	prevAddr, prevIO, prevReceiver := mgr.ServerAddr(), mgr.backendIO.Load(), mgr.getEventReceiver()
+	prevBackendTLS, prevConnID := mgr.backendTLS, auth.backendConnID
+	prevUser, prevDB, prevAttrs := auth.user, auth.dbname, auth.attrs
+	// Do not redirect to the old router during rerouting.
+	pendingSignal := mgr.redirectInfo.Swap(nil)
+
+	auth.changeUser(username, db, attrs)
+	mgr.backendTLS = mgr.proxyBackendTLS
+	backendIO, err := mgr.connectRouter(mgr, r, auth, 15*time.Second)
+	if err != nil {
+		err = pnet.WrapUserError(err, connectErrMsg)
+	} else if err = auth.handshakeChangeUser(mgr.logger, mgr.clientIO, backendIO, mgr.backendTLS); err == nil {
+		err = mgr.cmdProcessor.forwardChangeUserCmd(mgr.clientIO, backendIO, request)
+	}
+	if err != nil {
+		if backendIO != nil {
+			addr := backendIO.RemoteAddr().String()
+			mgr.handshakeHandler.OnHandshake(mgr, addr, err)
+			if ignoredErr := r.OnConnClosed(addr, mgr); ignoredErr != nil {
+				mgr.logger.Error("close connection error", zap.String("backend_addr", addr), zap.NamedError("notify_err", ignoredErr))
+			}
+			if ignoredErr := backendIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
+				mgr.logger.Error("close new backend connection failed", zap.Error(ignoredErr))
+			}
+		}
+		mgr.backendIO.Store(prevIO)
+		mgr.SetEventReceiver(prevReceiver)
+		mgr.backendTLS, auth.backendConnID = prevBackendTLS, prevConnID
+		auth.user, auth.dbname, auth.attrs = prevUser, prevDB, prevAttrs
+		mgr.restoreRouteValues(prevValues)
+		mgr.logger = prevLogger
+		// It's unknown which router sends the redirection signals during rerouting, so restore the pending one.
+		mgr.redirectInfo.Store(pendingSignal)
+		mgr.resetQuitSource()
+		// The MySQL error is already forwarded to the client.
+		if IsMySQLError(err) || errors.Is(err, ErrClientConn) {
+			return true, err
+		}
+		err = pnet.WrapUserError(err, handshakeErrMsg)
+		mgr.clientIO.WriteUserError(err)
+		// The session still works on the previous backend.
+		mgr.logger.Warn("route the session to another namespace failed", zap.String("user", username), zap.Error(err))
+		return true, nil
+	}
+
+	// The router may not set itself as the event receiver, e.g. the static router.
+	mgr.SetEventReceiver(r)
+	if prevReceiver != nil {
+		rs := &redirectResult{from: prevAddr, receiver: prevReceiver, leave: true}
+		// The pending redirection can not be done after the connection leaves the router.
+		if pendingSignal != nil {
+			rs.to = pendingSignal.newAddr
+		}
+		// Notify the old router synchronously after the previous redirection results so that it stops
+		// redirecting the connection before the session runs on the new backend.
+		mgr.addRedirectResult(rs)
+		mgr.notifyRedirectResults(context.Background())
+	}
+	// It's unknown which router sends the redirection signals during rerouting, so just drop them.
+	mgr.redirectInfo.Store(nil)
+	if ignoredErr := prevIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
+		mgr.logger.Error("close previous backend connection failed", zap.Error(ignoredErr))
+	}
+	// The kill token belongs to the previous session.
+	mgr.initKillToken(backendIO)
+	mgr.resetQuitSource()
+	mgr.handshakeHandler.OnHandshake(mgr, mgr.ServerAddr(), nil)
+	mgr.logger.Info("route the session to another namespace", zap.Any("from", prevNamespace), zap.String("from_addr", prevAddr),
+		zap.String("user", username))
+	return true, nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"go.uber.org/zap"
+)
+
+const (
+	sqlKillQuery = "KILL QUERY %d"
+	// The session token expires after a while in TiDB, so it's refreshed before it expires.
+	killTokenRefreshInterval = 30 * time.Second
+	killQueryTimeout         = 5 * time.Second
+)
+
+// needSQLTimeout returns true if the command executes statements.
+func needSQLTimeout(cmd pnet.Command) bool {
+	switch cmd {
+	case pnet.ComQuery, pnet.ComStmtExecute, pnet.ComStmtFetch:
+		return true
+	}
+	return false
+}
+
+func (mgr *BackendConnManager) sqlTimeout() time.Duration {
+	if timeout, ok := mgr.Value(ConnContextKeySQLTimeout).(time.Duration); ok {
+		return timeout
+	}
+	return 0
+}
+
+// queryKiller kills the running statement on the backend once the timer fires.
+type queryKiller struct {
+	timer *time.Timer
+	done  chan struct{}
+	err   error
+}
+
+// stop returns true if the statement has been killed. It waits for the killing to finish so that
+// the killing result can be reported.
+func (k *queryKiller) stop() (bool, error) {
+	if k.timer.Stop() {
+		return false, nil
+	}
+	<-k.done
+	return true, k.err
+}
+
+// executeCmd executes the command and kills the statement if it exceeds the SQL timeout.
+// The backend returns an error to the client after the statement is killed and the connection is still usable.
+// If the statement can't be killed, e.g. the session has no token, the backend connection is closed to stop it,
+// and then the client receives an error and the session is closed.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) executeCmd(request []byte, waitingRedirect bool) (holdRequest bool, err error) {
+	backendIO := mgr.backendIO.Load()
+	timeout := mgr.sqlTimeout()
+	if timeout <= 0 || !needSQLTimeout(pnet.Command(request[0])) {
+		return mgr.cmdProcessor.executeCmd(request, mgr.clientIO, backendIO, waitingRedirect)
+	}
+
+	// The timer runs in another goroutine, so it only reads the copied states.
+	login, connID := mgr.newTokenLogin(), mgr.authenticator.backendConnID
+	killer := &queryKiller{done: make(chan struct{})}
+	killer.timer = time.AfterFunc(timeout, func() {
+		defer close(killer.done)
+		if killer.err = login.killQuery(connID); killer.err != nil {
+			_ = backendIO.Close()
+		}
+	})
+	holdRequest, err = mgr.cmdProcessor.executeCmd(request, mgr.clientIO, backendIO, waitingRedirect)
+	if killed, killErr := killer.stop(); killed {
+		addr := backendIO.RemoteAddr().String()
+		addSQLTimeoutMetrics(addr, killErr == nil)
+		mgr.logger.Warn("statement exceeds the sql timeout", zap.Duration("timeout", timeout), zap.String("backend_addr", addr),
+			zap.Stringer("cmd", pnet.Command(request[0])), zap.NamedError("kill_err", killErr))
+		if killErr != nil {
+			err = pnet.WrapUserError(errors.Wrap(ErrSQLTimeout, killErr), fmt.Sprintf(sqlTimeoutErrMsg, timeout))
+			mgr.clientIO.WriteUserError(err)
+		}
+	}
+	return
+}
+
+// initKillToken queries the session token right after logging in, before the client executes any statement.
+// The token is used to connect to the backend to kill the running statement.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) initKillToken(backendIO *pnet.PacketIO) {
+	mgr.killToken = ""
+	if mgr.sqlTimeout() <= 0 {
+		return
+	}
+	_, sessionToken, err := mgr.querySessionStates(backendIO)
+	if err != nil {
+		mgr.logger.Debug("query session token failed", zap.Error(err))
+		return
+	}
+	mgr.killToken = sessionToken
+}
+
+// refreshKillToken replaces the session token before it expires. It logs into the backend with the current token
+// on another connection and queries a new one there, so that no statement is executed in the client session.
+// Only the sessions that enable the SQL timeout or are detached need the token.
+func (mgr *BackendConnManager) refreshKillToken() {
+	mgr.processLock.Lock()
+	var login *tokenLogin
+	if mgr.closeStatus.Load() == statusActive && (mgr.sqlTimeout() > 0 || mgr.isDetached()) {
+		login = mgr.newTokenLogin()
+	}
+	mgr.processLock.Unlock()
+	if login == nil {
+		return
+	}
+	sessionToken, err := login.queryToken()
+	if err != nil {
+		mgr.logger.Debug("refresh session token failed", zap.Error(err))
+		return
+	}
+	mgr.processLock.Lock()
+	// The token may be replaced during refreshing, e.g. the session is redirected.
+	if mgr.killToken == login.token {
+		mgr.killToken = sessionToken
+	}
+	mgr.processLock.Unlock()
+}
+
+// tokenLogin logs into the backend with the session token on a new connection.
+// It copies the states of the session so that it can be used without holding processLock.
+type tokenLogin struct {
+	auth       Authenticator
+	logger     *zap.Logger
+	clientIO   *pnet.PacketIO
+	backendTLS *tls.Config
+	addr       string
+	token      string
+}
+
+// newTokenLogin returns nil if the session has no token.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) newTokenLogin() *tokenLogin {
+	if len(mgr.killToken) == 0 {
+		return nil
+	}
+	return &tokenLogin{
+		auth:       *mgr.authenticator,
+		logger:     mgr.logger,
+		clientIO:   mgr.clientIO,
+		backendTLS: mgr.backendTLS,
+		addr:       mgr.ServerAddr(),
+		token:      mgr.killToken,
+	}
+}
+
+// connect logs into the backend. It can be called only once because the handshake updates the copied authenticator.
+func (tl *tokenLogin) connect() (*pnet.PacketIO, *CmdProcessor, error) {
+	cn, err := net.DialTimeout("tcp", tl.addr, DialTimeout)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "dial backend %s error", tl.addr)
+	}
+	if err = cn.SetDeadline(time.Now().Add(killQueryTimeout)); err != nil {
+		_ = cn.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+	backendIO := pnet.NewPacketIO(cn, tl.logger, pnet.WithRemoteAddr(tl.addr, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn))
+	if err = tl.auth.handshakeSecondTime(tl.logger, tl.clientIO, backendIO, tl.backendTLS, tl.token); err != nil {
+		_ = backendIO.Close()
+		return nil, nil, err
+	}
+	// Use another CmdProcessor to avoid updating the status of the session.
+	cp := NewCmdProcessor()
+	cp.capability = tl.auth.capability
+	return backendIO, cp, nil
+}
+
+// killQuery kills the running statement of the backend connection.
+func (tl *tokenLogin) killQuery(connID uint64) error {
+	if tl == nil || connID == 0 {
+		return errors.New("no session token or connection ID to kill the statement")
+	}
+	backendIO, cp, err := tl.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = backendIO.Close()
+	}()
+	_, _, err = cp.query(backendIO, fmt.Sprintf(sqlKillQuery, connID))
+	return err
+}
+
+// queryToken queries a new session token.
+func (tl *tokenLogin) queryToken() (string, error) {
+	backendIO, cp, err := tl.connect()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = backendIO.Close()
+	}()
+	result, _, err := cp.query(backendIO, sqlQueryState)
+	if err != nil {
+		return "", err
+	}
+	return result.GetStringByName(0, sessionTokenCol)
+}
@@ -4,6 +4,8 @@
 package backend
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"fmt"
 	"strings"
 	"testing"
@@ -41,8 +43,18 @@ var (
 	mockCmdInt        = 100
 	mockCmdBytes      = []byte("01234567890123456789")
 	mockSessionStates = "{\"current-db\":\"test_db\"}"
+	mockProxyRSAKey   = generateRSAKey()
+	mockBackendRSAKey = generateRSAKey()
 )
 
+func generateRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
 type testConfig struct {
 	clientConfig    *clientConfig
 	proxyConfig     *proxyConfig
@@ -148,7 +160,7 @@ func (ts *testSuite) changeDB(db string) {
 func (ts *testSuite) changeUser(username, db string) {
 	ts.mc.username = username
 	ts.mc.dbName = db
-	ts.mp.authenticator.changeUser(username, db)
+	ts.mp.authenticator.changeUser(username, db, nil)
 }
 
 func (ts *testSuite) runAndCheck(t *testing.T, c checker, clientRunner, backendRunner func(*pnet.PacketIO) error,
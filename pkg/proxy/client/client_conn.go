@@ -20,6 +20,7 @@ type ClientConnection struct {
 	backendTLSConfig  *tls.Config    // the TLS config to connect to TiDB server.
 	pkt               *pnet.PacketIO // a helper to read and write data in packet format.
 	connMgr           *backend.BackendConnManager
+	done              chan struct{} // closed after Run() returns.
 }
 
 func NewClientConnection(logger *zap.Logger, conn net.Conn, frontendTLSConfig *tls.Config, backendTLSConfig *tls.Config,
@@ -37,12 +38,14 @@ func NewClientConnection(logger *zap.Logger, conn net.Conn, frontendTLSConfig *t
 		backendTLSConfig:  backendTLSConfig,
 		pkt:               pkt,
 		connMgr:           bemgr,
+		done:              make(chan struct{}),
 	}
 }
 
 func (cc *ClientConnection) Run(ctx context.Context) {
 	var err error
 	var msg string
+	defer close(cc.done)
 
 	if err = cc.connMgr.Connect(ctx, cc.pkt, cc.frontendTLSConfig, cc.backendTLSConfig); err != nil {
 		msg = "new connection failed"
@@ -79,6 +82,33 @@ func (cc *ClientConnection) processMsg(ctx context.Context) error {
 	}
 }
 
+// ConnInfo returns the snapshot of the connection.
+func (cc *ClientConnection) ConnInfo() *backend.ConnInfo {
+	return cc.connMgr.ConnInfo()
+}
+
+// Done returns a channel that's closed after the connection stops running.
+func (cc *ClientConnection) Done() <-chan struct{} {
+	return cc.done
+}
+
+// QuitSource returns the reason why the connection is closed.
+func (cc *ClientConnection) QuitSource() backend.ErrorSource {
+	return cc.connMgr.QuitSource()
+}
+
+// AdminClose closes the connection on behalf of the admin. If graceful is false, the running command is interrupted.
+func (cc *ClientConnection) AdminClose(graceful bool) {
+	cc.connMgr.AdminClose(graceful)
+	if graceful {
+		return
+	}
+	// Interrupt reading from the client so that Run() returns.
+	if err := cc.pkt.GracefulClose(); err != nil {
+		cc.logger.Warn("graceful close client IO error", zap.Error(err))
+	}
+}
+
 func (cc *ClientConnection) GracefulClose() {
 	cc.connMgr.GracefulClose()
 }
@@ -6,5 +6,6 @@ package proxy
 import "github.com/pingcap/TiProxy/lib/util/errors"
 
 var (
-	ErrCloseServer = errors.New("failed to close sqlserver")
+	ErrCloseServer  = errors.New("failed to close sqlserver")
+	ErrConnNotFound = errors.New("connection not found")
 )
@@ -3,6 +3,17 @@
 
 package net
 
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pingcap/TiProxy/lib/util/errors"
+)
+
 const (
 	AuthNativePassword      = "mysql_native_password"
 	AuthCachingSha2Password = "caching_sha2_password"
@@ -12,3 +23,102 @@ const (
 	AuthTiDBSessionToken    = "tidb_session_token"
 	AuthTiDBAuthToken       = "tidb_auth_token"
 )
+
+var (
+	ErrUnsupportedAuthPlugin = errors.New("unsupported auth plugin")
+	ErrInvalidPublicKey      = errors.New("invalid RSA public key")
+)
+
+// ScramblePassword computes the auth data of the password that is sent to the server with the salt from the server.
+func ScramblePassword(authPlugin string, salt []byte, password string) ([]byte, error) {
+	switch authPlugin {
+	case AuthNativePassword:
+		if len(password) == 0 {
+			return nil, nil
+		}
+		// SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+		stage1 := sha1.Sum([]byte(password))
+		stage2 := sha1.Sum(stage1[:])
+		h := sha1.New()
+		h.Write(salt)
+		h.Write(stage2[:])
+		return xorBytes(stage1[:], h.Sum(nil)), nil
+	case AuthCachingSha2Password:
+		if len(password) == 0 {
+			return nil, nil
+		}
+		// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + salt)
+		stage1 := sha256.Sum256([]byte(password))
+		stage2 := sha256.Sum256(stage1[:])
+		h := sha256.New()
+		h.Write(stage2[:])
+		h.Write(salt)
+		return xorBytes(stage1[:], h.Sum(nil)), nil
+	case AuthMySQLClearPassword:
+		return append([]byte(password), 0), nil
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedAuthPlugin, "%s", authPlugin)
+	}
+}
+
+// MarshalPublicKey encodes the public key in PEM, which is sent to the clients that request the public key
+// during caching_sha2_password full authentication.
+func MarshalPublicKey(key *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePublicKey parses the PEM public key sent by the server.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPublicKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPublicKey, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrInvalidPublicKey
+	}
+	return rsaKey, nil
+}
+
+// EncryptPassword encrypts the password with the public key of the server for caching_sha2_password full
+// authentication. The password is XORed with the salt before encryption. It should end with NUL as the clients send.
+func EncryptPassword(password, salt []byte, key *rsa.PublicKey) ([]byte, error) {
+	data, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, key, xorSalt(password, salt), nil)
+	return data, errors.WithStack(err)
+}
+
+// DecryptPassword is the reverse of EncryptPassword.
+func DecryptPassword(data, salt []byte, key *rsa.PrivateKey) ([]byte, error) {
+	password, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, data, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return xorSalt(password, salt), nil
+}
+
+// xorSalt returns a copy of data XORed with the repeated salt.
+func xorSalt(data, salt []byte) []byte {
+	result := make([]byte, len(data))
+	for i := range data {
+		result[i] = data[i]
+		if len(salt) > 0 {
+			result[i] ^= salt[i%len(salt)]
+		}
+	}
+	return result
+}
+
+func xorBytes(dst, src []byte) []byte {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+	return dst
+}
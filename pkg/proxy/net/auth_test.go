@@ -0,0 +1,61 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package net
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScramblePassword(t *testing.T) {
+	salt := make([]byte, 20)
+	for i := range salt {
+		salt[i] = byte(i + 1)
+	}
+	tests := []struct {
+		plugin string
+		expect string
+	}{
+		{AuthNativePassword, "9315cb762383621827109359a71c7be241589fb2"},
+		{AuthCachingSha2Password, "6196a891bc94a955e434f9c4b7d705fe97da916d472a4d11d8d17c54647ff958"},
+		{AuthMySQLClearPassword, hex.EncodeToString([]byte("123456\x00"))},
+	}
+	for _, test := range tests {
+		data, err := ScramblePassword(test.plugin, salt, "123456")
+		require.NoError(t, err, test.plugin)
+		require.Equal(t, test.expect, hex.EncodeToString(data), test.plugin)
+	}
+	// Empty passwords send empty auth data.
+	data, err := ScramblePassword(AuthNativePassword, salt, "")
+	require.NoError(t, err)
+	require.Empty(t, data)
+	_, err = ScramblePassword(AuthTiDBSM3Password, salt, "123456")
+	require.ErrorIs(t, err, ErrUnsupportedAuthPlugin)
+}
+
+func TestEncryptPassword(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	data, err := MarshalPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pubKey, err := ParsePublicKey(data)
+	require.NoError(t, err)
+	require.True(t, key.PublicKey.Equal(pubKey))
+	_, err = ParsePublicKey([]byte("dummy"))
+	require.ErrorIs(t, err, ErrInvalidPublicKey)
+
+	salt := []byte("01234567890123456789")
+	password := []byte("a long password that is longer than the salt\x00")
+	encrypted, err := EncryptPassword(password, salt, pubKey)
+	require.NoError(t, err)
+	decrypted, err := DecryptPassword(encrypted, salt, key)
+	require.NoError(t, err)
+	require.Equal(t, password, decrypted)
+	_, err = DecryptPassword(password, salt, key)
+	require.Error(t, err)
+}
@@ -16,8 +16,10 @@ import (
 )
 
 const (
-	ShaCommand   = 1
-	FastAuthFail = 4
+	ShaCommand       = 1
+	RequestPublicKey = 2
+	FastAuthSuccess  = 3
+	FastAuthFail     = 4
 )
 
 var (
@@ -55,6 +57,61 @@ func ParseInitialHandshake(data []byte) (Capability, string) {
 	return Capability(capability), serverVersion
 }
 
+// ParseInitialHandshakeAuth parses the salt and the default auth plugin from the initial handshake packet.
+func ParseInitialHandshakeAuth(data []byte) (salt []byte, authPlugin string) {
+	pos := 1 + bytes.IndexByte(data[1:], 0) + 1
+	// skip connection id
+	pos += 4
+	if pos <= 5 || len(data) < pos+8 {
+		return nil, ""
+	}
+	// salt first part
+	salt = append(salt, data[pos:pos+8]...)
+	// skip filter, capability lower 2 bytes, charset, status, capability upper 2 bytes
+	pos += 8 + 1 + 2 + 1 + 2 + 2
+	if len(data) <= pos {
+		return salt, ""
+	}
+	authDataLen := int(data[pos])
+	// skip auth data len, reserved
+	pos += 1 + 10
+	// salt second part, whose length is at least 13 and the last byte is [00]
+	part2Len := authDataLen - 8
+	if part2Len < 13 {
+		part2Len = 13
+	}
+	if len(data) < pos+part2Len {
+		return salt, ""
+	}
+	salt = append(salt, data[pos:pos+part2Len-1]...)
+	pos += part2Len
+	authPlugin = string(data[pos:])
+	if idx := bytes.IndexByte(data[pos:], 0); idx >= 0 {
+		authPlugin = string(data[pos : pos+idx])
+	}
+	return salt, authPlugin
+}
+
+// ParseAuthSwitchRequest parses the auth plugin and the salt from the auth switch request packet.
+func ParseAuthSwitchRequest(data []byte) (authPlugin string, salt []byte) {
+	idx := bytes.IndexByte(data[1:], 0)
+	if idx < 0 {
+		return string(data[1:]), nil
+	}
+	authPlugin = string(data[1 : 1+idx])
+	salt = bytes.TrimSuffix(data[1+idx+1:], []byte{0})
+	return authPlugin, salt
+}
+
+// ParseConnectionID parses the connection ID from the initial handshake packet.
+func ParseConnectionID(data []byte) uint64 {
+	pos := 1 + bytes.IndexByte(data[1:], 0) + 1
+	if pos <= 1 || len(data) < pos+4 {
+		return 0
+	}
+	return uint64(binary.LittleEndian.Uint32(data[pos : pos+4]))
+}
+
 // HandshakeResp indicates the response read from the client.
 type HandshakeResp struct {
 	Attrs      map[string]string
@@ -248,9 +305,13 @@ func MakeHandshakeResponse(resp *HandshakeResp) []byte {
 	return data[:pos]
 }
 
-// MakeChangeUser creates the data of COM_CHANGE_USER. It's only used for testing.
-func MakeChangeUser(username, db, authPlugin string, authData []byte) []byte {
-	length := 1 + len(username) + 1 + len(authData) + 1 + len(db) + 1
+// MakeChangeUser creates the data of COM_CHANGE_USER.
+func MakeChangeUser(username, db, authPlugin string, authData []byte, attrs map[string]string) []byte {
+	var attrBuf []byte
+	if len(attrs) > 0 {
+		attrBuf = dumpAttrs(attrs)
+	}
+	length := 1 + len(username) + 1 + len(authData) + 1 + len(db) + 1 + 2 + len(authPlugin) + 1 + 9 + len(attrBuf)
 	data := make([]byte, 0, length)
 	data = append(data, ComChangeUser.Byte())
 	data = append(data, []byte(username)...)
@@ -266,18 +327,38 @@ func MakeChangeUser(username, db, authPlugin string, authData []byte) []byte {
 
 	data = append(data, []byte(authPlugin)...)
 	data = append(data, 0x00)
+
+	if len(attrBuf) > 0 {
+		data = DumpLengthEncodedInt(data, uint64(len(attrBuf)))
+		data = append(data, attrBuf...)
+	}
 	return data
 }
 
 // ParseChangeUser parses the data of COM_CHANGE_USER.
-func ParseChangeUser(data []byte) (username, db string) {
+// The attrs are nil if the client doesn't send connection attributes.
+func ParseChangeUser(data []byte, capability Capability) (username, db string, attrs map[string]string) {
 	user, data := ParseNullTermString(data[1:])
 	username = string(user)
 	passLen := int(data[0])
 	data = data[passLen+1:]
-	dbName, _ := ParseNullTermString(data)
+	dbName, data := ParseNullTermString(data)
 	db = string(dbName)
-	// TODO: attrs
+	// character set
+	if len(data) < 2 {
+		return
+	}
+	data = data[2:]
+	if capability&ClientPluginAuth > 0 {
+		_, data = ParseNullTermString(data)
+	}
+	if capability&ClientConnectAttrs > 0 && len(data) > 0 {
+		if num, null, off := ParseLengthEncodedInt(data); !null && off+int(num) <= len(data) {
+			if parsed, err := parseAttrs(data[off : off+int(num)]); err == nil {
+				attrs = parsed
+			}
+		}
+	}
 	return
 }
 
@@ -4,6 +4,7 @@
 package net
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -24,3 +25,61 @@ func TestHandshakeResp(t *testing.T) {
 	require.Equal(t, resp1, resp2)
 	require.NoError(t, err)
 }
+
+func TestChangeUser(t *testing.T) {
+	attrs := map[string]string{"program_name": "app"}
+	data := MakeChangeUser("user", "db", AuthNativePassword, []byte("1234567890"), attrs)
+	user, db, parsedAttrs := ParseChangeUser(data, ClientPluginAuth|ClientConnectAttrs)
+	require.Equal(t, "user", user)
+	require.Equal(t, "db", db)
+	require.Equal(t, attrs, parsedAttrs)
+	// The attrs are ignored without the capability.
+	_, _, parsedAttrs = ParseChangeUser(data, ClientPluginAuth)
+	require.Nil(t, parsedAttrs)
+
+	data = MakeChangeUser("user", "", AuthNativePassword, nil, nil)
+	user, db, parsedAttrs = ParseChangeUser(data, ClientPluginAuth|ClientConnectAttrs)
+	require.Equal(t, "user", user)
+	require.Empty(t, db)
+	require.Nil(t, parsedAttrs)
+}
+
+func TestParseConnectionID(t *testing.T) {
+	data := []byte{10}
+	data = append(data, "5.7.25-TiDB"...)
+	data = append(data, 0, 0x78, 0x56, 0x34, 0x12, 1, 2, 3, 4, 5, 6, 7, 8, 0)
+	require.Equal(t, uint64(0x12345678), ParseConnectionID(data))
+	// Malformed packets.
+	require.Equal(t, uint64(0), ParseConnectionID(data[:10]))
+	require.Equal(t, uint64(0), ParseConnectionID(data[:14]))
+}
+
+func TestParseInitialHandshakeAuth(t *testing.T) {
+	salt := []byte("01234567890123456789")
+	data := []byte{10}
+	data = append(data, "5.7.25-TiDB"...)
+	data = append(data, 0, 1, 0, 0, 0)
+	data = append(data, salt[:8]...)
+	data = append(data, 0, 0xff, 0xff, 46, 2, 0, 0xff, 0xff, byte(len(salt)+1))
+	data = append(data, bytes.Repeat([]byte{0}, 10)...)
+	data = append(data, salt[8:]...)
+	data = append(data, 0)
+	data = append(data, AuthCachingSha2Password...)
+	data = append(data, 0)
+	parsedSalt, plugin := ParseInitialHandshakeAuth(data)
+	require.Equal(t, salt, parsedSalt)
+	require.Equal(t, AuthCachingSha2Password, plugin)
+	// Malformed packets.
+	parsedSalt, plugin = ParseInitialHandshakeAuth(data[:30])
+	require.Equal(t, salt[:8], parsedSalt)
+	require.Empty(t, plugin)
+
+	data = []byte{byte(AuthSwitchHeader)}
+	data = append(data, AuthNativePassword...)
+	data = append(data, 0)
+	data = append(data, salt...)
+	data = append(data, 0)
+	plugin, parsedSalt = ParseAuthSwitchRequest(data)
+	require.Equal(t, AuthNativePassword, plugin)
+	require.Equal(t, salt, parsedSalt)
+}
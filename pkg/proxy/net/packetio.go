@@ -126,6 +126,12 @@ func (p *PacketIO) RemoteAddr() net.Addr {
 	return p.conn.RemoteAddr()
 }
 
+// PeerAddr returns the address of the TCP peer. It differs from RemoteAddr() when the client connects
+// through a proxy that uses the proxy protocol, in which case it's the address of the proxy.
+func (p *PacketIO) PeerAddr() net.Addr {
+	return p.rawConn.RemoteAddr()
+}
+
 func (p *PacketIO) ResetSequence() {
 	p.sequence = 0
 }
@@ -61,7 +61,7 @@ func (p *PacketIO) WriteInitialHandshake(capability Capability, salt []byte, aut
 	return p.WritePacket(data, true)
 }
 
-// WriteSwitchRequest writes a switch request to the client. It's only for testing.
+// WriteSwitchRequest writes a switch request to the client.
 func (p *PacketIO) WriteSwitchRequest(authPlugin string, salt []byte) error {
 	length := 1 + len(authPlugin) + 1 + len(salt) + 1
 	data := make([]byte, 0, length)
@@ -97,17 +97,29 @@ func (p *PacketIO) ReadSSLRequestOrHandshakeResp() (pkt []byte, isSSL bool, err
 
 // WriteErrPacket writes an Error packet.
 func (p *PacketIO) WriteErrPacket(code uint16, message ...any) error {
+	return p.writeErrPacket(true, code, message...)
+}
+
+// WriteInitialErrPacket writes an Error packet in place of the initial handshake, e.g. when the client is rejected
+// once it connects. The packet has no SQL state because the client hasn't negotiated ClientProtocol41.
+func (p *PacketIO) WriteInitialErrPacket(code uint16, message ...any) error {
+	return p.writeErrPacket(false, code, message...)
+}
+
+func (p *PacketIO) writeErrPacket(withState bool, code uint16, message ...any) error {
 	data := make([]byte, 0, 9+len(message))
 	data = append(data, ErrHeader.Byte())
 	data = append(data, byte(code), byte(code>>8))
 
 	// TODO: ClientProtocol41 must be enabled for state
-	data = append(data, '#')
-	s, ok := mysql.MySQLState[code]
-	if !ok {
-		s = mysql.DEFAULT_MYSQL_STATE
+	if withState {
+		data = append(data, '#')
+		s, ok := mysql.MySQLState[code]
+		if !ok {
+			s = mysql.DEFAULT_MYSQL_STATE
+		}
+		data = append(data, s...)
 	}
-	data = append(data, s...)
 
 	var msg string
 	if format, ok := mysql.MySQLErrName[code]; ok {
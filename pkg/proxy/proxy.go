@@ -5,7 +5,9 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,11 +15,13 @@ import (
 	"github.com/pingcap/TiProxy/lib/util/errors"
 	"github.com/pingcap/TiProxy/lib/util/waitgroup"
 	"github.com/pingcap/TiProxy/pkg/manager/cert"
+	"github.com/pingcap/TiProxy/pkg/manager/namespace"
 	"github.com/pingcap/TiProxy/pkg/metrics"
 	"github.com/pingcap/TiProxy/pkg/proxy/backend"
 	"github.com/pingcap/TiProxy/pkg/proxy/client"
 	"github.com/pingcap/TiProxy/pkg/proxy/keepalive"
 	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"github.com/pingcap/tidb/parser/mysql"
 	"go.uber.org/zap"
 )
 
@@ -25,6 +29,9 @@ type serverState struct {
 	sync.RWMutex
 	healthyKeepAlive   config.KeepAlive
 	unhealthyKeepAlive config.KeepAlive
+	evacuateTxnTimeout time.Duration
+	idleDetachTimeout  time.Duration
+	defaultAuthPlugin  string
 	clients            map[uint64]*client.ClientConnection
 	connID             uint64
 	maxConnections     uint64
@@ -32,6 +39,8 @@ type serverState struct {
 	proxyProtocol      bool
 	gracefulWait       int
 	inShutdown         bool
+	// accessFilter is nil if the global access list is empty.
+	accessFilter *namespace.AccessFilter
 }
 
 type SQLServer struct {
@@ -40,6 +49,7 @@ type SQLServer struct {
 	certMgr           *cert.CertManager
 	hsHandler         backend.HandshakeHandler
 	requireBackendTLS bool
+	backendPool       *backend.BackendPool
 	wg                waitgroup.WaitGroup
 	cancelFunc        context.CancelFunc
 
@@ -50,11 +60,17 @@ type SQLServer struct {
 func NewSQLServer(logger *zap.Logger, cfg config.ProxyServer, certMgr *cert.CertManager, hsHandler backend.HandshakeHandler) (*SQLServer, error) {
 	var err error
 
+	// The pool checks whether its TLS connections use the latest certs.
+	var backendTLS func(namespace string) *tls.Config
+	if certMgr != nil {
+		backendTLS = certMgr.BackendTLS
+	}
 	s := &SQLServer{
 		logger:            logger,
 		certMgr:           certMgr,
 		hsHandler:         hsHandler,
 		requireBackendTLS: cfg.RequireBackendTLS,
+		backendPool:       backend.NewBackendPool(logger.Named("backend_pool"), cfg.BackendPoolSize, backendTLS),
 		mu: serverState{
 			connID:  0,
 			clients: make(map[uint64]*client.ClientConnection),
@@ -65,6 +81,7 @@ func NewSQLServer(logger *zap.Logger, cfg config.ProxyServer, certMgr *cert.Cert
 
 	s.listener, err = net.Listen("tcp", cfg.Addr)
 	if err != nil {
+		s.backendPool.Close()
 		return nil, err
 	}
 
@@ -72,14 +89,25 @@ func NewSQLServer(logger *zap.Logger, cfg config.ProxyServer, certMgr *cert.Cert
 }
 
 func (s *SQLServer) reset(cfg *config.ProxyServerOnline) {
+	// The config is validated before, so it won't fail unless there's a bug.
+	accessFilter, err := namespace.NewAccessFilter("", cfg.AccessList)
 	s.mu.Lock()
+	if err != nil {
+		s.logger.Error("build access list failed, keep the previous one", zap.Error(err))
+	} else {
+		s.mu.accessFilter = accessFilter
+	}
 	s.mu.tcpKeepAlive = cfg.FrontendKeepalive.Enabled
 	s.mu.maxConnections = cfg.MaxConnections
 	s.mu.proxyProtocol = cfg.ProxyProtocol != ""
 	s.mu.gracefulWait = cfg.GracefulWaitBeforeShutdown
 	s.mu.healthyKeepAlive = cfg.BackendHealthyKeepalive
 	s.mu.unhealthyKeepAlive = cfg.BackendUnhealthyKeepalive
+	s.mu.evacuateTxnTimeout = time.Duration(cfg.EvacuateTxnTimeout) * time.Second
+	s.mu.idleDetachTimeout = time.Duration(cfg.IdleDetachTimeout) * time.Second
+	s.mu.defaultAuthPlugin = cfg.DefaultAuthPlugin
 	s.mu.Unlock()
+	s.backendPool.SetSize(cfg.BackendPoolSize)
 }
 
 func (s *SQLServer) Run(ctx context.Context, cfgch <-chan *config.Config) {
@@ -126,6 +154,18 @@ func (s *SQLServer) Run(ctx context.Context, cfgch <-chan *config.Config) {
 }
 
 func (s *SQLServer) onConn(ctx context.Context, conn net.Conn) {
+	s.mu.RLock()
+	accessFilter, proxyProtocol := s.mu.accessFilter, s.mu.proxyProtocol
+	s.mu.RUnlock()
+	// With the proxy protocol, the real client address is checked after reading the proxy header in the handshake.
+	if !proxyProtocol {
+		if err := accessFilter.Check(conn.RemoteAddr().String()); err != nil {
+			s.rejectConn(conn, err)
+			return
+		}
+		accessFilter = nil
+	}
+
 	s.mu.Lock()
 	conns := uint64(len(s.mu.clients))
 	maxConns := s.mu.maxConnections
@@ -146,12 +186,18 @@ func (s *SQLServer) onConn(ctx context.Context, conn net.Conn) {
 	connID := s.mu.connID
 	s.mu.connID++
 	logger := s.logger.With(zap.Uint64("connID", connID), zap.String("client_addr", conn.RemoteAddr().String()))
-	clientConn := client.NewClientConnection(logger.Named("conn"), conn, s.certMgr.ServerTLS(), s.certMgr.SQLTLS(),
+	clientConn := client.NewClientConnection(logger.Named("conn"), conn, s.certMgr.FrontendTLS(), s.certMgr.SQLTLS(),
 		s.hsHandler, connID, &backend.BCConfig{
-			ProxyProtocol:      s.mu.proxyProtocol,
+			ProxyProtocol:      proxyProtocol,
 			RequireBackendTLS:  s.requireBackendTLS,
 			HealthyKeepAlive:   s.mu.healthyKeepAlive,
 			UnhealthyKeepAlive: s.mu.unhealthyKeepAlive,
+			EvacuateTxnTimeout: s.mu.evacuateTxnTimeout,
+			IdleDetachTimeout:  s.mu.idleDetachTimeout,
+			BackendPool:        s.backendPool,
+			DefaultAuthPlugin:  s.mu.defaultAuthPlugin,
+			AuthRSAKey:         s.certMgr.AuthRSAKey(),
+			AccessFilter:       accessFilter,
 		})
 	s.mu.clients[connID] = clientConn
 	s.mu.Unlock()
@@ -179,12 +225,73 @@ func (s *SQLServer) onConn(ctx context.Context, conn net.Conn) {
 	clientConn.Run(ctx)
 }
 
+// rejectConn sends the host not privileged error in place of the initial handshake and closes the connection.
+func (s *SQLServer) rejectConn(conn net.Conn, err error) {
+	addr := conn.RemoteAddr().String()
+	host, _, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil {
+		host = addr
+	}
+	pkt := pnet.NewPacketIO(conn, s.logger)
+	writeErr := pkt.WriteInitialErrPacket(mysql.ErrHostNotPrivileged, host)
+	s.logger.Info("reject the client address", zap.String("client_addr", addr), zap.Error(err),
+		zap.NamedError("write_err", writeErr), zap.NamedError("close_err", pkt.Close()))
+}
+
 func (s *SQLServer) IsClosing() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.mu.inShutdown
 }
 
+// ConnInfos returns the snapshots of all the client connections, sorted by the connection ID.
+func (s *SQLServer) ConnInfos() []*backend.ConnInfo {
+	s.mu.RLock()
+	infos := make([]*backend.ConnInfo, 0, len(s.mu.clients))
+	for _, conn := range s.mu.clients {
+		infos = append(infos, conn.ConnInfo())
+	}
+	s.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectionID < infos[j].ConnectionID
+	})
+	return infos
+}
+
+// CloseResult is the result of closing a connection through the admin API.
+type CloseResult struct {
+	ConnectionID uint64 `json:"id"`
+	// Closed is false if the connection is still waiting for the end of the transaction.
+	Closed     bool   `json:"closed"`
+	QuitSource string `json:"quit-source,omitempty"`
+}
+
+// CloseConn closes the connection and waits until it's closed or the wait time exceeds.
+// If graceful is true, the connection is closed after the current transaction ends.
+// Otherwise, the running command is interrupted and the connection is closed immediately.
+func (s *SQLServer) CloseConn(connID uint64, graceful bool, wait time.Duration) (*CloseResult, error) {
+	s.mu.RLock()
+	conn, ok := s.mu.clients[connID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errors.Wrapf(ErrConnNotFound, "connection %d", connID)
+	}
+	conn.AdminClose(graceful)
+	s.logger.Info("closing connection by admin", zap.Uint64("connID", connID), zap.Bool("graceful", graceful))
+
+	result := &CloseResult{ConnectionID: connID}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-conn.Done():
+		// Run() has returned, so it's safe to read the quit source now.
+		result.Closed = true
+		result.QuitSource = conn.QuitSource().String()
+	case <-timer.C:
+	}
+	return result, nil
+}
+
 // Graceful shutdown doesn't close the listener but rejects new connections.
 // Whether this affects NLB is to be tested.
 func (s *SQLServer) gracefulShutdown() {
@@ -240,5 +347,6 @@ func (s *SQLServer) Close() error {
 	s.mu.RUnlock()
 
 	s.wg.Wait()
+	s.backendPool.Close()
 	return errors.Collect(ErrCloseServer, errs...)
 }
@@ -4,6 +4,8 @@
 package proxy
 
 import (
+	"context"
+	"encoding/binary"
 	"net"
 	"testing"
 	"time"
@@ -12,13 +14,15 @@ import (
 	"github.com/pingcap/TiProxy/lib/util/logger"
 	"github.com/pingcap/TiProxy/pkg/proxy/backend"
 	"github.com/pingcap/TiProxy/pkg/proxy/client"
+	pnet "github.com/pingcap/TiProxy/pkg/proxy/net"
+	"github.com/pingcap/tidb/parser/mysql"
 	"github.com/stretchr/testify/require"
 )
 
 func TestGracefulShutdown(t *testing.T) {
 	// Graceful shutdown finishes immediately if there's no connection.
 	lg, _ := logger.CreateLoggerForTest(t)
-	hsHandler := backend.NewDefaultHandshakeHandler(nil, "")
+	hsHandler := backend.NewDefaultHandshakeHandler(nil, "", nil)
 	server, err := NewSQLServer(lg, config.ProxyServer{
 		ProxyServerOnline: config.ProxyServerOnline{
 			GracefulWaitBeforeShutdown: 10,
@@ -98,3 +102,77 @@ func TestGracefulShutdown(t *testing.T) {
 	case <-finish:
 	}
 }
+
+func TestAccessList(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	server, err := NewSQLServer(lg, config.ProxyServer{
+		Addr: "127.0.0.1:0",
+		ProxyServerOnline: config.ProxyServerOnline{
+			AccessList: config.AccessList{Deny: []string{"127.0.0.1"}},
+		},
+	}, nil, backend.NewDefaultHandshakeHandler(nil, "", nil))
+	require.NoError(t, err)
+	server.Run(context.Background(), nil)
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+	})
+
+	// The client receives an error instead of the initial handshake.
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	require.NoError(t, err)
+	pkt := pnet.NewPacketIO(conn, lg)
+	data, err := pkt.ReadPacket()
+	require.NoError(t, err)
+	require.Equal(t, pnet.ErrHeader.Byte(), data[0])
+	require.Equal(t, uint16(mysql.ErrHostNotPrivileged), binary.LittleEndian.Uint16(data[1:]))
+	require.Contains(t, string(data[3:]), "127.0.0.1")
+	_, err = pkt.ReadPacket()
+	require.Error(t, err)
+	require.NoError(t, pkt.Close())
+
+	// The access list is reloaded with the config.
+	server.reset(&config.ProxyServerOnline{})
+	server.mu.RLock()
+	require.Nil(t, server.mu.accessFilter)
+	server.mu.RUnlock()
+}
+
+func TestCloseConn(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	hsHandler := &backend.CustomHandshakeHandler{}
+	server, err := NewSQLServer(lg, config.ProxyServer{}, nil, hsHandler)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+	})
+	_, err = server.CloseConn(1, false, time.Second)
+	require.ErrorIs(t, err, ErrConnNotFound)
+
+	// The client doesn't respond to the initial handshake, so the connection keeps running.
+	cliConn, err := net.Dial("tcp", server.listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, cliConn.Close())
+	})
+	conn, err := server.listener.Accept()
+	require.NoError(t, err)
+	clientConn := client.NewClientConnection(lg, conn, nil, nil, hsHandler, 1, &backend.BCConfig{})
+	server.mu.Lock()
+	server.mu.clients[1] = clientConn
+	server.mu.Unlock()
+	go func() {
+		clientConn.Run(context.Background())
+		require.NoError(t, clientConn.Close())
+	}()
+
+	// The graceful close waits for the current command and the wait time exceeds.
+	result, err := server.CloseConn(1, true, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, result.Closed)
+	// The connection is closed as soon as it's interrupted.
+	startTime := time.Now()
+	result, err = server.CloseConn(1, false, 10*time.Second)
+	require.NoError(t, err)
+	require.True(t, result.Closed)
+	require.Less(t, time.Since(startTime), 5*time.Second)
+}
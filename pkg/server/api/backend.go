@@ -0,0 +1,56 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	mgrns "github.com/pingcap/TiProxy/pkg/manager/namespace"
+	"github.com/pingcap/TiProxy/pkg/manager/router"
+)
+
+// BackendDrainStatus shows the progress of the draining backends, keyed by namespace names.
+func (h *HTTPServer) BackendDrainStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.mgr.ns.DrainStatuses())
+}
+
+// BackendDrain migrates the connections away from the backend and stops routing new connections to it.
+func (h *HTTPServer) BackendDrain(c *gin.Context) {
+	h.drainBackend(c, true)
+}
+
+// BackendUndrain makes the backend routable again.
+func (h *HTTPServer) BackendUndrain(c *gin.Context) {
+	h.drainBackend(c, false)
+}
+
+func (h *HTTPServer) drainBackend(c *gin.Context, drain bool) {
+	addr := c.Param("addr")
+	if addr == "" {
+		c.JSON(http.StatusBadRequest, "bad addr parameter")
+		return
+	}
+
+	if err := h.mgr.ns.DrainBackend(c.Query("namespace"), addr, drain); err != nil {
+		if errors.Is(err, router.ErrBackendNotFound) || errors.Is(err, mgrns.ErrNamespaceNotFound) {
+			c.JSON(http.StatusNotFound, err.Error())
+			return
+		}
+		c.Errors = append(c.Errors, &gin.Error{
+			Type: gin.ErrorTypePrivate,
+			Err:  errors.Errorf("can not drain backend[%s]: %+v", addr, err),
+		})
+		c.JSON(http.StatusInternalServerError, "can not drain backend")
+		return
+	}
+	c.JSON(http.StatusOK, h.mgr.ns.DrainStatuses())
+}
+
+func (h *HTTPServer) registerBackend(group *gin.RouterGroup) {
+	group.GET("/drain", h.BackendDrainStatus)
+	group.POST("/:addr/drain", h.BackendDrain)
+	group.POST("/:addr/undrain", h.BackendUndrain)
+}
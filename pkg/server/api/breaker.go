@@ -0,0 +1,36 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BreakerList shows the circuit breakers of all namespaces that enable them.
+func (h *HTTPServer) BreakerList(c *gin.Context) {
+	c.JSON(http.StatusOK, h.mgr.ns.BreakerStatuses())
+}
+
+func (h *HTTPServer) BreakerGet(c *gin.Context) {
+	ns := c.Param("namespace")
+	if ns == "" {
+		c.JSON(http.StatusBadRequest, "bad namespace parameter")
+		return
+	}
+
+	for _, status := range h.mgr.ns.BreakerStatuses() {
+		if status.Namespace == ns {
+			c.JSON(http.StatusOK, status)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, "the namespace doesn't exist or doesn't enable the circuit breaker")
+}
+
+func (h *HTTPServer) registerBreaker(group *gin.RouterGroup) {
+	group.GET("/", h.BreakerList)
+	group.GET("/:namespace", h.BreakerGet)
+}
@@ -35,7 +35,7 @@ func (h *HTTPServer) ConfigSet(c *gin.Context) {
 }
 
 func (h *HTTPServer) ConfigGet(c *gin.Context) {
-	c.TOML(http.StatusOK, h.mgr.cfg.GetConfig())
+	c.TOML(http.StatusOK, h.mgr.cfg.GetConfig().Redact())
 }
 
 func (h *HTTPServer) registerConfig(group *gin.RouterGroup) {
@@ -0,0 +1,81 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	"github.com/pingcap/TiProxy/pkg/proxy"
+	"github.com/pingcap/TiProxy/pkg/proxy/backend"
+)
+
+const (
+	// defCloseConnWait is the default duration to wait for a connection to be closed.
+	defCloseConnWait = 10 * time.Second
+)
+
+// ConnectionList lists the client connections. The connections can be filtered by namespace, user and backend.
+func (h *HTTPServer) ConnectionList(c *gin.Context) {
+	ns, user, backendAddr := c.Query("namespace"), c.Query("user"), c.Query("backend")
+	infos := h.proxy.ConnInfos()
+	filtered := make([]*backend.ConnInfo, 0, len(infos))
+	for _, info := range infos {
+		if (ns != "" && info.Namespace != ns) || (user != "" && info.User != user) ||
+			(backendAddr != "" && info.BackendAddr != backendAddr) {
+			continue
+		}
+		filtered = append(filtered, info)
+	}
+	c.JSON(http.StatusOK, filtered)
+}
+
+// ConnectionClose closes the connection after its transaction ends.
+func (h *HTTPServer) ConnectionClose(c *gin.Context) {
+	h.closeConnection(c, true)
+}
+
+// ConnectionKill closes the connection immediately, even if it's executing a command.
+func (h *HTTPServer) ConnectionKill(c *gin.Context) {
+	h.closeConnection(c, false)
+}
+
+func (h *HTTPServer) closeConnection(c *gin.Context, graceful bool) {
+	connID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "bad connection id parameter")
+		return
+	}
+	wait := defCloseConnWait
+	if waitStr := c.Query("wait"); waitStr != "" {
+		if wait, err = time.ParseDuration(waitStr); err != nil || wait < 0 {
+			c.JSON(http.StatusBadRequest, "bad wait parameter")
+			return
+		}
+	}
+
+	result, err := h.proxy.CloseConn(connID, graceful, wait)
+	if err != nil {
+		if errors.Is(err, proxy.ErrConnNotFound) {
+			c.JSON(http.StatusNotFound, "connection not found")
+			return
+		}
+		c.Errors = append(c.Errors, &gin.Error{
+			Type: gin.ErrorTypePrivate,
+			Err:  errors.Errorf("can not close connection[%d]: %+v", connID, err),
+		})
+		c.JSON(http.StatusInternalServerError, "can not close connection")
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *HTTPServer) registerConnection(group *gin.RouterGroup) {
+	group.GET("", h.ConnectionList)
+	group.POST("/:id/close", h.ConnectionClose)
+	group.POST("/:id/kill", h.ConnectionKill)
+}
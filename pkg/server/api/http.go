@@ -169,6 +169,10 @@ func (h *HTTPServer) register(group *gin.RouterGroup, cfg config.API, nsmgr *mgr
 		}
 		h.registerNamespace(adminGroup.Group("namespace"))
 		h.registerConfig(adminGroup.Group("config"))
+		h.registerBreaker(adminGroup.Group("breaker"))
+		h.registerConnection(adminGroup.Group("connections"))
+		h.registerBackend(adminGroup.Group("backend"))
+		h.registerRebalance(adminGroup.Group("rebalance"))
 	}
 
 	h.registerMetrics(group.Group("metrics"))
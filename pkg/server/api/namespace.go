@@ -9,6 +9,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/pingcap/TiProxy/lib/config"
 	"github.com/pingcap/TiProxy/lib/util/errors"
+	mgrcfg "github.com/pingcap/TiProxy/pkg/manager/config"
+	mgrns "github.com/pingcap/TiProxy/pkg/manager/namespace"
 )
 
 func (h *HTTPServer) NamespaceGet(c *gin.Context) {
@@ -92,12 +94,18 @@ func (h *HTTPServer) NamespaceCommit(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, "failed to list all namespaces")
 			return
 		}
+		for _, ns := range nss {
+			ns_names = append(ns_names, ns.Namespace)
+		}
 	} else {
 		nss = make([]*config.Namespace, len(ns_names))
 		nss_delete = make([]bool, len(ns_names))
 		for i, ns_name := range ns_names {
 			ns, err := h.mgr.cfg.GetNamespace(c, ns_name)
-			if err != nil {
+			if errors.Is(err, mgrcfg.ErrNoResults) {
+				// The namespace is deleted.
+				ns, nss_delete[i] = &config.Namespace{Namespace: ns_name}, true
+			} else if err != nil {
 				c.Errors = append(c.Errors, &gin.Error{
 					Type: gin.ErrorTypePrivate,
 					Err:  errors.Errorf("failed to get namespace[%s]: %+v", ns_name, err),
@@ -106,7 +114,6 @@ func (h *HTTPServer) NamespaceCommit(c *gin.Context) {
 				return
 			}
 			nss[i] = ns
-			nss_delete[i] = false
 		}
 	}
 
@@ -119,6 +126,16 @@ func (h *HTTPServer) NamespaceCommit(c *gin.Context) {
 		return
 	}
 
+	// Notify other TiProxy instances to reload the namespaces.
+	if err := h.mgr.cfg.CommitNamespaces(c, ns_names); err != nil {
+		c.Errors = append(c.Errors, &gin.Error{
+			Type: gin.ErrorTypePrivate,
+			Err:  errors.Errorf("failed to commit namespaces: %v, %+v", ns_names, err),
+		})
+		c.JSON(http.StatusInternalServerError, "failed to commit namespaces")
+		return
+	}
+
 	c.JSON(http.StatusOK, "")
 }
 
@@ -136,9 +153,27 @@ func (h *HTTPServer) NamespaceList(c *gin.Context) {
 	c.JSON(http.StatusOK, nscs)
 }
 
+// NamespaceMatch explains which namespace and rule a connection with the given information matches.
+func (h *HTTPServer) NamespaceMatch(c *gin.Context) {
+	info := &mgrns.MatchInfo{}
+	if c.ShouldBindJSON(info) != nil {
+		c.JSON(http.StatusBadRequest, "bad match info json")
+		return
+	}
+
+	_, result, ok := h.mgr.ns.MatchNamespace(info)
+	if !ok {
+		c.JSON(http.StatusNotFound, "no namespace matched")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 func (h *HTTPServer) registerNamespace(group *gin.RouterGroup) {
 	group.GET("/", h.NamespaceList)
 	group.POST("/commit", h.NamespaceCommit)
+	group.POST("/match", h.NamespaceMatch)
 	group.GET("/:namespace", h.NamespaceGet)
 	group.PUT("/:namespace", h.NamespaceUpsert)
 	group.DELETE("/:namespace", h.NamespaceRemove)
@@ -0,0 +1,81 @@
+// Copyright 2023 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/TiProxy/lib/util/errors"
+	mgrns "github.com/pingcap/TiProxy/pkg/manager/namespace"
+	"github.com/pingcap/TiProxy/pkg/manager/router"
+)
+
+// RebalanceStatus shows whether the rebalancing of each namespace is paused.
+func (h *HTTPServer) RebalanceStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.mgr.ns.RebalanceStatuses())
+}
+
+// RebalancePause stops migrating connections between backends in the namespace.
+func (h *HTTPServer) RebalancePause(c *gin.Context) {
+	h.pauseRebalance(c, true)
+}
+
+// RebalanceResume resumes migrating connections between backends in the namespace.
+func (h *HTTPServer) RebalanceResume(c *gin.Context) {
+	h.pauseRebalance(c, false)
+}
+
+func (h *HTTPServer) pauseRebalance(c *gin.Context, pause bool) {
+	ns := c.Param("namespace")
+	if ns == "" {
+		c.JSON(http.StatusBadRequest, "bad namespace parameter")
+		return
+	}
+
+	if err := h.mgr.ns.PauseRebalance(ns, pause); err != nil {
+		h.handleRebalanceError(c, ns, err)
+		return
+	}
+	c.JSON(http.StatusOK, h.mgr.ns.RebalanceStatuses())
+}
+
+// RebalancePreview shows the migrations that the next round of rebalancing would make without making them.
+func (h *HTTPServer) RebalancePreview(c *gin.Context) {
+	ns := c.Param("namespace")
+	if ns == "" {
+		c.JSON(http.StatusBadRequest, "bad namespace parameter")
+		return
+	}
+
+	migrations, err := h.mgr.ns.PreviewRebalance(ns)
+	if err != nil {
+		h.handleRebalanceError(c, ns, err)
+		return
+	}
+	c.JSON(http.StatusOK, migrations)
+}
+
+func (h *HTTPServer) handleRebalanceError(c *gin.Context, ns string, err error) {
+	if errors.Is(err, mgrns.ErrNamespaceNotFound) {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, router.ErrRebalanceNotSupported) {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Errors = append(c.Errors, &gin.Error{
+		Type: gin.ErrorTypePrivate,
+		Err:  errors.Errorf("can not rebalance namespace[%s]: %+v", ns, err),
+	})
+	c.JSON(http.StatusInternalServerError, "can not rebalance namespace")
+}
+
+func (h *HTTPServer) registerRebalance(group *gin.RouterGroup) {
+	group.GET("/", h.RebalanceStatus)
+	group.POST("/:namespace/pause", h.RebalancePause)
+	group.POST("/:namespace/resume", h.RebalanceResume)
+	group.GET("/:namespace/preview", h.RebalancePreview)
+}
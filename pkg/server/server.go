@@ -15,8 +15,10 @@ import (
 	"github.com/pingcap/TiProxy/pkg/manager/infosync"
 	"github.com/pingcap/TiProxy/pkg/manager/logger"
 	mgrns "github.com/pingcap/TiProxy/pkg/manager/namespace"
+	"github.com/pingcap/TiProxy/pkg/manager/router"
 	"github.com/pingcap/TiProxy/pkg/metrics"
 	"github.com/pingcap/TiProxy/pkg/proxy"
+	"github.com/pingcap/TiProxy/pkg/proxy/auth"
 	"github.com/pingcap/TiProxy/pkg/proxy/backend"
 	"github.com/pingcap/TiProxy/pkg/sctx"
 	"github.com/pingcap/TiProxy/pkg/server/api"
@@ -93,8 +95,24 @@ func NewServer(ctx context.Context, sctx *sctx.Context) (srv *Server, err error)
 		}
 	}
 
+	// setup namespace store
+	{
+		var store mgrcfg.KVStore
+		if srv.InfoSyncer != nil {
+			// Namespaces are shared by all the TiProxy instances in the cluster.
+			store = mgrcfg.NewEtcdKVStore(srv.InfoSyncer.GetEtcdClient(), lg.Named("nsstore"))
+		} else if store, err = mgrcfg.NewFileKVStore(cfg.Workdir); err != nil {
+			return
+		}
+		if err = srv.ConfigManager.SetKVStore(store); err != nil {
+			return
+		}
+	}
+
 	// setup namespace manager
 	{
+		// Watch before listing so that no commit is missed.
+		nsch := srv.ConfigManager.WatchNamespace()
 		nscs, nerr := srv.ConfigManager.ListAllNamespace(ctx)
 		if nerr != nil {
 			err = errors.WithStack(nerr)
@@ -107,7 +125,7 @@ func NewServer(ctx context.Context, sctx *sctx.Context) (srv *Server, err error)
 				Namespace: "default",
 				Backend: config.BackendNamespace{
 					Instances:    []string{},
-					SelectorType: "random",
+					SelectorType: router.SelectorTypeScore,
 				},
 			}
 			if err = srv.ConfigManager.SetNamespace(ctx, nsc.Namespace, nsc); err != nil {
@@ -116,11 +134,37 @@ func NewServer(ctx context.Context, sctx *sctx.Context) (srv *Server, err error)
 			nscs = append(nscs, nsc)
 		}
 
-		err = srv.NamespaceManager.Init(lg.Named("nsmgr"), nscs, srv.InfoSyncer, srv.Http)
+		// The rebalance config is applied to the routers once they are built.
+		cfgch := srv.ConfigManager.WatchConfig()
+		srv.NamespaceManager.SetRebalanceConfig(&cfg.Rebalance)
+		srv.wg.Run(func() {
+			for cfg := range cfgch {
+				srv.NamespaceManager.SetRebalanceConfig(&cfg.Rebalance)
+			}
+		})
+
+		err = srv.NamespaceManager.Init(lg.Named("nsmgr"), nscs, srv.InfoSyncer, srv.Http, srv.CertManager,
+			cfg.Proxy.Labels[router.ZoneLabel])
 		if err != nil {
 			err = errors.WithStack(err)
 			return
 		}
+
+		// Rebuild the namespaces once they are committed by other TiProxy instances.
+		nslg := lg.Named("nswatch")
+		srv.wg.Run(func() {
+			for ev := range nsch {
+				nsc, deleted := ev.Namespace, ev.Namespace == nil
+				if deleted {
+					nsc = &config.Namespace{Namespace: ev.Name}
+				}
+				if err := srv.NamespaceManager.CommitNamespaces([]*config.Namespace{nsc}, []bool{deleted}); err != nil {
+					nslg.Warn("failed to reload namespace", zap.String("namespace", ev.Name), zap.Error(err))
+				} else {
+					nslg.Info("namespace reloaded", zap.String("namespace", ev.Name), zap.Bool("deleted", deleted))
+				}
+			}
+		})
 	}
 
 	// setup proxy server
@@ -129,7 +173,17 @@ func NewServer(ctx context.Context, sctx *sctx.Context) (srv *Server, err error)
 		if handler != nil {
 			hsHandler = handler
 		} else {
-			hsHandler = backend.NewDefaultHandshakeHandler(srv.NamespaceManager, cfg.Proxy.ServerVersion)
+			var verifier backend.CredentialVerifier
+			v, verr := auth.NewVerifier(lg.Named("auth"), cfg.Security.ProxyAuth, srv.CertManager.ClusterTLS())
+			if verr != nil {
+				err = errors.WithStack(verr)
+				return
+			}
+			// Avoid a non-nil interface holding a nil pointer.
+			if v != nil {
+				verifier = v
+			}
+			hsHandler = backend.NewDefaultHandshakeHandler(srv.NamespaceManager, cfg.Proxy.ServerVersion, verifier)
 		}
 		srv.Proxy, err = proxy.NewSQLServer(lg.Named("proxy"), cfg.Proxy, srv.CertManager, hsHandler)
 		if err != nil {
@@ -162,12 +216,13 @@ func (s *Server) Close() error {
 	if s.NamespaceManager != nil {
 		errs = append(errs, s.NamespaceManager.Close())
 	}
-	if s.InfoSyncer != nil {
-		errs = append(errs, s.InfoSyncer.Close())
-	}
+	// The namespace store shares the etcd client of the info syncer, so close it first.
 	if s.ConfigManager != nil {
 		errs = append(errs, s.ConfigManager.Close())
 	}
+	if s.InfoSyncer != nil {
+		errs = append(errs, s.InfoSyncer.Close())
+	}
 	if s.MetricsManager != nil {
 		s.MetricsManager.Close()
 	}